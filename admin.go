@@ -0,0 +1,477 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	pfsense "github.com/Asort97/vpnBot/clients/pfSense"
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// adminRole — роль администратора. Владельцу доступно всё, остальным — команды своей роли.
+type adminRole string
+
+const (
+	roleOwner   adminRole = "owner"
+	roleSupport adminRole = "support"
+	roleFinance adminRole = "finance"
+)
+
+// admins — администраторы бота и их роли (ADMINS="id:role,id:role").
+// Им же приходят уведомления о событиях, см. notify.go.
+var admins = map[int64]adminRole{}
+
+// adminCommandRoles — какие роли, кроме владельца, могут выполнять служебную команду.
+// Пустой список — только владелец.
+var adminCommandRoles = map[string][]adminRole{
+	"user":           {roleSupport, roleFinance},
+	"resendcert":     {roleSupport},
+	"revoke":         {roleSupport},
+	"unrevoke":       {roleSupport},
+	"migrate":        {roleSupport},
+	"transfer_force": {roleSupport},
+	"grant":          {roleFinance},
+	"refund":         {roleFinance},
+	"promo_add":      {roleFinance},
+	"promo_list":     {roleFinance},
+	"promo_del":      {roleFinance},
+	"plans":          {roleFinance},
+	"stats":          {roleFinance},
+	"broadcast":      {roleSupport},
+	"notify":         {roleSupport, roleFinance},
+	"ban":            {roleSupport, roleFinance},
+	"suspend":        {roleSupport, roleFinance},
+	"unban":          {roleSupport, roleFinance},
+	"bans":           {roleSupport, roleFinance},
+	"refreport":      {roleSupport, roleFinance},
+	"plan_set":       {},
+	"plan_hide":      {},
+	"plan_show":      {},
+	"audit":          {},
+}
+
+// loadAdmins читает список администраторов из ADMINS. Без переменной служебные команды
+// и уведомления администраторам отключены.
+func loadAdmins() {
+	v := strings.TrimSpace(os.Getenv("ADMINS"))
+	if v == "" {
+		log.Printf("ADMINS is not set, admin commands and notifications are disabled")
+		return
+	}
+	parsed := make(map[int64]adminRole)
+	for _, part := range strings.Split(v, ",") {
+		idStr, roleStr, _ := strings.Cut(strings.TrimSpace(part), ":")
+		id, err := strconv.ParseInt(strings.TrimSpace(idStr), 10, 64)
+		role := adminRole(strings.ToLower(strings.TrimSpace(roleStr)))
+		if role == "" {
+			role = roleOwner
+		}
+		if err != nil || (role != roleOwner && role != roleSupport && role != roleFinance) {
+			log.Printf("invalid ADMINS entry %q, skipped", part)
+			continue
+		}
+		parsed[id] = role
+	}
+	if len(parsed) == 0 {
+		log.Printf("ADMINS=%q has no valid entries, admin commands and notifications are disabled", v)
+		return
+	}
+	admins = parsed
+}
+
+// roleCan сообщает, может ли роль выполнять служебную команду; владелец может всё
+func roleCan(role adminRole, command string) bool {
+	if role == roleOwner {
+		return true
+	}
+	for _, r := range adminCommandRoles[command] {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+func isAdmin(id int64) bool {
+	_, ok := admins[id]
+	return ok
+}
+
+// hasRole сообщает, может ли администратор действовать в одной из ролей; владелец может всё
+func hasRole(id int64, roles ...adminRole) bool {
+	role, ok := admins[id]
+	if !ok {
+		return false
+	}
+	if role == roleOwner {
+		return true
+	}
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// authorizeAdminCommand проверяет права на служебную команду и пишет её в журнал.
+// Сообщения не-администраторов молча игнорируются, как и раньше.
+func authorizeAdminCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, roles []adminRole) bool {
+	if !isAdmin(msg.From.ID) {
+		log.Printf("admin command /%s from non-admin %d ignored", msg.Command(), msg.From.ID)
+		return false
+	}
+	allowed := hasRole(msg.From.ID, roles...)
+	entry := sqlite.AuditEntry{
+		AdminID: strconv.FormatInt(msg.From.ID, 10),
+		Role:    string(admins[msg.From.ID]),
+		Action:  msg.Command(),
+		Args:    msg.CommandArguments(),
+		Denied:  !allowed,
+	}
+	if err := sqliteClient.RecordAudit(entry); err != nil {
+		log.Printf("RecordAudit error: %v", err)
+	}
+	if !allowed {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "⛔️ Недостаточно прав для этой команды"))
+	}
+	return allowed
+}
+
+// adminTargetUser разбирает ID пользователя из аргументов команды и проверяет, что он есть в БД
+func adminTargetUser(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, usage string) (string, []string, bool) {
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: "+usage))
+		return "", nil, false
+	}
+	if _, err := strconv.ParseInt(args[0], 10, 64); err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ ID пользователя должен быть числом"))
+		return "", nil, false
+	}
+	if sqliteClient.IsNewUser(args[0]) {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Пользователь %s не найден", args[0])))
+		return "", nil, false
+	}
+	return args[0], args[1:], true
+}
+
+// handleUserCommand — /user <id>, карточка пользователя
+func handleUserCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	userID, _, ok := adminTargetUser(bot, msg, "/user <id>")
+	if !ok {
+		return
+	}
+	card, err := userCard(userID)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ %v", err)))
+		return
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, card)
+	reply.ParseMode = "HTML"
+	bot.Send(reply)
+}
+
+// userCard — карточка пользователя в HTML: подписка, сертификаты, семья, контакты и последние платежи
+func userCard(userID string) (string, error) {
+	user, err := sqliteClient.GetUser(userID)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	fmt.Fprintf(&b, "👤 <b>Пользователь</b> <code>%s</code>\n\n", userID)
+	if expiresAt, err := sqliteClient.GetExpiresAt(userID, now); err == nil && !expiresAt.IsZero() {
+		fmt.Fprintf(&b, "📅 Подписка до: %s (%d дн.)\n", expiresAt.UTC().Format("02.01.2006 15:04 UTC"), user.Days)
+	} else {
+		b.WriteString("📅 Подписка: не активна\n")
+	}
+	if user.InGrace() {
+		fmt.Fprintf(&b, "⏳ Льготный период до: %s\n", user.GraceEndsAt().UTC().Format("02.01.2006 15:04 UTC"))
+	}
+	if user.Frozen() {
+		fmt.Fprintf(&b, "⏸ Заморожен с: %s\n", user.FrozenAt)
+	}
+	if user.Banned(now) {
+		period := "бессрочно"
+		if until := user.BanEndsAt(); !until.IsZero() {
+			period = "до " + until.UTC().Format("02.01.2006 15:04 UTC")
+		}
+		fmt.Fprintf(&b, "⛔ Заблокирован %s администратором <code>%s</code>: %s\n", period, html.EscapeString(user.BannedBy), html.EscapeString(user.BanReason))
+	}
+	if user.Suspended() {
+		fmt.Fprintf(&b, "🚫 Доступ приостановлен с %s (<code>%s</code>)\n", user.SuspendedAt, html.EscapeString(user.SuspendedBy))
+	}
+	certRef := user.CertRef
+	if certRef == "" {
+		certRef = "—"
+	}
+	fmt.Fprintf(&b, "🔐 CertRef: <code>%s</code>, устройств: %d из %d\n", html.EscapeString(certRef), len(user.Devices)+1, userDeviceLimit(user))
+	if user.FamilyOwner != "" {
+		fmt.Fprintf(&b, "👨‍👩‍👧 Участник семьи <code>%s</code>\n", user.FamilyOwner)
+	} else if members := sqliteClient.FamilyMembers(userID); len(members) > 0 {
+		fmt.Fprintf(&b, "👨‍👩‍👧 Владелец семьи: %d из %d участников\n", len(members), user.FamilyLimit)
+	}
+	if user.Email != "" || user.Phone != "" {
+		fmt.Fprintf(&b, "✉️ Контакт для чеков: %s %s\n", html.EscapeString(user.Email), html.EscapeString(user.Phone))
+	}
+	if user.AutopayEnabled {
+		fmt.Fprintf(&b, "🔁 Автопродление: %s\n", html.EscapeString(user.AutopayPlanID))
+	}
+	if user.ReferredBy != "" {
+		fmt.Fprintf(&b, "🤝 Приглашён: <code>%s</code>\n", user.ReferredBy)
+	}
+	fmt.Fprintf(&b, "👥 Пригласил: %d\n", user.ReferralsCount)
+
+	var payments []string
+	for _, p := range sqliteClient.GetPayments() {
+		if p.UserID != userID {
+			continue
+		}
+		date := p.CreatedAt
+		if at, err := time.Parse(time.RFC3339, p.CreatedAt); err == nil {
+			date = at.Format("02.01.2006")
+		}
+		line := fmt.Sprintf("• %s %s, %s, %d дн.", date, formatPaymentAmount(p), html.EscapeString(p.PlanID), p.Days)
+		if p.Refunded {
+			line += " (возврат)"
+		}
+		payments = append(payments, line)
+		if len(payments) == 5 {
+			break
+		}
+	}
+	if len(payments) > 0 {
+		b.WriteString("\n💳 <b>Последние платежи:</b>\n" + strings.Join(payments, "\n"))
+	}
+	return b.String(), nil
+}
+
+// Статусы пользователя для панели и API
+const (
+	statusActive        = "active"
+	statusGrace         = "grace"
+	statusFrozen        = "frozen"
+	statusExpired       = "expired"
+	statusNoCertificate = "no_certificate"
+	statusBlocked       = "blocked"
+	statusBanned        = "banned"
+	statusSuspended     = "suspended"
+)
+
+var userStatusTitles = map[string]string{
+	statusActive:        "активен",
+	statusGrace:         "льготный период",
+	statusFrozen:        "заморожен",
+	statusExpired:       "подписка закончилась",
+	statusNoCertificate: "нет сертификата",
+	statusBlocked:       "заблокировал бота",
+	statusBanned:        "заблокирован администратором",
+	statusSuspended:     "доступ приостановлен",
+}
+
+// userStatus — статус пользователя; активность считается так же, как в /stats
+func userStatus(users map[string]sqlite.UserData, u sqlite.UserData) string {
+	switch {
+	case u.Banned(time.Now()):
+		return statusBanned
+	case u.Suspended():
+		return statusSuspended
+	case u.BlockedAt != "":
+		return statusBlocked
+	case u.Frozen():
+		return statusFrozen
+	case u.InGrace():
+		return statusGrace
+	case userActive(users, u):
+		return statusActive
+	case u.CertRef != "":
+		return statusExpired
+	}
+	return statusNoCertificate
+}
+
+// certStatus — сертификат пользователя и его состояние в pfSense
+type certStatus struct {
+	Ref        string `json:"cert_ref"`
+	Device     string `json:"device"`
+	ValidUntil string `json:"valid_until,omitempty"`
+	Revoked    bool   `json:"revoked"`
+	Error      string `json:"error,omitempty"`
+}
+
+// userCertificates запрашивает в pfSense срок действия и отзыв каждого сертификата пользователя
+func userCertificates(pfsenseClient *pfsense.PfSenseClient, u sqlite.UserData) []certStatus {
+	refs := []certStatus{{Ref: u.CertRef, Device: "основное"}}
+	for _, d := range u.Devices {
+		refs = append(refs, certStatus{Ref: d.CertRef, Device: d.Name})
+	}
+
+	var certs []certStatus
+	for _, c := range refs {
+		if c.Ref == "" {
+			continue
+		}
+		certID, _, err := pfsenseClient.GetCertificateIDByRefid(c.Ref)
+		if err != nil {
+			c.Error = err.Error()
+			certs = append(certs, c)
+			continue
+		}
+		if _, until, _, _, err := pfsenseClient.GetDateOfCertificate(certID); err == nil {
+			c.ValidUntil = until
+		} else {
+			c.Error = err.Error()
+		}
+		if revoked, err := pfsenseClient.IsCertificateRevoked(c.Ref); err == nil {
+			c.Revoked = revoked
+		} else {
+			c.Error = err.Error()
+		}
+		certs = append(certs, c)
+	}
+	return certs
+}
+
+// handleGrantCommand — /grant <id> <days>, начисление дней администратором
+func handleGrantCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	userID, rest, ok := adminTargetUser(bot, msg, "/grant <id> <days>")
+	if !ok {
+		return
+	}
+	if len(rest) != 1 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /grant <id> <days>"))
+		return
+	}
+	days, err := strconv.ParseInt(rest[0], 10, 64)
+	if err != nil || days <= 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Количество дней должно быть положительным числом"))
+		return
+	}
+
+	if err := grantDays(bot, userID, days); err != nil {
+		log.Printf("AddDays error for grant to %s: %v", userID, err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось начислить дни: %v", err)))
+		return
+	}
+	balance, _ := sqliteClient.GetDays(userID)
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Пользователю %s начислено %d дней, баланс: %d", userID, days, balance)))
+}
+
+// grantDays начисляет дни от имени администратора, возвращает доступ и уведомляет пользователя
+func grantDays(bot *tgbotapi.BotAPI, userID string, days int64) error {
+	if err := sqliteClient.AddDays(userID, days); err != nil {
+		return err
+	}
+	resumeIfFrozen(userID)
+	scheduleUnrevokeUser(userID)
+
+	if chatID, err := strconv.ParseInt(userID, 10, 64); err == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("🎁 Вам начислено %d дней.", days)))
+	}
+	return nil
+}
+
+// deductDays списывает дни от имени администратора. Как и при возврате платежа, если баланс
+// обнулился, сертификаты отзываются сразу.
+func deductDays(bot *tgbotapi.BotAPI, userID string, days int64) (int64, error) {
+	remaining, err := sqliteClient.DeductDays(userID, days, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	if remaining <= 0 {
+		scheduleRevokeUser(userID)
+	}
+	if chatID, err := strconv.ParseInt(userID, 10, 64); err == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("ℹ️ Администратор списал с баланса %d дн. Осталось: %d дн.", days, remaining)))
+	}
+	return remaining, nil
+}
+
+// handleAdminRevokeCommand — /revoke <id> и /unrevoke <id>: отзыв и восстановление
+// всех сертификатов пользователя (и его семьи) без изменения баланса
+func handleAdminRevokeCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, revoke bool) {
+	usage := "/unrevoke <id>"
+	if revoke {
+		usage = "/revoke <id>"
+	}
+	userID, _, ok := adminTargetUser(bot, msg, usage)
+	if !ok {
+		return
+	}
+	if revoke {
+		if err := suspendUser(userID, strconv.FormatInt(msg.From.ID, 10)); err != nil {
+			log.Printf("suspendUser error for %s: %v", userID, err)
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось приостановить доступ: %v", err)))
+			return
+		}
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Доступ пользователя %s приостановлен, сертификаты поставлены в очередь на отзыв", userID)))
+		return
+	}
+	restored, err := resumeUser(userID)
+	if err != nil {
+		log.Printf("resumeUser error for %s: %v", userID, err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось возобновить доступ: %v", err)))
+		return
+	}
+	if !restored {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Приостановка пользователя %s снята. Сертификаты не восстановлены: подписка не активна или заморожена", userID)))
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Приостановка пользователя %s снята, сертификаты поставлены в очередь на восстановление", userID)))
+}
+
+// handleResendCertCommand — /resendcert <id>, повторно отправляет пользователю основной .ovpn
+func handleResendCertCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, pfsenseClient *pfsense.PfSenseClient) {
+	userID, _, ok := adminTargetUser(bot, msg, "/resendcert <id>")
+	if !ok {
+		return
+	}
+	certRef, err := sqliteClient.GetCertRef(userID)
+	if err != nil || certRef == "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ У пользователя %s нет сертификата", userID)))
+		return
+	}
+	chatID, _ := strconv.ParseInt(userID, 10, 64)
+	if err := sendCertificate(certRef, userID, chatID, 0, chatID, pfsenseClient, bot, getSession(chatID)); err != nil {
+		log.Printf("resend certificate to %s error: %v", userID, err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось отправить сертификат: %v", err)))
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Сертификат отправлен пользователю %s", userID)))
+}
+
+// handleAuditCommand — /audit [N], последние действия администраторов
+func handleAuditCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	limit := 20
+	if n, err := strconv.Atoi(strings.TrimSpace(msg.CommandArguments())); err == nil && n > 0 {
+		limit = n
+	}
+	entries, err := sqliteClient.ListAudit(limit)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось прочитать журнал: %v", err)))
+		return
+	}
+	if len(entries) == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Журнал пуст"))
+		return
+	}
+	lines := []string{"📜 <b>Журнал действий</b>"}
+	for _, e := range entries {
+		line := fmt.Sprintf("%s <code>%s</code> (%s): /%s %s", e.At, e.AdminID, e.Role, e.Action, html.EscapeString(e.Args))
+		if e.Denied {
+			line += " ⛔️"
+		}
+		lines = append(lines, line)
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, strings.Join(lines, "\n"))
+	reply.ParseMode = "HTML"
+	bot.Send(reply)
+}
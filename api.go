@@ -0,0 +1,547 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	pfsense "github.com/Asort97/vpnBot/clients/pfSense"
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+//go:embed api/openapi.yaml
+var openAPISpec []byte
+
+const (
+	// apiDefaultLimit и apiMaxLimit — размер страницы списка пользователей
+	apiDefaultLimit = 100
+	apiMaxLimit     = 1000
+	// apiMaxBody — ограничение размера тела запроса
+	apiMaxBody = 1 << 16
+)
+
+// apiClient — внешняя система с токеном из API_TOKENS. Права роли те же, что у администраторов.
+type apiClient struct {
+	Name string
+	Role adminRole
+}
+
+// managementAPI — HTTP API для интеграций (CRM, бухгалтерия). Спецификация — api/openapi.yaml.
+type managementAPI struct {
+	bot     *tgbotapi.BotAPI
+	pfsense *pfsense.PfSenseClient
+	tokens  map[string]apiClient
+}
+
+// startManagementAPI запускает API на API_ADDR. Без API_ADDR или API_TOKENS API отключено.
+func startManagementAPI(bot *tgbotapi.BotAPI, pfsenseClient *pfsense.PfSenseClient) {
+	addr := strings.TrimSpace(os.Getenv("API_ADDR"))
+	if addr == "" {
+		return
+	}
+	tokens := loadAPITokens(os.Getenv("API_TOKENS"))
+	if len(tokens) == 0 {
+		log.Printf("API_ADDR is set but API_TOKENS has no valid entries, management API is disabled")
+		return
+	}
+
+	api := &managementAPI{bot: bot, pfsense: pfsenseClient, tokens: tokens}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(openAPISpec)
+	})
+	mux.HandleFunc("GET /api/v1/users", api.require("user", api.handleListUsers))
+	mux.HandleFunc("GET /api/v1/users/{id}", api.require("user", api.handleGetUser))
+	mux.HandleFunc("GET /api/v1/users/{id}/certificates", api.require("user", api.handleCertificates))
+	mux.HandleFunc("GET /api/v1/users/{id}/referrals", api.require("user", api.handleReferrals))
+	mux.HandleFunc("POST /api/v1/users/{id}/grant", api.require("grant", api.handleGrant))
+	mux.HandleFunc("POST /api/v1/users/{id}/suspend", api.require("revoke", api.handleSuspend))
+	mux.HandleFunc("POST /api/v1/users/{id}/resume", api.require("unrevoke", api.handleSuspend))
+	mux.HandleFunc("POST /api/v1/users/{id}/config/regenerate", api.require("resendcert", api.handleRegenerateConfig))
+	mux.HandleFunc("GET /api/v1/payments", api.require("user", api.handleListPayments))
+	// уведомления платёжного провайдера приходят без токена: источник проверяет сам провайдер
+	mux.HandleFunc("POST /api/v1/payments/notifications", api.handlePaymentNotification)
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("management API listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil {
+			log.Printf("management API stopped: %v", err)
+		}
+	}()
+}
+
+// loadAPITokens разбирает API_TOKENS="name:token[:role],...". Без роли клиент получает owner.
+func loadAPITokens(v string) map[string]apiClient {
+	tokens := make(map[string]apiClient)
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		role := roleOwner
+		if len(fields) == 3 {
+			role = adminRole(strings.ToLower(strings.TrimSpace(fields[2])))
+		}
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" || len(fields[1]) < 16 ||
+			(role != roleOwner && role != roleSupport && role != roleFinance) {
+			log.Printf("invalid API_TOKENS entry for %q, skipped (token must be at least 16 characters)", fields[0])
+			continue
+		}
+		tokens[fields[1]] = apiClient{Name: fields[0], Role: role}
+	}
+	return tokens
+}
+
+type apiHandler func(w http.ResponseWriter, r *http.Request, c apiClient)
+
+// require проверяет токен и права клиента. Изменяющие запросы и отказы пишутся в журнал действий.
+func (api *managementAPI) require(command string, next apiHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, ok := api.client(r)
+		if !ok {
+			writeAPIError(w, http.StatusUnauthorized, "invalid or missing bearer token")
+			return
+		}
+		allowed := roleCan(client.Role, command)
+		if r.Method != http.MethodGet || !allowed {
+			if err := sqliteClient.RecordAudit(sqlite.AuditEntry{
+				AdminID: "api:" + client.Name,
+				Role:    string(client.Role),
+				Action:  "api_" + command,
+				Args:    r.Method + " " + r.URL.RequestURI(),
+				Denied:  !allowed,
+			}); err != nil {
+				log.Printf("RecordAudit error: %v", err)
+			}
+		}
+		if !allowed {
+			writeAPIError(w, http.StatusForbidden, fmt.Sprintf("role %s may not %s", client.Role, command))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, apiMaxBody)
+		next(w, r, client)
+	}
+}
+
+func (api *managementAPI) client(r *http.Request) (apiClient, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return apiClient{}, false
+	}
+	for known, client := range api.tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			return client, true
+		}
+	}
+	return apiClient{}, false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("API response error: %v", err)
+	}
+}
+
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// decodeAPIBody читает JSON-тело запроса; пустое тело допустимо
+func decodeAPIBody(r *http.Request, v any) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+// ---- представление данных ----
+
+type apiBalance struct {
+	Days        int64  `json:"days"`
+	PaidUntil   string `json:"paid_until,omitempty"` // собственный баланс пользователя
+	ExpiresAt   string `json:"expires_at,omitempty"` // с учётом семьи: подписка владельца
+	InGrace     bool   `json:"in_grace"`
+	GraceEndsAt string `json:"grace_ends_at,omitempty"`
+	Frozen      bool   `json:"frozen"`
+	FrozenAt    string `json:"frozen_at,omitempty"`
+}
+
+type apiUser struct {
+	ID             string     `json:"id"`
+	Status         string     `json:"status"`
+	CreatedAt      string     `json:"created_at,omitempty"`
+	Email          string     `json:"email,omitempty"`
+	Phone          string     `json:"phone,omitempty"`
+	CertRef        string     `json:"cert_ref,omitempty"`
+	Devices        int        `json:"devices"`
+	DeviceLimit    int        `json:"device_limit"`
+	FamilyOwner    string     `json:"family_owner,omitempty"`
+	FamilyMembers  []string   `json:"family_members,omitempty"`
+	ReferredBy     string     `json:"referred_by,omitempty"`
+	ReferralsCount int        `json:"referrals_count"`
+	AutopayEnabled bool       `json:"autopay_enabled"`
+	AutopayPlanID  string     `json:"autopay_plan_id,omitempty"`
+	Balance        apiBalance `json:"balance"`
+}
+
+type apiReferral struct {
+	ID        string `json:"id"`
+	CreatedAt string `json:"created_at,omitempty"`
+	Status    string `json:"status"`
+	Paid      bool   `json:"paid"` // есть хотя бы один платёж без возврата
+
+	Bonus       string `json:"bonus,omitempty"`        // pending, rewarded или rejected
+	BonusDays   int64  `json:"bonus_days,omitempty"`   // начислено пригласившему
+	BonusReason string `json:"bonus_reason,omitempty"` // почему бонус отклонён
+	BonusVoided bool   `json:"bonus_voided,omitempty"` // бонус аннулирован при блокировке
+}
+
+func toAPIUser(users map[string]sqlite.UserData, id string, u sqlite.UserData, now time.Time) apiUser {
+	au := apiUser{
+		ID:             id,
+		Status:         userStatus(users, u),
+		CreatedAt:      u.CreatedAt,
+		Email:          u.Email,
+		Phone:          u.Phone,
+		CertRef:        u.CertRef,
+		Devices:        len(u.Devices) + 1,
+		DeviceLimit:    userDeviceLimit(u),
+		FamilyOwner:    u.FamilyOwner,
+		ReferredBy:     u.ReferredBy,
+		ReferralsCount: u.ReferralsCount,
+		AutopayEnabled: u.AutopayEnabled,
+		AutopayPlanID:  u.AutopayPlanID,
+		Balance: apiBalance{
+			Days:      u.Days,
+			PaidUntil: u.PaidUntil,
+			InGrace:   u.InGrace(),
+			Frozen:    u.Frozen(),
+			FrozenAt:  u.FrozenAt,
+		},
+	}
+	if u.CertRef == "" {
+		au.Devices = len(u.Devices)
+	}
+	if u.FamilyOwner == "" {
+		au.FamilyMembers = sqliteClient.FamilyMembers(id)
+	}
+	if owner, ok := users[u.FamilyOwner]; ok {
+		au.Balance.Days = owner.Days
+	}
+	if expiresAt, err := sqliteClient.GetExpiresAt(id, now); err == nil && !expiresAt.IsZero() {
+		au.Balance.ExpiresAt = expiresAt.UTC().Format(time.RFC3339)
+	}
+	if au.Balance.InGrace {
+		au.Balance.GraceEndsAt = u.GraceEndsAt().UTC().Format(time.RFC3339)
+	}
+	return au
+}
+
+// ---- обработчики ----
+
+func (api *managementAPI) handleListUsers(w http.ResponseWriter, r *http.Request, c apiClient) {
+	query := r.URL.Query()
+	q := strings.ToLower(strings.TrimSpace(query.Get("q")))
+	status := query.Get("status")
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = apiDefaultLimit
+	}
+	if limit > apiMaxLimit {
+		limit = apiMaxLimit
+	}
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	users := sqliteClient.GetAllUsers()
+	now := time.Now()
+	ids := make([]string, 0, len(users))
+	for id, u := range users {
+		if q != "" && !userMatches(id, u, q) {
+			continue
+		}
+		if status != "" && userStatus(users, u) != status {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	// новые пользователи первыми, порядок стабилен между страницами
+	sort.Slice(ids, func(i, j int) bool {
+		a, b := users[ids[i]].CreatedAt, users[ids[j]].CreatedAt
+		if a != b {
+			return a > b
+		}
+		return ids[i] < ids[j]
+	})
+
+	total := len(ids)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	result := make([]apiUser, 0, end-offset)
+	for _, id := range ids[offset:end] {
+		result = append(result, toAPIUser(users, id, users[id], now))
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"total": total, "users": result})
+}
+
+// apiUserOr404 находит пользователя по {id} или отвечает 404
+func apiUserOr404(w http.ResponseWriter, r *http.Request) (map[string]sqlite.UserData, string, sqlite.UserData, bool) {
+	id := r.PathValue("id")
+	users := sqliteClient.GetAllUsers()
+	u, ok := users[id]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "user "+id+" not found")
+	}
+	return users, id, u, ok
+}
+
+func (api *managementAPI) handleGetUser(w http.ResponseWriter, r *http.Request, c apiClient) {
+	users, id, u, ok := apiUserOr404(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, toAPIUser(users, id, u, time.Now()))
+}
+
+func (api *managementAPI) handleCertificates(w http.ResponseWriter, r *http.Request, c apiClient) {
+	_, _, u, ok := apiUserOr404(w, r)
+	if !ok {
+		return
+	}
+	certs := userCertificates(api.pfsense, u)
+	if certs == nil {
+		certs = []certStatus{}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"certificates": certs})
+}
+
+func (api *managementAPI) handleReferrals(w http.ResponseWriter, r *http.Request, c apiClient) {
+	users, id, _, ok := apiUserOr404(w, r)
+	if !ok {
+		return
+	}
+	paid := make(map[string]bool)
+	for _, p := range sqliteClient.GetPayments() {
+		if !p.Refunded {
+			paid[p.UserID] = true
+		}
+	}
+	referrals := []apiReferral{}
+	for refID, u := range users {
+		if u.ReferredBy != id {
+			continue
+		}
+		ar := apiReferral{ID: refID, CreatedAt: u.CreatedAt, Status: userStatus(users, u), Paid: paid[refID]}
+		if ref, ok := sqliteClient.GetReferral(refID); ok {
+			ar.Bonus = string(ref.Status)
+			ar.BonusDays = ref.Days
+			ar.BonusReason = ref.Reason
+			ar.BonusVoided = ref.Voided
+		}
+		referrals = append(referrals, ar)
+	}
+	sort.Slice(referrals, func(i, j int) bool { return referrals[i].CreatedAt > referrals[j].CreatedAt })
+	writeJSON(w, http.StatusOK, map[string]any{"referrals": referrals})
+}
+
+func (api *managementAPI) handleListPayments(w http.ResponseWriter, r *http.Request, c apiClient) {
+	query := r.URL.Query()
+	userID := query.Get("user_id")
+	var from, to time.Time
+	for name, target := range map[string]*time.Time{"from": &from, "to": &to} {
+		if v := query.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeAPIError(w, http.StatusBadRequest, name+" must be an RFC 3339 timestamp")
+				return
+			}
+			*target = t
+		}
+	}
+
+	payments := []sqlite.PaymentRecord{}
+	for _, p := range sqliteClient.GetPayments() {
+		if userID != "" && p.UserID != userID {
+			continue
+		}
+		if !from.IsZero() || !to.IsZero() {
+			at, err := time.Parse(time.RFC3339, p.CreatedAt)
+			if err != nil || (!from.IsZero() && at.Before(from)) || (!to.IsZero() && !at.Before(to)) {
+				continue
+			}
+		}
+		payments = append(payments, p)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"payments": payments})
+}
+
+// handlePaymentNotification принимает HTTP-уведомление платёжного провайдера. Провайдер
+// проверяет адрес отправителя и перезапрашивает платёж, поэтому статусу из тела не доверяем.
+// Здесь обрабатываются только автоплатежи: обычную оплату подтверждает сам пользователь
+// кнопкой проверки в своей сессии бота.
+func (api *managementAPI) handlePaymentNotification(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, apiMaxBody))
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "request body too large")
+		return
+	}
+	pay, err := paymentProvider.VerifyNotification(body, r.RemoteAddr)
+	if err != nil {
+		log.Printf("payment notification rejected: %v", err)
+		writeAPIError(w, http.StatusForbidden, "notification rejected")
+		return
+	}
+
+	for userID, user := range sqliteClient.GetAllUsers() {
+		if user.AutopayPending != pay.ID {
+			continue
+		}
+		chatID, err := strconv.ParseInt(userID, 10, 64)
+		if err != nil {
+			log.Printf("failed to parse chat id %s: %v", userID, err)
+			break
+		}
+		settleAutopay(sqliteClient, api.bot, userID, chatID, resolvePlanFromMetadata(pay.Metadata, nil), pay)
+		break
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (api *managementAPI) handleGrant(w http.ResponseWriter, r *http.Request, c apiClient) {
+	users, id, _, ok := apiUserOr404(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Days int64 `json:"days"`
+	}
+	if err := decodeAPIBody(r, &body); err != nil || body.Days <= 0 {
+		writeAPIError(w, http.StatusBadRequest, `body must be {"days": <positive integer>}`)
+		return
+	}
+	if err := grantDays(api.bot, id, body.Days); err != nil {
+		log.Printf("AddDays error for API grant to %s: %v", id, err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	users = sqliteClient.GetAllUsers()
+	writeJSON(w, http.StatusOK, toAPIUser(users, id, users[id], time.Now()))
+}
+
+// handleSuspend приостанавливает (suspend) или возобновляет (resume) доступ пользователя и его
+// семьи, как /revoke и /unrevoke. Баланс не меняется.
+func (api *managementAPI) handleSuspend(w http.ResponseWriter, r *http.Request, c apiClient) {
+	_, id, _, ok := apiUserOr404(w, r)
+	if !ok {
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/suspend") {
+		if err := suspendUser(id, "api:"+c.Name); err != nil {
+			log.Printf("suspendUser error for API suspend of %s: %v", id, err)
+			writeAPIError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+		return
+	}
+	restored, err := resumeUser(id)
+	if err != nil {
+		log.Printf("resumeUser error for API resume of %s: %v", id, err)
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !restored {
+		// приостановка снята, но оплаченного времени нет или подписка заморожена
+		writeJSON(w, http.StatusOK, map[string]string{"status": "inactive"})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+// handleRegenerateConfig продлевает основной сертификат пользователя в pfSense (создаёт, если его
+// нет) и возвращает свежий .ovpn; с send_to_user=true конфиг также отправляется пользователю в бот
+func (api *managementAPI) handleRegenerateConfig(w http.ResponseWriter, r *http.Request, c apiClient) {
+	_, id, _, ok := apiUserOr404(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		SendToUser bool `json:"send_to_user"`
+	}
+	if err := decodeAPIBody(r, &body); err != nil {
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	certRef, _, _, err := ensureUserCertificate(api.pfsense, id)
+	if err != nil {
+		log.Printf("ensureUserCertificate error for API config of %s: %v", id, err)
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	if err := api.pfsense.RenewExistingCertificateByRefid(certRef); err != nil {
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	ovpn, err := api.pfsense.GenerateOVPN(certRef, "", "213.21.200.205")
+	if err != nil {
+		writeAPIError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	fileName := fmt.Sprintf("Cert%s_permanent.ovpn", id)
+	if body.SendToUser {
+		// обычное сообщение вместо sendCertificate: сессии пользователей принадлежат горутине
+		// обработки апдейтов, из HTTP-обработчика их трогать нельзя
+		chatID, _ := strconv.ParseInt(id, 10, 64)
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileBytes{Name: fileName, Bytes: ovpn})
+		doc.Caption = "🔐 <b>VPN-конфигурация перевыпущена.</b>\n\nИмпортируйте новый файл в OpenVPN вместо старого."
+		doc.ParseMode = "HTML"
+		if _, err := api.bot.Send(doc); err != nil {
+			log.Printf("send regenerated config to %s error: %v", id, err)
+			writeAPIError(w, http.StatusBadGateway, "config regenerated but not delivered: "+err.Error())
+			return
+		}
+	}
+
+	var validUntil string
+	if certID, _, err := api.pfsense.GetCertificateIDByRefid(certRef); err == nil {
+		_, validUntil, _, _, _ = api.pfsense.GetDateOfCertificate(certID)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"cert_ref":    certRef,
+		"valid_until": validUntil,
+		"file_name":   fileName,
+		"config":      string(ovpn),
+		"sent":        body.SendToUser,
+	})
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// bansListLimit — сколько последних блокировок показывает /bans, чтобы уложиться в лимит сообщения
+const bansListLimit = 50
+
+// bannedText — единственный ответ заблокированному пользователю
+func bannedText(user sqlite.UserData) string {
+	text := "⛔ Ваш аккаунт заблокирован."
+	if until := user.BanEndsAt(); !until.IsZero() {
+		text = fmt.Sprintf("⛔ Ваш аккаунт заблокирован до %s.", until.Local().Format("02.01.2006 15:04"))
+	}
+	return text + "\n\nЕсли вы считаете это ошибкой, напишите в поддержку: " + supportContact
+}
+
+func userBanned(userID string) bool {
+	user, err := sqliteClient.GetUser(userID)
+	return err == nil && user.Banned(time.Now())
+}
+
+// rejectBanned отвечает заблокированному пользователю и сообщает, что апдейт обрабатывать не нужно.
+// Администраторов не блокирует, чтобы нельзя было случайно лишить себя доступа к командам.
+func rejectBanned(bot *tgbotapi.BotAPI, fromID, chatID int64, cq *tgbotapi.CallbackQuery) bool {
+	if isAdmin(fromID) {
+		return false
+	}
+	user, err := sqliteClient.GetUser(strconv.FormatInt(fromID, 10))
+	if err != nil || !user.Banned(time.Now()) {
+		return false
+	}
+	if cq != nil {
+		ackCallback(bot, cq, "⛔ Аккаунт заблокирован")
+	}
+	bot.Send(tgbotapi.NewMessage(chatID, bannedText(user)))
+	return true
+}
+
+// ownCertRefs — сертификаты самого пользователя без участников семьи: блокировка не отключает
+// тех, кто пользуется его подпиской
+func ownCertRefs(user sqlite.UserData) []string {
+	refs := []string{user.CertRef}
+	for _, d := range user.Devices {
+		refs = append(refs, d.CertRef)
+	}
+	return refs
+}
+
+// lockedCertRefs — сертификаты, которые не восстанавливаются ни при пополнении, ни командой
+// /unrevoke: заблокированных пользователей и приостановленных вместе с их семьёй
+func lockedCertRefs() map[string]bool {
+	now := time.Now()
+	refs := make(map[string]bool)
+	for id, u := range sqliteClient.GetAllUsers() {
+		locked := ownCertRefs(u)
+		switch {
+		case u.Suspended():
+			family, err := sqliteClient.GetFamilyCertRefs(id)
+			if err != nil {
+				log.Printf("failed to find certrefs of user %s: %v", id, err)
+			}
+			locked = append(locked, family...)
+		case !u.Banned(now):
+			continue
+		}
+		for _, ref := range locked {
+			if ref != "" {
+				refs[ref] = true
+			}
+		}
+	}
+	return refs
+}
+
+// accessAllowed сообщает, должны ли сейчас работать сертификаты пользователя: есть оплаченное
+// время (своё или владельца семьи), подписка не заморожена, доступ не приостановлен — ни у него,
+// ни у владельца семьи, — а сам пользователь не заблокирован
+func accessAllowed(userID string) bool {
+	users := sqliteClient.GetAllUsers()
+	user, ok := users[userID]
+	if !ok || user.Banned(time.Now()) || !userActive(users, user) {
+		return false
+	}
+	owner := user
+	if user.FamilyOwner != "" {
+		owner = users[user.FamilyOwner]
+	}
+	return !user.Frozen() && !owner.Frozen() && !user.Suspended() && !owner.Suspended()
+}
+
+// enforceAccess вызывается после выпуска сертификата: если доступа у пользователя сейчас нет,
+// все его сертификаты, включая только что выпущенный, отзываются
+func enforceAccess(userID string) {
+	if accessAllowed(userID) {
+		return
+	}
+	user, err := sqliteClient.GetUser(userID)
+	if err != nil {
+		log.Printf("GetUser error for %s: %v", userID, err)
+		return
+	}
+	for _, ref := range ownCertRefs(user) {
+		if ref != "" {
+			scheduleRevoke(ref)
+		}
+	}
+}
+
+// suspendUser приостанавливает доступ пользователя и его семьи (/revoke, панель, API): сертификаты
+// отзываются, а пополнения и бонусы не вернут их, пока доступ не возобновят
+func suspendUser(userID, by string) error {
+	if _, err := sqliteClient.SetSuspended(userID, by, true, time.Now()); err != nil {
+		return err
+	}
+	scheduleRevokeUser(userID)
+	return nil
+}
+
+// resumeUser снимает приостановку. Как и после блокировки, доступ возвращается, только если у
+// пользователя есть оплаченное время и подписка не заморожена.
+func resumeUser(userID string) (restored bool, err error) {
+	if _, err := sqliteClient.SetSuspended(userID, "", false, time.Now()); err != nil {
+		return false, err
+	}
+	if !accessAllowed(userID) {
+		return false, nil
+	}
+	scheduleUnrevokeUser(userID)
+	return true, nil
+}
+
+// handleBanCommand — /ban <id> <причина> и /suspend <id> <дней> <причина>: бессрочная и временная
+// блокировка. Сертификаты пользователя отзываются, бонусы за приглашения аннулируются.
+func handleBanCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, temporary bool) {
+	usage := "/ban <id> <причина>"
+	if temporary {
+		usage = "/suspend <id> <дней> <причина>"
+	}
+	userID, rest, ok := adminTargetUser(bot, msg, usage)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	var until time.Time
+	if temporary {
+		if len(rest) == 0 {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: "+usage))
+			return
+		}
+		days, err := strconv.Atoi(rest[0])
+		if err != nil || days <= 0 {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Количество дней должно быть положительным числом"))
+			return
+		}
+		until = now.Add(time.Duration(days) * sqlite.Day)
+		rest = rest[1:]
+	}
+	reason := strings.Join(rest, " ")
+	if reason == "" {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Укажите причину блокировки. Использование: "+usage))
+		return
+	}
+	if id, err := strconv.ParseInt(userID, 10, 64); err == nil && isAdmin(id) {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Нельзя заблокировать администратора"))
+		return
+	}
+
+	voided, err := sqliteClient.Ban(userID, reason, strconv.FormatInt(msg.From.ID, 10), until, now)
+	if err != nil {
+		log.Printf("Ban error for %s: %v", userID, err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось заблокировать: %v", err)))
+		return
+	}
+	user, _ := sqliteClient.GetUser(userID)
+	revokeAllCertificates(ownCertRefs(user), nil)
+	log.Printf("user %s banned by %d until %q: %s", userID, msg.From.ID, user.BanUntil, reason)
+
+	if chatID, err := strconv.ParseInt(userID, 10, 64); err == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, bannedText(user)))
+	}
+
+	period := "бессрочно"
+	if temporary {
+		period = "до " + until.UTC().Format("02.01.2006 15:04 UTC")
+	}
+	reply := fmt.Sprintf("⛔ Пользователь %s заблокирован %s, сертификаты поставлены в очередь на отзыв", userID, period)
+	if voided > 0 {
+		reply += fmt.Sprintf("\n🎁 Аннулировано бонусных дней за приглашения: %d", voided)
+	}
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, reply))
+}
+
+// handleUnbanCommand — /unban <id>, снимает блокировку и возвращает доступ, если подписка активна
+func handleUnbanCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	userID, _, ok := adminTargetUser(bot, msg, "/unban <id>")
+	if !ok {
+		return
+	}
+	unbanned, err := sqliteClient.Unban(userID)
+	if err != nil {
+		log.Printf("Unban error for %s: %v", userID, err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось снять блокировку: %v", err)))
+		return
+	}
+	if !unbanned {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("Пользователь %s не заблокирован", userID)))
+		return
+	}
+	restored := restoreAfterBan(bot, userID)
+	reply := fmt.Sprintf("✅ Блокировка пользователя %s снята", userID)
+	if restored {
+		reply += ", сертификаты поставлены в очередь на восстановление"
+	}
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, reply))
+}
+
+// liftExpiredBan снимает закончившуюся временную блокировку
+func liftExpiredBan(bot *tgbotapi.BotAPI, userID string) {
+	if unbanned, err := sqliteClient.Unban(userID); err != nil || !unbanned {
+		if err != nil {
+			log.Printf("Unban error for %s: %v", userID, err)
+		}
+		return
+	}
+	log.Printf("temporary ban of user %s expired", userID)
+	restoreAfterBan(bot, userID)
+}
+
+// restoreAfterBan уведомляет пользователя о снятии блокировки и возвращает доступ, если у него
+// (или у владельца его семьи) есть оплаченное время и подписка не заморожена
+func restoreAfterBan(bot *tgbotapi.BotAPI, userID string) bool {
+	users := sqliteClient.GetAllUsers()
+	user := users[userID]
+	restore := !user.Frozen() && !user.Suspended() && userActive(users, user)
+	if restore {
+		for _, ref := range ownCertRefs(user) {
+			scheduleUnrevoke(ref)
+		}
+	}
+	if chatID, err := strconv.ParseInt(userID, 10, 64); err == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "✅ Блокировка аккаунта снята. Нажмите /start, чтобы открыть меню."))
+	}
+	return restore
+}
+
+// handleBansCommand — /bans, действующие блокировки
+func handleBansCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	now := time.Now()
+	type banned struct {
+		id   string
+		user sqlite.UserData
+	}
+	var list []banned
+	for id, u := range sqliteClient.GetAllUsers() {
+		if u.Banned(now) {
+			list = append(list, banned{id, u})
+		}
+	}
+	if len(list) == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Заблокированных пользователей нет"))
+		return
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].user.BannedAt > list[j].user.BannedAt })
+
+	lines := []string{fmt.Sprintf("⛔ <b>Заблокированные пользователи</b> (%d)", len(list))}
+	if len(list) > bansListLimit {
+		lines[0] += fmt.Sprintf(", последние %d", bansListLimit)
+		list = list[:bansListLimit]
+	}
+	for _, b := range list {
+		period := "бессрочно"
+		if until := b.user.BanEndsAt(); !until.IsZero() {
+			period = "до " + until.UTC().Format("02.01.2006 15:04 UTC")
+		}
+		lines = append(lines, fmt.Sprintf("• <code>%s</code> %s — %s (admin %s)",
+			b.id, period, html.EscapeString(b.user.BanReason), html.EscapeString(b.user.BannedBy)))
+	}
+	reply := tgbotapi.NewMessage(msg.Chat.ID, strings.Join(lines, "\n"))
+	reply.ParseMode = "HTML"
+	bot.Send(reply)
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// broadcastRate — сообщений в секунду; лимит Telegram — около 30 в секунду на бота
+	broadcastRate = 25
+	// broadcastMaxRetries — сколько раз повторяем отправку после ответа 429
+	broadcastMaxRetries = 3
+	// broadcastProgressInterval — как часто обновляется сообщение о ходе рассылки
+	broadcastProgressInterval = 3 * time.Second
+)
+
+// broadcastRunning — идёт ли сейчас рассылка; одновременно выполняется только одна
+var broadcastRunning atomic.Bool
+
+func broadcastCancelKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "nav_menu"),
+		),
+	)
+}
+
+// marketingKeyboard — кнопка отписки под рекламной рассылкой
+func marketingKeyboard(optedOut bool) tgbotapi.InlineKeyboardMarkup {
+	if optedOut {
+		return tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("🔔 Подписаться снова", "sub_marketing"),
+			),
+		)
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔕 Отписаться от рассылок", "unsub_marketing"),
+		),
+	)
+}
+
+// handleBroadcastCommand начинает рассылку: /broadcast
+func handleBroadcastCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession) {
+	session.BroadcastMsgID = 0
+	session.BroadcastSegment = ""
+	session.BroadcastService = false
+	text := "📣 <b>Рассылка</b>\n\nОтправьте сообщение для рассылки: текст, фото, видео или документ с подписью. Оно будет скопировано пользователям как есть, с форматированием."
+	_ = replaceSessionWithText(bot, msg.Chat.ID, session, stateBroadcastCompose, text, "HTML", broadcastCancelKeyboard())
+}
+
+func handleBroadcastDraft(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession) {
+	session.BroadcastMsgID = msg.MessageID
+	showBroadcastSegments(bot, msg.Chat.ID, session)
+}
+
+func showBroadcastSegments(bot *tgbotapi.BotAPI, chatID int64, session *UserSession) {
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("👥 Все", "bc_seg_all"),
+			tgbotapi.NewInlineKeyboardButtonData("🟢 Активные", "bc_seg_active"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔴 Подписка закончилась", "bc_seg_expired"),
+			tgbotapi.NewInlineKeyboardButtonData("💤 Не платили", "bc_seg_nopay"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🤝 Приглашённые пользователем", "bc_seg_ref"),
+			tgbotapi.NewInlineKeyboardButtonData("🧾 По тарифу", "bc_seg_plan"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "nav_menu"),
+		),
+	)
+	_ = replaceSessionWithText(bot, chatID, session, stateMenu, "👥 Кому отправить рассылку?", "HTML", kb)
+}
+
+func showBroadcastPlans(bot *tgbotapi.BotAPI, chatID int64, session *UserSession) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, plan := range visiblePlans() {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(plan.Title, "bc_plan_"+plan.ID),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "bc_segments"),
+	))
+	_ = updateSessionText(bot, chatID, session, stateMenu, "🧾 Пользователям, оплачивавшим тариф:", "HTML", tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+func handleBroadcastReferrerInput(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession) {
+	referrerID := strings.TrimSpace(msg.Text)
+	if _, err := strconv.ParseInt(referrerID, 10, 64); err != nil {
+		_ = replaceSessionWithText(bot, msg.Chat.ID, session, stateBroadcastReferrer, "❌ Отправьте числовой Telegram ID пригласившего.", "HTML", broadcastCancelKeyboard())
+		return
+	}
+	session.BroadcastSegment = "ref:" + referrerID
+	showBroadcastPreview(bot, msg.Chat.ID, session)
+}
+
+func describeSegment(segment string) string {
+	kind, param, _ := strings.Cut(segment, ":")
+	switch kind {
+	case "all":
+		return "все пользователи"
+	case "active":
+		return "активные"
+	case "expired":
+		return "подписка закончилась"
+	case "nopay":
+		return "ни разу не платили"
+	case "ref":
+		return "приглашённые пользователем " + param
+	case "plan":
+		if plan, ok := planByID(param); ok {
+			return "оплачивали тариф «" + plan.Title + "»"
+		}
+		return "оплачивали тариф " + param
+	}
+	return segment
+}
+
+// broadcastRecipients выбирает получателей сегмента. Заблокировавшие бота и заблокированные
+// администратором пропускаются всегда, отписавшиеся от рекламы — в рекламных рассылках.
+func broadcastRecipients(segment string, marketing bool) []int64 {
+	users := sqliteClient.GetAllUsers()
+	paid := make(map[string]map[string]bool) // userID -> оплаченные тарифы
+	for _, p := range sqliteClient.GetPayments() {
+		if p.Refunded {
+			continue
+		}
+		if paid[p.UserID] == nil {
+			paid[p.UserID] = make(map[string]bool)
+		}
+		paid[p.UserID][p.PlanID] = true
+	}
+
+	kind, param, _ := strings.Cut(segment, ":")
+	now := time.Now()
+	var recipients []int64
+	for id, u := range users {
+		if u.BlockedAt != "" || u.Banned(now) || (marketing && u.MarketingOff) {
+			continue
+		}
+		var match bool
+		switch kind {
+		case "all":
+			match = true
+		case "active":
+			match = userActive(users, u)
+		case "expired":
+			match = !userActive(users, u) && u.CertRef != ""
+		case "nopay":
+			match = len(paid[id]) == 0
+		case "ref":
+			match = u.ReferredBy == param
+		case "plan":
+			match = paid[id][param]
+		}
+		if !match {
+			continue
+		}
+		if chatID, err := strconv.ParseInt(id, 10, 64); err == nil {
+			recipients = append(recipients, chatID)
+		}
+	}
+	return recipients
+}
+
+func showBroadcastPreview(bot *tgbotapi.BotAPI, chatID int64, session *UserSession) {
+	marketing := !session.BroadcastService
+	recipients := broadcastRecipients(session.BroadcastSegment, marketing)
+
+	// предпросмотр — та же копия, которую получат пользователи
+	preview := tgbotapi.NewCopyMessage(chatID, chatID, session.BroadcastMsgID)
+	if marketing {
+		preview.ReplyMarkup = marketingKeyboard(false)
+	}
+	if _, err := bot.Request(preview); err != nil {
+		log.Printf("broadcast preview error: %v", err)
+	}
+
+	kind := "📢 рекламная: не уходит отписавшимся, с кнопкой отписки"
+	kindButton := tgbotapi.NewInlineKeyboardButtonData("🛠 Сделать сервисной", "bc_kind")
+	if !marketing {
+		kind = "🛠 сервисная: уходит всем, включая отписавшихся от рекламы"
+		kindButton = tgbotapi.NewInlineKeyboardButtonData("📢 Сделать рекламной", "bc_kind")
+	}
+	text := fmt.Sprintf("👆 Так выглядит рассылка.\n\n👥 Сегмент: %s\n✉️ Тип: %s\n📬 Получателей: <b>%d</b>",
+		html.EscapeString(describeSegment(session.BroadcastSegment)), kind, len(recipients))
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("✅ Отправить (%d)", len(recipients)), "bc_send"),
+		),
+		tgbotapi.NewInlineKeyboardRow(kindButton),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Сегмент", "bc_segments"),
+			tgbotapi.NewInlineKeyboardButtonData("❌ Отмена", "nav_menu"),
+		),
+	)
+	_ = replaceSessionWithText(bot, chatID, session, stateMenu, text, "HTML", kb)
+}
+
+// handleBroadcastCallback обрабатывает кнопки bc_* мастера рассылки
+func handleBroadcastCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, data string) {
+	chatID := cq.Message.Chat.ID
+	if !hasRole(cq.From.ID, adminCommandRoles["broadcast"]...) {
+		ackCallback(bot, cq, "⛔️ Недостаточно прав")
+		return
+	}
+	if session.BroadcastMsgID == 0 {
+		ackCallback(bot, cq, "Черновик не найден, начните заново: /broadcast")
+		return
+	}
+
+	switch {
+	case data == "bc_segments":
+		showBroadcastSegments(bot, chatID, session)
+	case data == "bc_seg_ref":
+		_ = updateSessionText(bot, chatID, session, stateBroadcastReferrer, "🤝 Отправьте Telegram ID пользователя, чьим приглашённым нужно отправить рассылку.", "HTML", broadcastCancelKeyboard())
+	case data == "bc_seg_plan":
+		showBroadcastPlans(bot, chatID, session)
+	case strings.HasPrefix(data, "bc_seg_"):
+		session.BroadcastSegment = strings.TrimPrefix(data, "bc_seg_")
+		showBroadcastPreview(bot, chatID, session)
+	case strings.HasPrefix(data, "bc_plan_"):
+		session.BroadcastSegment = "plan:" + strings.TrimPrefix(data, "bc_plan_")
+		showBroadcastPreview(bot, chatID, session)
+	case data == "bc_kind":
+		session.BroadcastService = !session.BroadcastService
+		showBroadcastPreview(bot, chatID, session)
+	case data == "bc_send":
+		startBroadcast(bot, cq, session)
+		return
+	}
+	ackCallback(bot, cq, "")
+}
+
+func startBroadcast(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
+	chatID := cq.Message.Chat.ID
+	if session.BroadcastSegment == "" {
+		ackCallback(bot, cq, "Сначала выберите сегмент")
+		return
+	}
+	if !broadcastRunning.CompareAndSwap(false, true) {
+		ackCallback(bot, cq, "⏳ Уже идёт другая рассылка, дождитесь её окончания")
+		return
+	}
+
+	marketing := !session.BroadcastService
+	recipients := broadcastRecipients(session.BroadcastSegment, marketing)
+	record := sqlite.BroadcastRecord{
+		ID:        fmt.Sprintf("bc_%d", time.Now().UnixNano()),
+		AdminID:   strconv.FormatInt(cq.From.ID, 10),
+		Segment:   session.BroadcastSegment,
+		Marketing: marketing,
+		Total:     len(recipients),
+		StartedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := sqliteClient.RecordAudit(sqlite.AuditEntry{
+		AdminID: record.AdminID,
+		Role:    string(admins[cq.From.ID]),
+		Action:  "broadcast_send",
+		Args:    fmt.Sprintf("segment=%s marketing=%t recipients=%d", record.Segment, marketing, record.Total),
+	}); err != nil {
+		log.Printf("RecordAudit error: %v", err)
+	}
+
+	draftID := session.BroadcastMsgID
+	session.BroadcastMsgID = 0
+	_ = updateSessionText(bot, chatID, session, stateMenu, fmt.Sprintf("🚀 Рассылка запущена: %d получателей.", record.Total), "HTML", singleBackKeyboard("nav_menu"))
+	ackCallback(bot, cq, "")
+
+	go func() {
+		defer broadcastRunning.Store(false)
+		runBroadcast(bot, chatID, draftID, recipients, &record)
+	}()
+}
+
+// runBroadcast рассылает копии сообщения с ограничением скорости и отчитывается о ходе рассылки
+func runBroadcast(bot *tgbotapi.BotAPI, adminChatID int64, draftID int, recipients []int64, record *sqlite.BroadcastRecord) {
+	progress := func() string {
+		return fmt.Sprintf("📣 Рассылка: %d из %d\n✅ Доставлено: %d\n🚫 Заблокировали бота: %d\n❌ Ошибки: %d",
+			record.Sent+record.Blocked+record.Failed, record.Total, record.Sent, record.Blocked, record.Failed)
+	}
+	progressMsg, err := bot.Send(tgbotapi.NewMessage(adminChatID, progress()))
+	if err != nil {
+		log.Printf("broadcast progress error: %v", err)
+	}
+	lastProgress := time.Now()
+
+	ticker := time.NewTicker(time.Second / broadcastRate)
+	defer ticker.Stop()
+
+	for _, chatID := range recipients {
+		<-ticker.C
+		cfg := tgbotapi.NewCopyMessage(chatID, adminChatID, draftID)
+		if record.Marketing {
+			cfg.ReplyMarkup = marketingKeyboard(false)
+		}
+		switch err := sendWithRetry(bot, cfg); {
+		case err == nil:
+			record.Sent++
+		case isBlockedError(err):
+			record.Blocked++
+			if err := sqliteClient.SetBlocked(strconv.FormatInt(chatID, 10), true, time.Now()); err != nil {
+				log.Printf("SetBlocked error: %v", err)
+			}
+		default:
+			record.Failed++
+			log.Printf("broadcast to %d error: %v", chatID, err)
+		}
+
+		if time.Since(lastProgress) >= broadcastProgressInterval && progressMsg.MessageID != 0 {
+			bot.Send(tgbotapi.NewEditMessageText(adminChatID, progressMsg.MessageID, progress()))
+			lastProgress = time.Now()
+		}
+	}
+
+	record.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := sqliteClient.RecordBroadcast(*record); err != nil {
+		log.Printf("RecordBroadcast error: %v", err)
+	}
+	final := "🏁 Рассылка завершена\n\n" + progress()
+	if progressMsg.MessageID != 0 {
+		bot.Send(tgbotapi.NewEditMessageText(adminChatID, progressMsg.MessageID, final))
+	} else {
+		bot.Send(tgbotapi.NewMessage(adminChatID, final))
+	}
+}
+
+// sendWithRetry отправляет запрос и при ответе 429 ждёт указанное Telegram время и повторяет
+func sendWithRetry(bot *tgbotapi.BotAPI, c tgbotapi.Chattable) error {
+	for attempt := 0; ; attempt++ {
+		_, err := bot.Request(c)
+		var tgErr *tgbotapi.Error
+		if err == nil || !errors.As(err, &tgErr) || tgErr.Code != 429 || attempt >= broadcastMaxRetries {
+			return err
+		}
+		wait := time.Duration(tgErr.RetryAfter) * time.Second
+		if wait <= 0 {
+			wait = time.Second
+		}
+		time.Sleep(wait)
+	}
+}
+
+// isBlockedError — пользователь заблокировал бота или удалил аккаунт
+func isBlockedError(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && tgErr.Code == 403
+}
+
+// handleMarketingCallback — отписка от рекламных рассылок и повторная подписка
+func handleMarketingCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, optOut bool) {
+	userID := strconv.FormatInt(cq.From.ID, 10)
+	if err := sqliteClient.SetMarketingOptOut(userID, optOut); err != nil {
+		log.Printf("SetMarketingOptOut error: %v", err)
+		ackCallback(bot, cq, "❌ Не удалось сохранить настройку")
+		return
+	}
+	if cq.Message.MessageID == session.MessageID {
+		showReminders(bot, cq.Message.Chat.ID, session, userID)
+	} else {
+		// кнопка под самой рассылкой
+		bot.Send(tgbotapi.NewEditMessageReplyMarkup(cq.Message.Chat.ID, cq.Message.MessageID, marketingKeyboard(optOut)))
+	}
+	if optOut {
+		ackCallback(bot, cq, "🔕 Вы отписались от рекламных рассылок. Важные сервисные сообщения продолжат приходить.")
+	} else {
+		ackCallback(bot, cq, "🔔 Вы снова подписаны на рассылки")
+	}
+}
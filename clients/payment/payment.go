@@ -0,0 +1,56 @@
+package payment
+
+// Статусы платежа, общие для всех провайдеров
+const (
+	StatusPending   = "pending"
+	StatusSucceeded = "succeeded"
+	StatusCanceled  = "canceled"
+)
+
+// CreateRequest описывает платёж, который нужно создать у провайдера
+type CreateRequest struct {
+	Amount      float64
+	Currency    string
+	Description string
+	ChatID      int64
+	Metadata    map[string]interface{}
+	Email       string // e-mail для чека
+	Phone       string // телефон для чека; если нет ни e-mail, ни телефона — чек не формируется
+
+	// SavePaymentMethod просит провайдера сохранить способ оплаты для автоплатежей
+	SavePaymentMethod bool
+	// PaymentMethodID — списание по сохранённому способу оплаты без редиректа
+	PaymentMethodID string
+}
+
+// Payment — состояние платежа у провайдера
+type Payment struct {
+	ID              string
+	Status          string
+	Paid            bool
+	Amount          float64
+	Currency        string
+	ConfirmationURL string // ссылка на оплату, если требуется подтверждение пользователя
+	Metadata        map[string]interface{}
+
+	PaymentMethodID    string
+	PaymentMethodSaved bool
+}
+
+// Succeeded сообщает, что деньги списаны
+func (p *Payment) Succeeded() bool {
+	return p.Status == StatusSucceeded || p.Paid
+}
+
+// PaymentProvider — платёжный шлюз. Реализация не должна знать о Telegram:
+// отрисовкой кнопок и сообщений занимается бот.
+type PaymentProvider interface {
+	// Name — короткое имя провайдера для истории платежей
+	Name() string
+	CreatePayment(req CreateRequest) (*Payment, error)
+	GetPayment(paymentID string) (*Payment, error)
+	Refund(paymentID string, amount float64, currency string) error
+	// VerifyNotification проверяет входящее HTTP-уведомление провайдера
+	// и возвращает актуальное состояние платежа.
+	VerifyNotification(body []byte, remoteAddr string) (*Payment, error)
+}
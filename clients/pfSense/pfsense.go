@@ -270,22 +270,29 @@ func (c *PfSenseClient) GetCARef() (string, error) {
 }
 
 func (c *PfSenseClient) AttachCertificateToUser(userId, certId string) error {
+	return c.SetUserCertificates(userId, []string{certId})
+}
+
+// SetUserCertificates заменяет список сертификатов пользователя pfSense целиком:
+// сертификаты, которых нет в certIds, будут откреплены
+func (c *PfSenseClient) SetUserCertificates(userId string, certIds []string) error {
+	certId := strings.Join(certIds, ",")
 	fmt.Printf("Attaching Certificate{%s} to user{%s}...\n", certId, userId)
 
 	url := "https://drake2.eunet.lv/api/v2/user"
 	payload := map[string]interface{}{
 		"id":   userId,
-		"cert": []string{certId}, // массив строк
+		"cert": certIds, // массив строк
 	}
 
 	jsonBody, err := json.Marshal(payload)
 	if err != nil {
-		return colorfulprint.PrintError(fmt.Sprintf("couldnt marshal payload %w", err), err)
+		return colorfulprint.PrintError("couldnt marshal payload %w", err)
 	}
 
 	req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return colorfulprint.PrintError(fmt.Sprintf("couldnt create request %w", err), err)
+		return colorfulprint.PrintError("couldnt create request %w", err)
 	}
 
 	req.Header.Set("X-API-Key", c.apiKey)
@@ -855,7 +862,13 @@ func (c *PfSenseClient) RevokeCertificate(certRef string) error {
 	return nil
 }
 
-func (c *PfSenseClient) GetCertIdInRevocationList(certRef string) (int, error) {
+// revokedCertificate — запись списка отзыва (CRL)
+type revokedCertificate struct {
+	ID      int    `json:"id"`
+	CertRef string `json:"certref"`
+}
+
+func (c *PfSenseClient) revokedCertificates() ([]revokedCertificate, error) {
 	url := "https://drake2.eunet.lv/api/v2/system/crl"
 
 	payload := map[string]interface{}{
@@ -864,12 +877,12 @@ func (c *PfSenseClient) GetCertIdInRevocationList(certRef string) (int, error) {
 
 	jsonBody, err := json.Marshal(payload)
 	if err != nil {
-		return -1, colorfulprint.PrintError("failed to marshal json: %w", err)
+		return nil, colorfulprint.PrintError("failed to marshal json: %w", err)
 	}
 
 	req, err := http.NewRequest("GET", url, bytes.NewBuffer(jsonBody))
 	if err != nil {
-		return -1, colorfulprint.PrintError("Cant request GET REVOCATION LIST: %w", err)
+		return nil, colorfulprint.PrintError("Cant request GET REVOCATION LIST: %w", err)
 	}
 
 	req.Header.Set("X-API-Key", c.apiKey)
@@ -877,32 +890,38 @@ func (c *PfSenseClient) GetCertIdInRevocationList(certRef string) (int, error) {
 
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return -1, colorfulprint.PrintError("error sending request: %w", err)
+		return nil, colorfulprint.PrintError("error sending request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
 
 	if resp.StatusCode >= 400 {
-		return -1, colorfulprint.PrintError(fmt.Sprintf("failed with status %s: %s", resp.Status, string(body)), nil)
+		return nil, colorfulprint.PrintError(fmt.Sprintf("failed with status %s: %s", resp.Status, string(body)), nil)
 	}
 
 	var r struct {
 		Data struct {
-			Cert []struct {
-				ID      int    `json:"id"`
-				CertRef string `json:"certref"`
-			} `json:"cert"`
+			Cert []revokedCertificate `json:"cert"`
 		} `json:"data"`
 	}
 
 	err = json.Unmarshal(body, &r)
 
 	if err != nil {
-		return -1, colorfulprint.PrintError("Couldnt unmarshal responce %w", err)
+		return nil, colorfulprint.PrintError("Couldnt unmarshal responce %w", err)
+	}
+
+	return r.Data.Cert, nil
+}
+
+func (c *PfSenseClient) GetCertIdInRevocationList(certRef string) (int, error) {
+	certs, err := c.revokedCertificates()
+	if err != nil {
+		return -1, err
 	}
 
-	for _, v := range r.Data.Cert {
+	for _, v := range certs {
 		if v.CertRef == certRef {
 			colorfulprint.PrintState("Successfully found certID in revocationList")
 			return v.ID, nil
@@ -912,6 +931,20 @@ func (c *PfSenseClient) GetCertIdInRevocationList(certRef string) (int, error) {
 	return -1, colorfulprint.PrintError(fmt.Sprintf("Couldnt find certificate in revocation list with ref{%s}", certRef), nil)
 }
 
+// IsCertificateRevoked проверяет, есть ли сертификат в списке отзыва
+func (c *PfSenseClient) IsCertificateRevoked(certRef string) (bool, error) {
+	certs, err := c.revokedCertificates()
+	if err != nil {
+		return false, err
+	}
+	for _, v := range certs {
+		if v.CertRef == certRef {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (c *PfSenseClient) UnrevokeCertificate(certRef string) error {
 	url := "https://drake2.eunet.lv/api/v2/system/crl/revoked_certificate"
 
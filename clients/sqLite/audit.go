@@ -0,0 +1,80 @@
+package sqlite
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// auditFile — журнал действий администраторов. Записи только дописываются, по одной
+// JSON-строке на действие, поэтому журнал не переписывается целиком, как остальные файлы.
+const auditFile = "audit.jsonl"
+
+// AuditEntry — запись журнала действий администратора
+type AuditEntry struct {
+	At      string `json:"at"` // ISO8601 timestamp
+	AdminID string `json:"admin_id"`
+	Role    string `json:"role"`
+	Action  string `json:"action"`           // команда без «/»
+	Args    string `json:"args,omitempty"`   // аргументы команды как есть
+	Denied  bool   `json:"denied,omitempty"` // у администратора не хватило прав
+}
+
+// RecordAudit дописывает запись в журнал действий администраторов
+func (s *Store) RecordAudit(e AuditEntry) error {
+	if e.At == "" {
+		e.At = time.Now().UTC().Format(time.RFC3339)
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	f, err := os.OpenFile(s.siblingPath(auditFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// ListAudit возвращает последние limit записей журнала, от новых к старым
+func (s *Store) ListAudit(limit int) ([]AuditEntry, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	f, err := os.Open(s.siblingPath(auditFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []AuditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
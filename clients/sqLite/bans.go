@@ -0,0 +1,143 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+)
+
+// Banned сообщает, заблокирован ли пользователь администратором в момент now
+func (u UserData) Banned(now time.Time) bool {
+	if u.BannedAt == "" {
+		return false
+	}
+	until := u.BanEndsAt()
+	return until.IsZero() || now.Before(until)
+}
+
+// BanEndsAt — когда закончится временная блокировка; нулевое время — блокировка бессрочная
+func (u UserData) BanEndsAt() time.Time {
+	until, err := time.Parse(time.RFC3339, u.BanUntil)
+	if err != nil {
+		return time.Time{}
+	}
+	return until
+}
+
+// Suspended сообщает, приостановлен ли доступ пользователя администратором
+func (u UserData) Suspended() bool {
+	return u.SuspendedAt != ""
+}
+
+// SetSuspended приостанавливает или возобновляет доступ пользователя. Бот и баланс продолжают
+// работать, но сертификаты не восстанавливаются, пока приостановка не снята.
+// Возвращает, изменилось ли состояние.
+func (s *Store) SetSuspended(userID, by string, suspended bool, now time.Time) (bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return false, fmt.Errorf("user %s not found", userID)
+	}
+	if ud.Suspended() == suspended {
+		return false, nil
+	}
+	ud.SuspendedAt, ud.SuspendedBy = "", ""
+	if suspended {
+		ud.SuspendedAt, ud.SuspendedBy = now.UTC().Format(time.RFC3339), by
+	}
+	db[userID] = ud
+	return true, s.saveUsersLocked()
+}
+
+// Ban блокирует пользователя до until (нулевое время — бессрочно) и аннулирует его бонусы за
+// приглашения: начисленные бонусы и награды списываются с баланса (не больше остатка),
+// ожидающие отклоняются.
+// Возвращает, сколько дней списано.
+func (s *Store) Ban(userID, reason, adminID string, until time.Time, now time.Time) (int64, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	referrals, err := s.loadReferralsLocked()
+	if err != nil {
+		return 0, err
+	}
+	rewards, err := s.loadReferralRewardsLocked()
+	if err != nil {
+		return 0, err
+	}
+	ud, ok := db[userID]
+	if !ok {
+		return 0, fmt.Errorf("user %s not found", userID)
+	}
+	ud.BannedAt = now.UTC().Format(time.RFC3339)
+	ud.BanUntil = ""
+	if !until.IsZero() {
+		ud.BanUntil = until.UTC().Format(time.RFC3339)
+	}
+	ud.BanReason = reason
+	ud.BannedBy = adminID
+
+	var bonus int64
+	for id, r := range referrals {
+		if r.ReferrerID != userID {
+			continue
+		}
+		switch {
+		case r.Status == ReferralRewarded && !r.Voided:
+			bonus += r.Days
+			r.Voided = true
+		case r.Status == ReferralPending:
+			r.Status = ReferralRejected
+			r.Reason = "пригласивший заблокирован"
+			r.ResolvedAt = now.UTC().Format(time.RFC3339)
+		default:
+			continue
+		}
+		referrals[id] = r
+	}
+	for i, r := range rewards {
+		if r.ReferrerID == userID && !r.Voided {
+			bonus += r.Days
+			rewards[i].Voided = true
+		}
+	}
+
+	var voided int64
+	if bonus > 0 {
+		ud.refresh(now)
+		before := ud.Days
+		ud.debit(time.Duration(bonus)*Day, now)
+		voided = before - ud.Days
+	}
+	db[userID] = ud
+	if err := s.saveUsersLocked(); err != nil {
+		return voided, err
+	}
+	if err := s.saveJSONLocked(referralsFile, referrals); err != nil {
+		return voided, err
+	}
+	return voided, s.saveJSONLocked(referralRewardsFile, rewards)
+}
+
+// Unban снимает блокировку. Возвращает false, если пользователь не был заблокирован.
+func (s *Store) Unban(userID string) (bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return false, fmt.Errorf("user %s not found", userID)
+	}
+	if ud.BannedAt == "" {
+		return false, nil
+	}
+	ud.BannedAt = ""
+	ud.BanUntil = ""
+	ud.BanReason = ""
+	ud.BannedBy = ""
+	db[userID] = ud
+	return true, s.saveUsersLocked()
+}
@@ -0,0 +1,188 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+)
+
+// Day — сутки подписки: тарифы и переводы считаются в днях, а баланс хранится как PaidUntil
+const Day = 24 * time.Hour
+
+// clock — момент, на который считается остаток. У замороженного баланса и у собственного
+// баланса участника семьи время не идёт: остаток считается на момент остановки.
+func (u UserData) clock(now time.Time) time.Time {
+	if at, err := time.Parse(time.RFC3339, u.FrozenAt); err == nil {
+		return at
+	}
+	if at, err := time.Parse(time.RFC3339, u.FamilyJoinedAt); err == nil {
+		return at
+	}
+	return now
+}
+
+func (u UserData) paidUntil() (time.Time, bool) {
+	until, err := time.Parse(time.RFC3339, u.PaidUntil)
+	return until, err == nil
+}
+
+// Remaining — сколько оплаченного времени осталось
+func (u UserData) Remaining(now time.Time) time.Duration {
+	until, ok := u.paidUntil()
+	if !ok {
+		return 0
+	}
+	if d := until.Sub(u.clock(now)); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// ExpiresAt — когда закончится подписка; для остановленного баланса — если запустить его сейчас.
+// Нулевое время — оплаченного времени нет.
+func (u UserData) ExpiresAt(now time.Time) time.Time {
+	d := u.Remaining(now)
+	if d <= 0 {
+		return time.Time{}
+	}
+	return now.Add(d).Truncate(time.Second)
+}
+
+// refresh пересчитывает Days — остаток в днях с округлением вверх. Days хранится в data.json
+// для наглядности, но источником правды служит PaidUntil.
+func (u *UserData) refresh(now time.Time) {
+	u.Days = int64((u.Remaining(now) + Day - 1) / Day)
+}
+
+// migrateDays переводит баланс в днях из старого формата в PaidUntil. Раньше дни списывались
+// 24-часовыми шагами от LastDeduct, значит оплачено время до LastDeduct + Days суток.
+// В льготный период Days = 0, и PaidUntil становится моментом обнуления баланса.
+func (u *UserData) migrateDays(now time.Time) bool {
+	if u.PaidUntil != "" || (u.Days <= 0 && !u.InGrace()) {
+		return false
+	}
+	base := now
+	switch {
+	case u.Frozen():
+		base, _ = time.Parse(time.RFC3339, u.FrozenAt)
+	case u.FamilyOwner != "":
+		// собственный баланс участника семьи не расходовался и дальше тоже стоит
+		u.FamilyJoinedAt = now.UTC().Format(time.RFC3339)
+	default:
+		if last, err := time.Parse(time.RFC3339, u.LastDeduct); err == nil {
+			base = last
+		}
+	}
+	u.PaidUntil = base.Add(time.Duration(u.Days) * Day).UTC().Format(time.RFC3339)
+	return true
+}
+
+// extend продлевает подписку на d. Остановленный и ещё не истёкший баланс продлевается
+// от PaidUntil, пустой — от текущего момента. В льготный период продление тоже идёт от
+// момента обнуления: часы льготного периода оплачиваются из новых дней.
+func (u *UserData) extend(d time.Duration, now time.Time) {
+	base := now
+	if until, ok := u.paidUntil(); ok && (until.After(now) || u.InGrace() || u.Frozen() || u.FamilyJoinedAt != "") {
+		base = until
+	}
+	u.PaidUntil = base.Add(d).UTC().Format(time.RFC3339)
+	u.GraceUntil = ""
+	u.refresh(now)
+}
+
+// debit уменьшает оплаченное время на d, но не ниже нуля
+func (u *UserData) debit(d time.Duration, now time.Time) {
+	until, ok := u.paidUntil()
+	if !ok {
+		return
+	}
+	until = until.Add(-d)
+	if clock := u.clock(now); until.Before(clock) {
+		until = clock
+	}
+	u.PaidUntil = until.UTC().Format(time.RFC3339)
+	u.refresh(now)
+}
+
+// pause останавливает время баланса на период участия в семье
+func (u *UserData) pause(now time.Time) {
+	u.FamilyJoinedAt = now.UTC().Format(time.RFC3339)
+}
+
+// unpause запускает время баланса снова, сдвигая PaidUntil на время остановки
+func (u *UserData) unpause(now time.Time) {
+	if at, err := time.Parse(time.RFC3339, u.FamilyJoinedAt); err == nil {
+		if until, ok := u.paidUntil(); ok {
+			u.PaidUntil = until.Add(now.Sub(at)).UTC().Format(time.RFC3339)
+		}
+		u.shiftLimits(now.Sub(at))
+	}
+	u.FamilyJoinedAt = ""
+	u.refresh(now)
+}
+
+// MigrateBalances сохраняет балансы, переведённые из старого формата в PaidUntil при
+// загрузке, и возвращает число таких пользователей. Вызывается при запуске.
+func (s *Store) MigrateBalances() (int, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	migrated := s.loadUsersLocked()
+	if migrated == 0 {
+		return 0, nil
+	}
+	return migrated, s.saveUsersLocked()
+}
+
+// GetExpiresAt возвращает, когда закончится подписка пользователя; для участника
+// семьи — подписка владельца
+func (s *Store) GetExpiresAt(userID string, now time.Time) (time.Time, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return time.Time{}, fmt.Errorf("user %s not found", userID)
+	}
+	if ud.FamilyOwner != "" {
+		ud = db[ud.FamilyOwner]
+	}
+	return ud.ExpiresAt(now), nil
+}
+
+// DeductDays списывает дни с баланса пользователя (не ниже нуля) и возвращает остаток в днях
+func (s *Store) DeductDays(userID string, days int64, now time.Time) (int64, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return 0, fmt.Errorf("user %s not found", userID)
+	}
+	ud.debit(time.Duration(days)*Day, now)
+	db[userID] = ud
+	return ud.Days, s.saveUsersLocked()
+}
+
+// ExpireIfDue закрывает истёкшую подписку: очищает PaidUntil и льготный период.
+// Возвращает false, если подписку успели продлить или льготный период ещё идёт.
+func (s *Store) ExpireIfDue(userID string, now time.Time) (bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return false, fmt.Errorf("user %s not found", userID)
+	}
+	if ud.PaidUntil == "" || ud.Remaining(now) > 0 || (ud.InGrace() && now.Before(ud.GraceEndsAt())) {
+		return false, nil
+	}
+	ud.PaidUntil = ""
+	ud.GraceUntil = ""
+	ud.ExpiredAt = now.UTC().Format(time.RFC3339)
+	ud.refresh(now)
+	db[userID] = ud
+	return true, s.saveUsersLocked()
+}
@@ -0,0 +1,318 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+func ts(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func TestExtend(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      UserData
+		add       time.Duration
+		wantUntil time.Time
+		wantDays  int64
+	}{
+		{
+			name:      "пустой баланс продлевается от текущего момента",
+			user:      UserData{},
+			add:       30 * Day,
+			wantUntil: testNow.Add(30 * Day),
+			wantDays:  30,
+		},
+		{
+			name:      "активная подписка продлевается от PaidUntil",
+			user:      UserData{PaidUntil: ts(testNow.Add(5 * Day))},
+			add:       30 * Day,
+			wantUntil: testNow.Add(35 * Day),
+			wantDays:  35,
+		},
+		{
+			name:      "истёкшая подписка без льготного периода — от текущего момента",
+			user:      UserData{PaidUntil: ts(testNow.Add(-3 * Day))},
+			add:       10 * Day,
+			wantUntil: testNow.Add(10 * Day),
+			wantDays:  10,
+		},
+		{
+			name:      "в льготный период часы после обнуления оплачиваются из новых дней",
+			user:      UserData{PaidUntil: ts(testNow.Add(-6 * time.Hour)), GraceUntil: ts(testNow.Add(18 * time.Hour))},
+			add:       10 * Day,
+			wantUntil: testNow.Add(10*Day - 6*time.Hour),
+			wantDays:  10,
+		},
+		{
+			name:      "замороженный баланс продлевается от PaidUntil",
+			user:      UserData{PaidUntil: ts(testNow.Add(-2 * Day)), FrozenAt: ts(testNow.Add(-5 * Day))},
+			add:       10 * Day,
+			wantUntil: testNow.Add(8 * Day),
+			wantDays:  13,
+		},
+		{
+			name:      "остановленный баланс участника семьи продлевается от PaidUntil",
+			user:      UserData{PaidUntil: ts(testNow.Add(-1 * Day)), FamilyJoinedAt: ts(testNow.Add(-4 * Day)), FamilyOwner: "1"},
+			add:       7 * Day,
+			wantUntil: testNow.Add(6 * Day),
+			wantDays:  10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := tt.user
+			u.extend(tt.add, testNow)
+			if u.PaidUntil != ts(tt.wantUntil) {
+				t.Errorf("PaidUntil = %s, want %s", u.PaidUntil, ts(tt.wantUntil))
+			}
+			if u.Days != tt.wantDays {
+				t.Errorf("Days = %d, want %d", u.Days, tt.wantDays)
+			}
+			if u.GraceUntil != "" {
+				t.Errorf("GraceUntil = %q, want empty", u.GraceUntil)
+			}
+		})
+	}
+}
+
+func TestDebit(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      UserData
+		sub       time.Duration
+		wantUntil string
+		wantDays  int64
+	}{
+		{
+			name:      "списание уменьшает PaidUntil",
+			user:      UserData{PaidUntil: ts(testNow.Add(10 * Day))},
+			sub:       4 * Day,
+			wantUntil: ts(testNow.Add(6 * Day)),
+			wantDays:  6,
+		},
+		{
+			name:      "не ниже текущего момента",
+			user:      UserData{PaidUntil: ts(testNow.Add(2 * Day))},
+			sub:       5 * Day,
+			wantUntil: ts(testNow),
+			wantDays:  0,
+		},
+		{
+			name:      "у замороженного баланса — не ниже момента заморозки",
+			user:      UserData{PaidUntil: ts(testNow.Add(1 * Day)), FrozenAt: ts(testNow.Add(-3 * Day))},
+			sub:       10 * Day,
+			wantUntil: ts(testNow.Add(-3 * Day)),
+			wantDays:  0,
+		},
+		{
+			name:      "пустой баланс не меняется",
+			user:      UserData{},
+			sub:       Day,
+			wantUntil: "",
+			wantDays:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := tt.user
+			u.debit(tt.sub, testNow)
+			if u.PaidUntil != tt.wantUntil {
+				t.Errorf("PaidUntil = %s, want %s", u.PaidUntil, tt.wantUntil)
+			}
+			if u.Days != tt.wantDays {
+				t.Errorf("Days = %d, want %d", u.Days, tt.wantDays)
+			}
+		})
+	}
+}
+
+func TestUnpause(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      UserData
+		wantUntil string
+		wantDays  int64
+	}{
+		{
+			name:      "PaidUntil сдвигается на время в семье",
+			user:      UserData{PaidUntil: ts(testNow.Add(-2 * Day)), FamilyJoinedAt: ts(testNow.Add(-7 * Day))},
+			wantUntil: ts(testNow.Add(5 * Day)),
+			wantDays:  5,
+		},
+		{
+			name:      "без собственного баланса только снимается остановка",
+			user:      UserData{FamilyJoinedAt: ts(testNow.Add(-7 * Day))},
+			wantUntil: "",
+			wantDays:  0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := tt.user
+			u.unpause(testNow)
+			if u.PaidUntil != tt.wantUntil {
+				t.Errorf("PaidUntil = %s, want %s", u.PaidUntil, tt.wantUntil)
+			}
+			if u.FamilyJoinedAt != "" {
+				t.Errorf("FamilyJoinedAt = %q, want empty", u.FamilyJoinedAt)
+			}
+			if u.Days != tt.wantDays {
+				t.Errorf("Days = %d, want %d", u.Days, tt.wantDays)
+			}
+		})
+	}
+}
+
+func TestMigrateDays(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         UserData
+		wantMigrated bool
+		wantUntil    string
+		wantJoinedAt string
+	}{
+		{
+			name:         "от последнего списания",
+			user:         UserData{Days: 5, LastDeduct: ts(testNow.Add(-10 * time.Hour))},
+			wantMigrated: true,
+			wantUntil:    ts(testNow.Add(5*Day - 10*time.Hour)),
+		},
+		{
+			name:         "без LastDeduct — от текущего момента",
+			user:         UserData{Days: 3},
+			wantMigrated: true,
+			wantUntil:    ts(testNow.Add(3 * Day)),
+		},
+		{
+			name:         "замороженный — от момента заморозки",
+			user:         UserData{Days: 4, FrozenAt: ts(testNow.Add(-2 * Day)), LastDeduct: ts(testNow.Add(-9 * Day))},
+			wantMigrated: true,
+			wantUntil:    ts(testNow.Add(2 * Day)),
+		},
+		{
+			name:         "участник семьи — баланс останавливается с текущего момента",
+			user:         UserData{Days: 6, FamilyOwner: "1", LastDeduct: ts(testNow.Add(-9 * Day))},
+			wantMigrated: true,
+			wantUntil:    ts(testNow.Add(6 * Day)),
+			wantJoinedAt: ts(testNow),
+		},
+		{
+			name:         "льготный период — PaidUntil становится моментом обнуления",
+			user:         UserData{Days: 0, GraceUntil: ts(testNow.Add(12 * time.Hour)), LastDeduct: ts(testNow.Add(-12 * time.Hour))},
+			wantMigrated: true,
+			wantUntil:    ts(testNow.Add(-12 * time.Hour)),
+		},
+		{
+			name:         "уже в новом формате",
+			user:         UserData{Days: 5, PaidUntil: ts(testNow.Add(Day))},
+			wantMigrated: false,
+			wantUntil:    ts(testNow.Add(Day)),
+		},
+		{
+			name:         "пустой баланс не переводится",
+			user:         UserData{Days: 0, LastDeduct: ts(testNow)},
+			wantMigrated: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := tt.user
+			if got := u.migrateDays(testNow); got != tt.wantMigrated {
+				t.Errorf("migrateDays() = %v, want %v", got, tt.wantMigrated)
+			}
+			if u.PaidUntil != tt.wantUntil {
+				t.Errorf("PaidUntil = %s, want %s", u.PaidUntil, tt.wantUntil)
+			}
+			if u.FamilyJoinedAt != tt.wantJoinedAt {
+				t.Errorf("FamilyJoinedAt = %q, want %q", u.FamilyJoinedAt, tt.wantJoinedAt)
+			}
+		})
+	}
+}
+
+// newTestStore создаёт хранилище во временном каталоге с заданными пользователями
+func newTestStore(t *testing.T, users map[string]UserData) *Store {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.json")
+	data, err := json.Marshal(users)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return New(path)
+}
+
+func TestExpireIfDue(t *testing.T) {
+	tests := []struct {
+		name        string
+		user        UserData
+		wantExpired bool
+	}{
+		{
+			name:        "подписка истекла",
+			user:        UserData{PaidUntil: ts(testNow.Add(-time.Minute))},
+			wantExpired: true,
+		},
+		{
+			name:        "подписку успели продлить",
+			user:        UserData{PaidUntil: ts(testNow.Add(Day))},
+			wantExpired: false,
+		},
+		{
+			name:        "льготный период ещё идёт",
+			user:        UserData{PaidUntil: ts(testNow.Add(-Day)), GraceUntil: ts(testNow.Add(time.Hour))},
+			wantExpired: false,
+		},
+		{
+			name:        "льготный период закончился",
+			user:        UserData{PaidUntil: ts(testNow.Add(-Day)), GraceUntil: ts(testNow.Add(-time.Hour))},
+			wantExpired: true,
+		},
+		{
+			name:        "подписка уже закрыта",
+			user:        UserData{},
+			wantExpired: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, map[string]UserData{"42": tt.user})
+			expired, err := store.ExpireIfDue("42", testNow)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expired != tt.wantExpired {
+				t.Fatalf("ExpireIfDue() = %v, want %v", expired, tt.wantExpired)
+			}
+			u, err := store.GetUser("42")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !expired {
+				if u.PaidUntil != tt.user.PaidUntil || u.GraceUntil != tt.user.GraceUntil {
+					t.Errorf("user changed: %+v", u)
+				}
+				return
+			}
+			if u.PaidUntil != "" || u.GraceUntil != "" || u.Days != 0 {
+				t.Errorf("PaidUntil = %q, GraceUntil = %q, Days = %d, want empty", u.PaidUntil, u.GraceUntil, u.Days)
+			}
+			if u.ExpiredAt != ts(testNow) {
+				t.Errorf("ExpiredAt = %q, want %q", u.ExpiredAt, ts(testNow))
+			}
+		})
+	}
+
+	if _, err := newTestStore(t, map[string]UserData{}).ExpireIfDue("42", testNow); err == nil {
+		t.Error("ExpireIfDue() of unknown user: want error")
+	}
+}
@@ -0,0 +1,71 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+)
+
+const broadcastsFile = "broadcasts.json"
+
+// BroadcastRecord — итог рассылки администратора
+type BroadcastRecord struct {
+	ID         string `json:"id"`
+	AdminID    string `json:"admin_id"`
+	Segment    string `json:"segment"`
+	Marketing  bool   `json:"marketing"` // рекламная рассылка: не уходит отписавшимся
+	Total      int    `json:"total"`
+	Sent       int    `json:"sent"`
+	Failed     int    `json:"failed"`
+	Blocked    int    `json:"blocked"` // получатели, заблокировавшие бота
+	StartedAt  string `json:"started_at"`
+	FinishedAt string `json:"finished_at"`
+}
+
+// SetBlocked отмечает, что пользователь заблокировал бота (или снова доступен при blocked == false)
+func (s *Store) SetBlocked(userID string, blocked bool, at time.Time) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	if blocked == (ud.BlockedAt != "") {
+		return nil
+	}
+	ud.BlockedAt = ""
+	if blocked {
+		ud.BlockedAt = at.UTC().Format(time.RFC3339)
+	}
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
+// SetMarketingOptOut отписывает пользователя от рекламных рассылок или подписывает обратно
+func (s *Store) SetMarketingOptOut(userID string, optOut bool) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	ud.MarketingOff = optOut
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
+// RecordBroadcast сохраняет итог рассылки
+func (s *Store) RecordBroadcast(r BroadcastRecord) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var records []BroadcastRecord
+	if err := s.loadJSONLocked(broadcastsFile, &records); err != nil {
+		return err
+	}
+	records = append(records, r)
+	return s.saveJSONLocked(broadcastsFile, records)
+}
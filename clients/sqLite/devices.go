@@ -0,0 +1,121 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+)
+
+// PrimaryDeviceID — основное устройство пользователя, его сертификат хранится в UserData.CertRef
+const PrimaryDeviceID = 1
+
+// Device — дополнительное устройство пользователя со своим сертификатом и .ovpn
+type Device struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	CertRef   string `json:"certref"`
+	CertName  string `json:"cert_name"`
+	CreatedAt string `json:"created_at"` // ISO8601 timestamp
+}
+
+// GetDevices возвращает дополнительные устройства пользователя (без основного)
+func (s *Store) GetDevices(userID string) []Device {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	return append([]Device(nil), db[userID].Devices...)
+}
+
+// NextDeviceID возвращает номер, который получит следующее устройство пользователя
+func (s *Store) NextDeviceID(userID string) int {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	next := PrimaryDeviceID + 1
+	for _, d := range db[userID].Devices {
+		if d.ID >= next {
+			next = d.ID + 1
+		}
+	}
+	return next
+}
+
+// AddDevice сохраняет новое устройство пользователя
+func (s *Store) AddDevice(userID string, d Device) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	for _, existing := range ud.Devices {
+		if existing.ID == d.ID {
+			return fmt.Errorf("device %d already exists", d.ID)
+		}
+	}
+	if d.CreatedAt == "" {
+		d.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	ud.Devices = append(ud.Devices, d)
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
+// RenameDevice меняет название устройства
+func (s *Store) RenameDevice(userID string, deviceID int, name string) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud := db[userID]
+	for i := range ud.Devices {
+		if ud.Devices[i].ID == deviceID {
+			ud.Devices[i].Name = name
+			db[userID] = ud
+			return s.saveUsersLocked()
+		}
+	}
+	return fmt.Errorf("device %d not found", deviceID)
+}
+
+// RemoveDevice удаляет устройство и возвращает его, чтобы вызывающий удалил сертификат
+func (s *Store) RemoveDevice(userID string, deviceID int) (Device, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud := db[userID]
+	for i, d := range ud.Devices {
+		if d.ID == deviceID {
+			ud.Devices = append(ud.Devices[:i:i], ud.Devices[i+1:]...)
+			db[userID] = ud
+			return d, s.saveUsersLocked()
+		}
+	}
+	return Device{}, fmt.Errorf("device %d not found", deviceID)
+}
+
+// GetCertRefs возвращает сертификаты всех устройств пользователя: основной первым
+func (s *Store) GetCertRefs(userID string) ([]string, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return nil, fmt.Errorf("user %s not found", userID)
+	}
+	var refs []string
+	if ud.CertRef != "" {
+		refs = append(refs, ud.CertRef)
+	}
+	for _, d := range ud.Devices {
+		if d.CertRef != "" {
+			refs = append(refs, d.CertRef)
+		}
+	}
+	return refs, nil
+}
@@ -0,0 +1,190 @@
+package sqlite
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+var (
+	ErrFamilyInviteInvalid = errors.New("приглашение недействительно")
+	ErrFamilyFull          = errors.New("в семье нет свободных мест")
+	ErrFamilySelf          = errors.New("нельзя вступить в собственную семью")
+	ErrFamilyAlreadyMember = errors.New("вы уже состоите в семье")
+	ErrFamilyIsOwner       = errors.New("у вас уже есть своя семья")
+)
+
+func newFamilyInviteToken() (string, error) {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// familyMembersLocked возвращает ID участников семьи владельца ownerID по порядку
+func familyMembersLocked(ownerID string) []string {
+	var members []string
+	for id, ud := range db {
+		if ud.FamilyOwner == ownerID {
+			members = append(members, id)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// FamilyInvite возвращает токен приглашения в семью, создавая его при первом обращении.
+// С reset == true выпускается новый токен, а старая ссылка перестаёт работать.
+func (s *Store) FamilyInvite(ownerID string, reset bool) (string, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[ownerID]
+	if !ok {
+		return "", fmt.Errorf("user %s not found", ownerID)
+	}
+	if ud.FamilyInvite != "" && !reset {
+		return ud.FamilyInvite, nil
+	}
+	token, err := newFamilyInviteToken()
+	if err != nil {
+		return "", err
+	}
+	ud.FamilyInvite = token
+	db[ownerID] = ud
+	return token, s.saveUsersLocked()
+}
+
+// FamilyMembers возвращает ID участников семьи
+func (s *Store) FamilyMembers(ownerID string) []string {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	return familyMembersLocked(ownerID)
+}
+
+// JoinFamily добавляет пользователя в семью по токену приглашения и возвращает ID владельца.
+// Пока пользователь в семье, его собственный баланс не расходуется.
+func (s *Store) JoinFamily(token, memberID string) (string, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ownerID := ""
+	for id, ud := range db {
+		if token != "" && ud.FamilyInvite == token {
+			ownerID = id
+			break
+		}
+	}
+	if ownerID == "" || db[ownerID].FamilyLimit <= 0 {
+		return "", ErrFamilyInviteInvalid
+	}
+	if ownerID == memberID {
+		return ownerID, ErrFamilySelf
+	}
+
+	member := db[memberID]
+	if member.FamilyOwner == ownerID {
+		return ownerID, nil
+	}
+	if member.FamilyOwner != "" {
+		return ownerID, ErrFamilyAlreadyMember
+	}
+	if len(familyMembersLocked(memberID)) > 0 {
+		return ownerID, ErrFamilyIsOwner
+	}
+	if len(familyMembersLocked(ownerID)) >= db[ownerID].FamilyLimit {
+		return ownerID, ErrFamilyFull
+	}
+
+	// участник пользуется балансом владельца: собственный баланс стоит, а льготный период больше не нужен
+	member.pause(time.Now().UTC())
+	member.GraceUntil = ""
+	member.FamilyOwner = ownerID
+	db[memberID] = member
+	return ownerID, s.saveUsersLocked()
+}
+
+// LeaveFamily выводит пользователя из семьи и возвращает ID бывшего владельца.
+// Собственный баланс снова начинает расходоваться с текущего момента.
+func (s *Store) LeaveFamily(memberID string) (string, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[memberID]
+	if !ok || ud.FamilyOwner == "" {
+		return "", fmt.Errorf("user %s is not a family member", memberID)
+	}
+	ownerID := ud.FamilyOwner
+	return ownerID, s.leaveFamilyLocked(memberID)
+}
+
+// RemoveFamilyMember исключает участника из семьи владельца ownerID
+func (s *Store) RemoveFamilyMember(ownerID, memberID string) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	if ud, ok := db[memberID]; !ok || ud.FamilyOwner != ownerID {
+		return fmt.Errorf("user %s is not a member of %s's family", memberID, ownerID)
+	}
+	return s.leaveFamilyLocked(memberID)
+}
+
+func (s *Store) leaveFamilyLocked(memberID string) error {
+	ud := db[memberID]
+	ud.FamilyOwner = ""
+	ud.unpause(time.Now().UTC())
+	db[memberID] = ud
+	return s.saveUsersLocked()
+}
+
+// DetachPaidMembers выводит из семьи ownerID участников, у которых остался собственный
+// баланс: когда подписка владельца закончилась, он снова начинает расходоваться с now.
+// Возвращает ID выведенных участников.
+func (s *Store) DetachPaidMembers(ownerID string, now time.Time) ([]string, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	var detached []string
+	for _, memberID := range familyMembersLocked(ownerID) {
+		member := db[memberID]
+		if member.Remaining(now) <= 0 {
+			continue
+		}
+		member.FamilyOwner = ""
+		member.unpause(now)
+		db[memberID] = member
+		detached = append(detached, memberID)
+	}
+	if len(detached) == 0 {
+		return nil, nil
+	}
+	return detached, s.saveUsersLocked()
+}
+
+// GetFamilyCertRefs возвращает сертификаты пользователя, а для владельца семьи — ещё и
+// сертификаты всех участников: они пользуются одним балансом и отзываются вместе
+func (s *Store) GetFamilyCertRefs(userID string) ([]string, error) {
+	refs, err := s.GetCertRefs(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, memberID := range s.FamilyMembers(userID) {
+		memberRefs, err := s.GetCertRefs(memberID)
+		if err != nil {
+			continue
+		}
+		refs = append(refs, memberRefs...)
+	}
+	return refs, nil
+}
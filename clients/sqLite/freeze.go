@@ -0,0 +1,103 @@
+package sqlite
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// FreezePeriod — период, в пределах которого действует лимит дней заморозки тарифа
+const FreezePeriod = 30 * 24 * time.Hour
+
+var (
+	ErrAlreadyFrozen   = errors.New("подписка уже заморожена")
+	ErrNotFrozen       = errors.New("подписка не заморожена")
+	ErrNoFreezeDays    = errors.New("лимит заморозки на этот период исчерпан")
+	ErrNothingToFreeze = errors.New("на балансе нет дней")
+)
+
+// Frozen сообщает, заморожен ли баланс пользователя
+func (u UserData) Frozen() bool {
+	return u.FrozenAt != ""
+}
+
+// FreezeDaysLeft возвращает, сколько дней заморозки осталось в текущем периоде
+// (без учёта идущей сейчас заморозки)
+func (u UserData) FreezeDaysLeft(now time.Time) int {
+	used := u.FreezeUsed
+	if start, err := time.Parse(time.RFC3339, u.FreezePeriodStart); err != nil || now.Sub(start) >= FreezePeriod {
+		used = 0
+	}
+	if left := u.FreezeLimit - used; left > 0 {
+		return left
+	}
+	return 0
+}
+
+// frozenDays — сколько дней заморозки засчитывается за период с FrozenAt до now (неполные сутки — целиком)
+func (u UserData) frozenDays(now time.Time) int {
+	frozenAt, err := time.Parse(time.RFC3339, u.FrozenAt)
+	if err != nil {
+		return 0
+	}
+	return int(math.Ceil(now.Sub(frozenAt).Hours() / 24))
+}
+
+// FreezeEndsAt — когда заморозка закончится сама, если не возобновить подписку раньше
+func (u UserData) FreezeEndsAt(now time.Time) time.Time {
+	frozenAt, err := time.Parse(time.RFC3339, u.FrozenAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return frozenAt.Add(time.Duration(u.FreezeDaysLeft(now)) * 24 * time.Hour)
+}
+
+// Freeze замораживает баланс: пока он заморожен, дни не списываются
+func (s *Store) Freeze(userID string, now time.Time) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	switch {
+	case !ok || ud.Days <= 0:
+		return ErrNothingToFreeze
+	case ud.Frozen():
+		return ErrAlreadyFrozen
+	case ud.FreezeDaysLeft(now) <= 0:
+		return ErrNoFreezeDays
+	}
+
+	if start, err := time.Parse(time.RFC3339, ud.FreezePeriodStart); err != nil || now.Sub(start) >= FreezePeriod {
+		ud.FreezePeriodStart = now.UTC().Format(time.RFC3339)
+		ud.FreezeUsed = 0
+	}
+	ud.FrozenAt = now.UTC().Format(time.RFC3339)
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
+// Resume снимает заморозку, засчитывает израсходованные дни заморозки и сдвигает
+// окончание подписки на время заморозки. Возвращает число дней заморозки.
+func (s *Store) Resume(userID string, now time.Time) (int, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok || !ud.Frozen() {
+		return 0, ErrNotFrozen
+	}
+
+	days := ud.frozenDays(now)
+	ud.FreezeUsed += days
+	frozenAt, _ := time.Parse(time.RFC3339, ud.FrozenAt)
+	if until, ok := ud.paidUntil(); ok {
+		ud.PaidUntil = until.Add(now.Sub(frozenAt)).UTC().Format(time.RFC3339)
+	}
+	ud.shiftLimits(now.Sub(frozenAt))
+	ud.FrozenAt = ""
+	ud.refresh(now)
+	db[userID] = ud
+	return days, s.saveUsersLocked()
+}
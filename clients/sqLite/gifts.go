@@ -0,0 +1,145 @@
+package sqlite
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const giftsFile = "gifts.json"
+
+// giftCodeAlphabet не содержит похожих символов (0/O, 1/I), чтобы код можно было продиктовать
+const giftCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+var (
+	ErrGiftNotFound = errors.New("подарок не найден")
+	ErrGiftRedeemed = errors.New("подарок уже активирован")
+	ErrGiftExpired  = errors.New("срок действия подарка истёк")
+	ErrGiftCanceled = errors.New("подарок отменён")
+)
+
+// Gift — оплаченная подписка, которую покупатель передаёт другому человеку одноразовым кодом
+type Gift struct {
+	Code       string `json:"code"`
+	BuyerID    string `json:"buyer_id"`
+	PlanID     string `json:"plan_id"`
+	Days       int    `json:"days"`
+	PaymentID  string `json:"payment_id"`
+	CreatedAt  string `json:"created_at"` // ISO8601 timestamp
+	ExpiresAt  string `json:"expires_at"` // ISO8601 timestamp
+	RedeemedBy string `json:"redeemed_by,omitempty"`
+	RedeemedAt string `json:"redeemed_at,omitempty"`
+	Canceled   bool   `json:"canceled,omitempty"` // платёж за подарок возвращён до активации
+}
+
+func (s *Store) loadGiftsLocked() (map[string]Gift, error) {
+	gifts := make(map[string]Gift)
+	if err := s.loadJSONLocked(giftsFile, &gifts); err != nil {
+		return nil, err
+	}
+	return gifts, nil
+}
+
+func newGiftCode() (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	for i, b := range buf {
+		buf[i] = giftCodeAlphabet[int(b)%len(giftCodeAlphabet)]
+	}
+	return string(buf), nil
+}
+
+// CreateGift выпускает подарочный код за оплаченный платёж. Повторный вызов с тем же
+// paymentID возвращает уже выпущенный код, поэтому повторная обработка оплаты безопасна.
+func (s *Store) CreateGift(buyerID, planID string, days int, paymentID string, ttl time.Duration) (Gift, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	gifts, err := s.loadGiftsLocked()
+	if err != nil {
+		return Gift{}, err
+	}
+	if paymentID != "" {
+		for _, g := range gifts {
+			if g.PaymentID == paymentID {
+				return g, nil
+			}
+		}
+	}
+
+	code, err := newGiftCode()
+	for err == nil {
+		if _, exists := gifts[code]; !exists {
+			break
+		}
+		code, err = newGiftCode()
+	}
+	if err != nil {
+		return Gift{}, err
+	}
+
+	now := time.Now().UTC()
+	g := Gift{
+		Code:      code,
+		BuyerID:   buyerID,
+		PlanID:    planID,
+		Days:      days,
+		PaymentID: paymentID,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: now.Add(ttl).Format(time.RFC3339),
+	}
+	gifts[code] = g
+	return g, s.saveJSONLocked(giftsFile, gifts)
+}
+
+// GetGift возвращает подарок по коду
+func (s *Store) GetGift(code string) (Gift, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	gifts, err := s.loadGiftsLocked()
+	if err != nil {
+		return Gift{}, err
+	}
+	g, ok := gifts[strings.ToUpper(strings.TrimSpace(code))]
+	if !ok {
+		return Gift{}, ErrGiftNotFound
+	}
+	return g, nil
+}
+
+// RedeemGift помечает подарок активированным пользователем userID. Дни начисляет вызывающий.
+func (s *Store) RedeemGift(code, userID string, now time.Time) (Gift, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	gifts, err := s.loadGiftsLocked()
+	if err != nil {
+		return Gift{}, err
+	}
+	code = strings.ToUpper(strings.TrimSpace(code))
+	g, ok := gifts[code]
+	switch {
+	case !ok:
+		return Gift{}, ErrGiftNotFound
+	case g.Canceled:
+		return g, ErrGiftCanceled
+	case g.RedeemedBy != "":
+		return g, ErrGiftRedeemed
+	}
+	if expires, err := time.Parse(time.RFC3339, g.ExpiresAt); err == nil && now.After(expires) {
+		return g, ErrGiftExpired
+	}
+
+	g.RedeemedBy = userID
+	g.RedeemedAt = now.UTC().Format(time.RFC3339)
+	gifts[code] = g
+	if err := s.saveJSONLocked(giftsFile, gifts); err != nil {
+		return Gift{}, fmt.Errorf("save gift: %v", err)
+	}
+	return g, nil
+}
@@ -0,0 +1,39 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+)
+
+// InGrace сообщает, идёт ли льготный период после окончания подписки
+func (u UserData) InGrace() bool {
+	return u.GraceUntil != ""
+}
+
+// GraceEndsAt — когда закончится льготный период
+func (u UserData) GraceEndsAt() time.Time {
+	until, err := time.Parse(time.RFC3339, u.GraceUntil)
+	if err != nil {
+		return time.Time{}
+	}
+	return until
+}
+
+// StartGrace начинает льготный период до until: сертификаты пока не отзываются.
+// Возвращает false, если подписку успели продлить или период уже начат.
+func (s *Store) StartGrace(userID string, until, now time.Time) (bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return false, fmt.Errorf("user %s not found", userID)
+	}
+	if ud.Remaining(now) > 0 || ud.InGrace() {
+		return false, nil
+	}
+	ud.GraceUntil = until.UTC().Format(time.RFC3339)
+	db[userID] = ud
+	return true, s.saveUsersLocked()
+}
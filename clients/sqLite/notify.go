@@ -0,0 +1,47 @@
+package sqlite
+
+// notifyPrefsFile — подписки администраторов на уведомления: ID администратора -> отключённые события.
+// По умолчанию администратор получает все события, поэтому хранятся только отключённые.
+const notifyPrefsFile = "notify_prefs.json"
+
+// MutedEvents возвращает события, уведомления о которых администратор отключил
+func (s *Store) MutedEvents(adminID string) (map[string]bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	prefs := make(map[string][]string)
+	if err := s.loadJSONLocked(notifyPrefsFile, &prefs); err != nil {
+		return nil, err
+	}
+	muted := make(map[string]bool)
+	for _, event := range prefs[adminID] {
+		muted[event] = true
+	}
+	return muted, nil
+}
+
+// SetEventMuted отключает или включает администратору уведомления о событии
+func (s *Store) SetEventMuted(adminID, event string, muted bool) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	prefs := make(map[string][]string)
+	if err := s.loadJSONLocked(notifyPrefsFile, &prefs); err != nil {
+		return err
+	}
+	var events []string
+	for _, e := range prefs[adminID] {
+		if e != event {
+			events = append(events, e)
+		}
+	}
+	if muted {
+		events = append(events, event)
+	}
+	if len(events) == 0 {
+		delete(prefs, adminID)
+	} else {
+		prefs[adminID] = events
+	}
+	return s.saveJSONLocked(notifyPrefsFile, prefs)
+}
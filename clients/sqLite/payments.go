@@ -0,0 +1,171 @@
+package sqlite
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const paymentsFile = "payments.json"
+
+// Провайдеры, через которые прошёл платёж
+const (
+	ProviderYooKassa = "yookassa" // ссылка YooKassa и автоплатежи
+	ProviderTelegram = "telegram" // счёт Telegram Payments (YooKassa как провайдер)
+	ProviderStars    = "stars"    // Telegram Stars (XTR)
+)
+
+// PaymentRecord — запись об успешной оплате тарифа
+type PaymentRecord struct {
+	ID         string  `json:"id"` // ID платежа у провайдера (для Stars — telegram_payment_charge_id)
+	UserID     string  `json:"user_id"`
+	Provider   string  `json:"provider"`
+	PlanID     string  `json:"plan_id"`
+	Amount     float64 `json:"amount"`
+	Currency   string  `json:"currency"`
+	Days       int     `json:"days"`
+	PromoCode  string  `json:"promo_code,omitempty"`
+	GiftCode   string  `json:"gift_code,omitempty"` // платёж за подарок: дни получает тот, кто активировал код
+	CreatedAt  string  `json:"created_at"`          // ISO8601 timestamp
+	Refunded   bool    `json:"refunded"`
+	RefundedAt string  `json:"refunded_at,omitempty"`
+}
+
+func (s *Store) loadPaymentsLocked() (map[string]PaymentRecord, error) {
+	payments := make(map[string]PaymentRecord)
+	if err := s.loadJSONLocked(paymentsFile, &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// hasPaidLocked — есть ли у пользователя своя оплата без возврата (оплата подарка не считается:
+// дни по ней получил другой человек)
+func hasPaidLocked(payments map[string]PaymentRecord, userID string) bool {
+	for _, p := range payments {
+		if p.UserID == userID && p.GiftCode == "" && !p.Refunded {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPaid сообщает, есть ли у пользователя оплата без возврата
+func (s *Store) HasPaid(userID string) bool {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	payments, err := s.loadPaymentsLocked()
+	return err == nil && hasPaidLocked(payments, userID)
+}
+
+// RecordPayment сохраняет успешный платёж. Повторная запись с тем же ID игнорируется,
+// поэтому метод можно безопасно вызывать при повторной обработке.
+func (s *Store) RecordPayment(p PaymentRecord) error {
+	if p.ID == "" {
+		return fmt.Errorf("payment id is empty")
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	payments, err := s.loadPaymentsLocked()
+	if err != nil {
+		return err
+	}
+	if _, exists := payments[p.ID]; exists {
+		return nil
+	}
+	if p.CreatedAt == "" {
+		p.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	payments[p.ID] = p
+	return s.saveJSONLocked(paymentsFile, payments)
+}
+
+// GetPayment возвращает платёж по ID
+func (s *Store) GetPayment(id string) (PaymentRecord, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	payments, err := s.loadPaymentsLocked()
+	if err != nil {
+		return PaymentRecord{}, err
+	}
+	p, ok := payments[id]
+	if !ok {
+		return PaymentRecord{}, fmt.Errorf("payment %s not found", id)
+	}
+	return p, nil
+}
+
+// GetPayments возвращает все платежи, отсортированные от новых к старым
+func (s *Store) GetPayments() []PaymentRecord {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	payments, err := s.loadPaymentsLocked()
+	if err != nil {
+		return nil
+	}
+	result := make([]PaymentRecord, 0, len(payments))
+	for _, p := range payments {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt > result[j].CreatedAt })
+	return result
+}
+
+// RefundPayment помечает платёж возвращённым и списывает начисленные по нему дни
+// (не уходя в минус). Возвращает обновлённую запись и остаток дней пользователя.
+// Для подарка дни списываются у получателя, а неактивированный подарок отменяется.
+func (s *Store) RefundPayment(id string, at time.Time) (PaymentRecord, int64, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	payments, err := s.loadPaymentsLocked()
+	if err != nil {
+		return PaymentRecord{}, 0, err
+	}
+	p, ok := payments[id]
+	if !ok {
+		return PaymentRecord{}, 0, fmt.Errorf("payment %s not found", id)
+	}
+	if p.Refunded {
+		return p, 0, fmt.Errorf("payment %s already refunded", id)
+	}
+
+	daysUserID := p.UserID
+	if p.GiftCode != "" {
+		gifts, err := s.loadGiftsLocked()
+		if err != nil {
+			return p, 0, err
+		}
+		g := gifts[p.GiftCode]
+		daysUserID = g.RedeemedBy
+		if g.RedeemedBy == "" {
+			g.Canceled = true
+			gifts[p.GiftCode] = g
+			if err := s.saveJSONLocked(giftsFile, gifts); err != nil {
+				return p, 0, err
+			}
+		}
+	}
+
+	p.Refunded = true
+	p.RefundedAt = at.UTC().Format(time.RFC3339)
+	payments[id] = p
+
+	if err := s.saveJSONLocked(paymentsFile, payments); err != nil {
+		return p, 0, err
+	}
+	if daysUserID == "" {
+		return p, 0, nil
+	}
+
+	s.loadUsersLocked()
+	ud := db[daysUserID]
+	ud.debit(time.Duration(p.Days)*Day, at)
+	db[daysUserID] = ud
+	return p, ud.Days, s.saveUsersLocked()
+}
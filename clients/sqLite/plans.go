@@ -0,0 +1,210 @@
+package sqlite
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const defaultPlansFile = "plans.json"
+
+// planIDPattern — допустимый ID тарифа. ID попадает в callback_data кнопок (не длиннее 64 байт)
+// и в payload счёта, где «:» и «|» служат разделителями.
+var planIDPattern = regexp.MustCompile(`^[a-z0-9_-]{1,32}$`)
+
+// ValidPlanID сообщает, можно ли использовать строку как ID тарифа
+func ValidPlanID(id string) bool {
+	return planIDPattern.MatchString(id)
+}
+
+// Plan — тариф из каталога. Тарифы не удаляются, а скрываются, чтобы старые
+// платежи с их ID по-прежнему можно было сопоставить с тарифом.
+type Plan struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Amount      float64 `json:"amount"`
+	Currency    string  `json:"currency"`
+	Days        int     `json:"days"`
+	Description string  `json:"description"`
+	Stars       int     `json:"stars,omitempty"`       // цена в Telegram Stars (XTR); 0 — тариф недоступен за звёзды
+	Devices     int     `json:"devices,omitempty"`     // сколько устройств можно подключить; 0 — одно
+	Family      int     `json:"family,omitempty"`      // сколько участников можно пригласить; 0 — тариф не семейный
+	FreezeDays  int     `json:"freeze_days,omitempty"` // сколько дней можно заморозить за период; 0 — заморозка недоступна
+	Hidden      bool    `json:"hidden,omitempty"`
+	SortOrder   int     `json:"sort_order"`
+}
+
+// SetPlansFile переопределяет путь к каталогу тарифов (по умолчанию plans.json рядом с БД)
+func (s *Store) SetPlansFile(path string) {
+	s.plansPath = path
+}
+
+func (s *Store) plansFile() string {
+	if s.plansPath != "" {
+		return s.plansPath
+	}
+	return s.siblingPath(defaultPlansFile)
+}
+
+// PlansModTime возвращает время изменения каталога — по нему бот понимает, что каталог нужно перечитать
+func (s *Store) PlansModTime() time.Time {
+	info, err := os.Stat(s.plansFile())
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+func (s *Store) loadPlansLocked() (map[string]Plan, error) {
+	var list []Plan
+	if err := s.loadJSONFileLocked(s.plansFile(), &list); err != nil {
+		return nil, err
+	}
+	plans := make(map[string]Plan, len(list))
+	for _, p := range list {
+		if p.Currency == "" {
+			p.Currency = "RUB"
+		}
+		plans[p.ID] = p
+	}
+	return plans, nil
+}
+
+func (s *Store) savePlansLocked(plans map[string]Plan) error {
+	list := make([]Plan, 0, len(plans))
+	for _, p := range plans {
+		list = append(list, p)
+	}
+	sortPlans(list)
+	return s.saveJSONFileLocked(s.plansFile(), list)
+}
+
+func sortPlans(list []Plan) {
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].SortOrder != list[j].SortOrder {
+			return list[i].SortOrder < list[j].SortOrder
+		}
+		return list[i].ID < list[j].ID
+	})
+}
+
+// LoadPlans возвращает весь каталог (включая скрытые тарифы) в порядке сортировки
+func (s *Store) LoadPlans() ([]Plan, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	plans, err := s.loadPlansLocked()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Plan, 0, len(plans))
+	for _, p := range plans {
+		list = append(list, p)
+	}
+	sortPlans(list)
+	return list, nil
+}
+
+// SeedPlans записывает каталог по умолчанию, если каталога ещё нет
+func (s *Store) SeedPlans(defaults []Plan) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	plans, err := s.loadPlansLocked()
+	if err != nil {
+		return err
+	}
+	if len(plans) > 0 {
+		return nil
+	}
+	for i, p := range defaults {
+		if p.SortOrder == 0 {
+			p.SortOrder = (i + 1) * 10
+		}
+		if p.Currency == "" {
+			p.Currency = "RUB"
+		}
+		plans[p.ID] = p
+	}
+	return s.savePlansLocked(plans)
+}
+
+// SavePlan добавляет тариф или обновляет существующий
+func (s *Store) SavePlan(p Plan) error {
+	if !ValidPlanID(p.ID) {
+		return fmt.Errorf("ID тарифа %q: допустимы от 1 до 32 символов a-z, 0-9, «_» и «-»", p.ID)
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	plans, err := s.loadPlansLocked()
+	if err != nil {
+		return err
+	}
+	if p.Currency == "" {
+		p.Currency = "RUB"
+	}
+	plans[p.ID] = p
+	return s.savePlansLocked(plans)
+}
+
+// PlanLimits — лимиты оплаченного тарифа
+type PlanLimits struct {
+	Devices int // сколько устройств можно подключить
+	Family  int // сколько участников можно пригласить в семью; 0 — тариф не семейный
+	Freeze  int // сколько дней можно заморозить за период
+}
+
+// ApplyPlanLimits сохраняет лимиты тарифа, за который пользователю только что начислено days
+// дней. Пока не закончилось время, оплаченное тарифом с большими лимитами (LimitsUntil), лимиты
+// не уменьшаются: короткое пополнение после годового тарифа не отнимает устройства и дни
+// заморозки. Размер семьи обычный тариф не меняет.
+func (s *Store) ApplyPlanLimits(userID string, limits PlanLimits, days int, now time.Time) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	ud.applyLimits(limits, days, now)
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
+func (u *UserData) applyLimits(limits PlanLimits, days int, now time.Time) {
+	limitsUntil, err := time.Parse(time.RFC3339, u.LimitsUntil)
+	if err != nil {
+		// лимиты, заданные до появления LimitsUntil, оплачены всем временем до этой покупки
+		limitsUntil = time.Time{}
+		if until, ok := u.paidUntil(); ok {
+			limitsUntil = until.Add(-time.Duration(days) * Day)
+		}
+	}
+	paid := limitsUntil.After(u.clock(now))
+	upgrade := limits.Devices >= u.DeviceLimit && limits.Freeze >= u.FreezeLimit &&
+		(limits.Family == 0 || limits.Family >= u.FamilyLimit)
+	if paid && !upgrade {
+		u.DeviceLimit = max(u.DeviceLimit, limits.Devices)
+		u.FamilyLimit = max(u.FamilyLimit, limits.Family)
+		u.FreezeLimit = max(u.FreezeLimit, limits.Freeze)
+		return
+	}
+	u.DeviceLimit = limits.Devices
+	u.FreezeLimit = limits.Freeze
+	if limits.Family > 0 {
+		u.FamilyLimit = limits.Family
+	}
+	u.LimitsUntil = u.PaidUntil
+}
+
+// shiftLimits сдвигает LimitsUntil вместе с PaidUntil, когда баланс стоял (заморозка, семья)
+func (u *UserData) shiftLimits(d time.Duration) {
+	if until, err := time.Parse(time.RFC3339, u.LimitsUntil); err == nil {
+		u.LimitsUntil = until.Add(d).UTC().Format(time.RFC3339)
+	}
+}
@@ -0,0 +1,236 @@
+package sqlite
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const promoCodesFile = "promocodes.json"
+
+// PromoCode — промокод маркетинговой кампании. Скидка задаётся либо в процентах,
+// либо фиксированной суммой; бонусные дни начисляются дополнительно к тарифу.
+type PromoCode struct {
+	Code            string         `json:"code"`
+	DiscountPercent int            `json:"discount_percent,omitempty"`
+	DiscountAmount  float64        `json:"discount_amount,omitempty"` // фиксированная скидка в рублях
+	BonusDays       int            `json:"bonus_days,omitempty"`
+	MaxUses         int            `json:"max_uses,omitempty"`          // 0 — без ограничения
+	MaxUsesPerUser  int            `json:"max_uses_per_user,omitempty"` // 0 — без ограничения
+	ValidFrom       string         `json:"valid_from,omitempty"`        // ISO8601 timestamp
+	ValidUntil      string         `json:"valid_until,omitempty"`       // ISO8601 timestamp
+	PlanIDs         []string       `json:"plan_ids,omitempty"`          // пусто — действует на все тарифы
+	UsedCount       int            `json:"used_count"`
+	Uses            map[string]int `json:"uses,omitempty"` // userID -> сколько раз использовал
+	// Reserved — неоплаченные счета с промокодом: userID -> до какого момента (ISO8601) за ним
+	// держится использование. Пока бронь действует, её не может занять другой пользователь.
+	Reserved  map[string]string `json:"reserved,omitempty"`
+	CreatedAt string            `json:"created_at"`
+}
+
+// PromoReservationTTL — сколько держится бронь промокода за выставленным счётом
+const PromoReservationTTL = time.Hour
+
+// NormalizePromoCode приводит код к виду, в котором он хранится
+func NormalizePromoCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func (s *Store) loadPromoCodesLocked() (map[string]PromoCode, error) {
+	codes := make(map[string]PromoCode)
+	if err := s.loadJSONLocked(promoCodesFile, &codes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// SavePromoCode создаёт или заменяет промокод, сохраняя накопленную статистику использований
+func (s *Store) SavePromoCode(p PromoCode) error {
+	p.Code = NormalizePromoCode(p.Code)
+	if p.Code == "" {
+		return fmt.Errorf("promo code is empty")
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	codes, err := s.loadPromoCodesLocked()
+	if err != nil {
+		return err
+	}
+	if existing, ok := codes[p.Code]; ok {
+		p.UsedCount = existing.UsedCount
+		p.Uses = existing.Uses
+		p.Reserved = existing.Reserved
+		p.CreatedAt = existing.CreatedAt
+	}
+	if p.CreatedAt == "" {
+		p.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	codes[p.Code] = p
+	return s.saveJSONLocked(promoCodesFile, codes)
+}
+
+// DeletePromoCode удаляет промокод
+func (s *Store) DeletePromoCode(code string) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	codes, err := s.loadPromoCodesLocked()
+	if err != nil {
+		return err
+	}
+	code = NormalizePromoCode(code)
+	if _, ok := codes[code]; !ok {
+		return fmt.Errorf("promo code %s not found", code)
+	}
+	delete(codes, code)
+	return s.saveJSONLocked(promoCodesFile, codes)
+}
+
+// ListPromoCodes возвращает все промокоды по алфавиту
+func (s *Store) ListPromoCodes() []PromoCode {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	codes, err := s.loadPromoCodesLocked()
+	if err != nil {
+		return nil
+	}
+	result := make([]PromoCode, 0, len(codes))
+	for _, p := range codes {
+		result = append(result, p)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Code < result[j].Code })
+	return result
+}
+
+// GetPromoCode возвращает промокод без проверки лимитов — для уже оплаченных счетов,
+// использование по которым забронировано заранее
+func (s *Store) GetPromoCode(code string) (PromoCode, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	codes, err := s.loadPromoCodesLocked()
+	if err != nil {
+		return PromoCode{}, err
+	}
+	p, ok := codes[NormalizePromoCode(code)]
+	if !ok {
+		return PromoCode{}, fmt.Errorf("промокод не найден")
+	}
+	return p, nil
+}
+
+// CheckPromoCode проверяет, может ли пользователь применить промокод к тарифу
+func (s *Store) CheckPromoCode(code, userID, planID string, now time.Time) (PromoCode, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	codes, err := s.loadPromoCodesLocked()
+	if err != nil {
+		return PromoCode{}, err
+	}
+	p, ok := codes[NormalizePromoCode(code)]
+	if !ok {
+		return PromoCode{}, fmt.Errorf("промокод не найден")
+	}
+	return p, p.check(userID, planID, now)
+}
+
+func (p PromoCode) check(userID, planID string, now time.Time) error {
+	if from, err := time.Parse(time.RFC3339, p.ValidFrom); err == nil && now.Before(from) {
+		return fmt.Errorf("промокод ещё не действует")
+	}
+	if until, err := time.Parse(time.RFC3339, p.ValidUntil); err == nil && now.After(until) {
+		return fmt.Errorf("срок действия промокода истёк")
+	}
+	if p.MaxUses > 0 && p.UsedCount+p.reservedByOthers(userID, now) >= p.MaxUses {
+		return fmt.Errorf("промокод больше недоступен")
+	}
+	if p.MaxUsesPerUser > 0 && p.Uses[userID] >= p.MaxUsesPerUser {
+		return fmt.Errorf("вы уже использовали этот промокод")
+	}
+	if len(p.PlanIDs) > 0 && planID != "" {
+		allowed := false
+		for _, id := range p.PlanIDs {
+			if id == planID {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("промокод не действует на этот тариф")
+		}
+	}
+	return nil
+}
+
+// reservedByOthers — сколько действующих броней держат другие пользователи
+func (p PromoCode) reservedByOthers(userID string, now time.Time) int {
+	n := 0
+	for id, until := range p.Reserved {
+		if t, err := time.Parse(time.RFC3339, until); id != userID && err == nil && now.Before(t) {
+			n++
+		}
+	}
+	return n
+}
+
+// ReservePromoCode проверяет промокод и одной операцией бронирует за пользователем использование
+// на PromoReservationTTL. Вызывается при выставлении счёта и перед списанием денег, поэтому
+// последнее использование ограниченного кода не достанется двум покупателям сразу.
+func (s *Store) ReservePromoCode(code, userID, planID string, now time.Time) (PromoCode, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	codes, err := s.loadPromoCodesLocked()
+	if err != nil {
+		return PromoCode{}, err
+	}
+	code = NormalizePromoCode(code)
+	p, ok := codes[code]
+	if !ok {
+		return PromoCode{}, fmt.Errorf("промокод не найден")
+	}
+	if err := p.check(userID, planID, now); err != nil {
+		return p, err
+	}
+	for id, until := range p.Reserved {
+		if t, err := time.Parse(time.RFC3339, until); err != nil || !now.Before(t) {
+			delete(p.Reserved, id)
+		}
+	}
+	if p.Reserved == nil {
+		p.Reserved = make(map[string]string)
+	}
+	p.Reserved[userID] = now.Add(PromoReservationTTL).UTC().Format(time.RFC3339)
+	codes[code] = p
+	return p, s.saveJSONLocked(promoCodesFile, codes)
+}
+
+// UsePromoCode засчитывает использование промокода после успешного начисления и снимает бронь.
+// Лимиты здесь не проверяются: деньги уже списаны, и покупатель должен получить обещанное.
+func (s *Store) UsePromoCode(code, userID string) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	codes, err := s.loadPromoCodesLocked()
+	if err != nil {
+		return err
+	}
+	code = NormalizePromoCode(code)
+	p, ok := codes[code]
+	if !ok {
+		return fmt.Errorf("promo code %s not found", code)
+	}
+	if p.Uses == nil {
+		p.Uses = make(map[string]int)
+	}
+	p.Uses[userID]++
+	p.UsedCount++
+	delete(p.Reserved, userID)
+	codes[code] = p
+	return s.saveJSONLocked(promoCodesFile, codes)
+}
@@ -0,0 +1,372 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const referralsFile = "referrals.json"
+
+// legacyReferralDays — бонус, который начислялся сразу при регистрации до отложенных наград
+const legacyReferralDays = 15
+
+var ErrReferralNotPending = errors.New("бонус за приглашение уже начислен или отклонён")
+
+// ReferralStatus — состояние бонуса за приглашение
+type ReferralStatus string
+
+const (
+	ReferralPending  ReferralStatus = "pending"  // ждём оплаты или активности приглашённого
+	ReferralRewarded ReferralStatus = "rewarded" // бонус начислен пригласившему
+	ReferralRejected ReferralStatus = "rejected" // бонус не положен, причина в Reason
+)
+
+// Referral — приглашение нового пользователя и бонус за него
+type Referral struct {
+	InviteeID  string         `json:"invitee_id"`
+	ReferrerID string         `json:"referrer_id"`
+	Status     ReferralStatus `json:"status"`
+	Reason     string         `json:"reason,omitempty"`      // почему бонус отклонён
+	CreatedAt  string         `json:"created_at"`            // ISO8601 timestamp регистрации по ссылке
+	ResolvedAt string         `json:"resolved_at,omitempty"` // ISO8601 timestamp начисления или отказа
+	Days       int64          `json:"days,omitempty"`        // сколько дней начислено пригласившему
+	Voided     bool           `json:"voided,omitempty"`      // бонус аннулирован при блокировке пригласившего
+}
+
+// loadReferralsLocked читает приглашения по ID приглашённого. Приглашения, записанные до
+// появления referrals.json, переносятся как уже вознаграждённые.
+func (s *Store) loadReferralsLocked() (map[string]Referral, error) {
+	referrals := make(map[string]Referral)
+	if err := s.loadJSONLocked(referralsFile, &referrals); err != nil {
+		return nil, err
+	}
+	if s.migrateReferralsLocked(referrals) {
+		if err := s.saveJSONLocked(referralsFile, referrals); err != nil {
+			return nil, err
+		}
+	}
+	return referrals, nil
+}
+
+func (s *Store) migrateReferralsLocked(referrals map[string]Referral) bool {
+	s.loadUsersLocked()
+	voided := make(map[string]int) // сколько старых приглашений пригласившего уже аннулировано
+	for id, u := range db {
+		voided[id] = u.ReferralsVoided
+	}
+	var legacy []string
+	for id, u := range db {
+		if _, ok := referrals[id]; !ok && u.ReferredBy != "" {
+			legacy = append(legacy, id)
+		}
+	}
+	if len(legacy) == 0 {
+		return false
+	}
+	sort.Strings(legacy)
+	for _, id := range legacy {
+		u := db[id]
+		r := Referral{
+			InviteeID:  id,
+			ReferrerID: u.ReferredBy,
+			Status:     ReferralRewarded,
+			CreatedAt:  u.CreatedAt,
+			ResolvedAt: u.CreatedAt,
+			Days:       legacyReferralDays,
+		}
+		if voided[u.ReferredBy] > 0 {
+			r.Voided = true
+			voided[u.ReferredBy]--
+		}
+		referrals[id] = r
+	}
+	return true
+}
+
+// RecordReferral записывает, что новый пользователь пришёл по ссылке пригласившего. Пустой
+// rejectReason — бонус ждёт оплаты или активности приглашённого, иначе приглашение
+// записывается, но бонус за него не положен.
+func (s *Store) RecordReferral(newUserID, referrerID, rejectReason string, now time.Time) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	referrals, err := s.loadReferralsLocked()
+	if err != nil {
+		return err
+	}
+	if newUser, exists := db[newUserID]; exists && newUser.ReferredBy != "" {
+		return fmt.Errorf("user %s already used referral code", newUserID)
+	}
+
+	newUser := db[newUserID]
+	newUser.ReferredBy = referrerID
+	newUser.ReferralUsed = true
+	db[newUserID] = newUser
+
+	referrer := db[referrerID]
+	referrer.ReferralsCount++
+	db[referrerID] = referrer
+
+	r := Referral{
+		InviteeID:  newUserID,
+		ReferrerID: referrerID,
+		Status:     ReferralPending,
+		CreatedAt:  now.UTC().Format(time.RFC3339),
+	}
+	if rejectReason != "" {
+		r.Status = ReferralRejected
+		r.Reason = rejectReason
+		r.ResolvedAt = r.CreatedAt
+	}
+	referrals[newUserID] = r
+	if err := s.saveJSONLocked(referralsFile, referrals); err != nil {
+		return err
+	}
+	return s.saveUsersLocked()
+}
+
+// GetReferral возвращает приглашение, по которому пришёл пользователь
+func (s *Store) GetReferral(inviteeID string) (Referral, bool) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	referrals, err := s.loadReferralsLocked()
+	if err != nil {
+		return Referral{}, false
+	}
+	r, ok := referrals[inviteeID]
+	return r, ok
+}
+
+// ListReferrals возвращает все приглашения, новые первыми
+func (s *Store) ListReferrals() ([]Referral, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	referrals, err := s.loadReferralsLocked()
+	if err != nil {
+		return nil, err
+	}
+	list := make([]Referral, 0, len(referrals))
+	for _, r := range referrals {
+		list = append(list, r)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].CreatedAt != list[j].CreatedAt {
+			return list[i].CreatedAt > list[j].CreatedAt
+		}
+		return list[i].InviteeID < list[j].InviteeID
+	})
+	return list, nil
+}
+
+// CountReferralsSince — сколько приглашений пригласившего с бонусом (ожидающим или начисленным)
+// записано начиная с since
+func (s *Store) CountReferralsSince(referrerID string, since time.Time) int {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	referrals, err := s.loadReferralsLocked()
+	if err != nil {
+		return 0
+	}
+	var n int
+	for _, r := range referrals {
+		if r.ReferrerID != referrerID || r.Status == ReferralRejected {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339, r.CreatedAt); err == nil && !at.Before(since) {
+			n++
+		}
+	}
+	return n
+}
+
+// RewardReferral начисляет пригласившему days дней за приглашение, ожидающее бонуса
+func (s *Store) RewardReferral(inviteeID string, days int64, now time.Time) (Referral, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	referrals, err := s.loadReferralsLocked()
+	if err != nil {
+		return Referral{}, err
+	}
+	r, ok := referrals[inviteeID]
+	if !ok || r.Status != ReferralPending {
+		return r, ErrReferralNotPending
+	}
+	referrer, ok := db[r.ReferrerID]
+	if !ok {
+		return r, fmt.Errorf("user %s not found", r.ReferrerID)
+	}
+	referrer.extend(time.Duration(days)*Day, now)
+	db[r.ReferrerID] = referrer
+
+	r.Status = ReferralRewarded
+	r.Days = days
+	r.ResolvedAt = now.UTC().Format(time.RFC3339)
+	referrals[inviteeID] = r
+	if err := s.saveUsersLocked(); err != nil {
+		return r, err
+	}
+	return r, s.saveJSONLocked(referralsFile, referrals)
+}
+
+// RejectReferral отклоняет бонус за приглашение, ожидающее оплаты или активности
+func (s *Store) RejectReferral(inviteeID, reason string, now time.Time) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	referrals, err := s.loadReferralsLocked()
+	if err != nil {
+		return err
+	}
+	r, ok := referrals[inviteeID]
+	if !ok || r.Status != ReferralPending {
+		return ErrReferralNotPending
+	}
+	r.Status = ReferralRejected
+	r.Reason = reason
+	r.ResolvedAt = now.UTC().Format(time.RFC3339)
+	referrals[inviteeID] = r
+	return s.saveJSONLocked(referralsFile, referrals)
+}
+
+const referralRewardsFile = "referral_rewards.json"
+
+// Виды дополнительных наград пригласившему, кроме бонуса за подтверждённого друга
+const (
+	RewardPayment     = "payment"      // процент от дней каждой оплаты друга
+	RewardSecondLevel = "second_level" // друг пригласившего сам привёл подтверждённого друга
+)
+
+// ReferralReward — дополнительная награда пригласившему
+type ReferralReward struct {
+	Kind       string `json:"kind"`
+	ReferrerID string `json:"referrer_id"` // кто получил дни
+	InviteeID  string `json:"invitee_id"`  // за кого: оплативший друг или друг второго уровня
+	PaymentID  string `json:"payment_id,omitempty"`
+	Days       int64  `json:"days"`
+	CreatedAt  string `json:"created_at"`       // ISO8601 timestamp
+	Voided     bool   `json:"voided,omitempty"` // платёж возвращён или пригласивший заблокирован
+}
+
+func (r ReferralReward) key() string {
+	return r.Kind + ":" + r.ReferrerID + ":" + r.InviteeID + ":" + r.PaymentID
+}
+
+func (s *Store) loadReferralRewardsLocked() ([]ReferralReward, error) {
+	var rewards []ReferralReward
+	if err := s.loadJSONLocked(referralRewardsFile, &rewards); err != nil {
+		return nil, err
+	}
+	return rewards, nil
+}
+
+// AddReferralReward начисляет пригласившему дополнительную награду. Повторная награда того же
+// вида за того же друга и платёж игнорируется; тогда возвращается false.
+func (s *Store) AddReferralReward(reward ReferralReward, now time.Time) (bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	rewards, err := s.loadReferralRewardsLocked()
+	if err != nil {
+		return false, err
+	}
+	for _, r := range rewards {
+		if r.key() == reward.key() {
+			return false, nil
+		}
+	}
+	s.loadUsersLocked()
+	referrer, ok := db[reward.ReferrerID]
+	if !ok {
+		return false, fmt.Errorf("user %s not found", reward.ReferrerID)
+	}
+	referrer.extend(time.Duration(reward.Days)*Day, now)
+	db[reward.ReferrerID] = referrer
+
+	reward.CreatedAt = now.UTC().Format(time.RFC3339)
+	rewards = append(rewards, reward)
+	if err := s.saveUsersLocked(); err != nil {
+		return false, err
+	}
+	return true, s.saveJSONLocked(referralRewardsFile, rewards)
+}
+
+// VoidPaymentReward аннулирует награду за возвращённый платёж и списывает её дни с баланса
+// пригласившего (не больше остатка). ok == false — за платёж ничего не начислялось.
+func (s *Store) VoidPaymentReward(paymentID string, now time.Time) (reward ReferralReward, remaining int64, ok bool, err error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	rewards, err := s.loadReferralRewardsLocked()
+	if err != nil {
+		return reward, 0, false, err
+	}
+	for i, r := range rewards {
+		if r.Kind != RewardPayment || r.PaymentID != paymentID || r.Voided {
+			continue
+		}
+		s.loadUsersLocked()
+		referrer := db[r.ReferrerID]
+		referrer.debit(time.Duration(r.Days)*Day, now)
+		db[r.ReferrerID] = referrer
+		rewards[i].Voided = true
+		if err := s.saveUsersLocked(); err != nil {
+			return r, 0, false, err
+		}
+		return rewards[i], referrer.Days, true, s.saveJSONLocked(referralRewardsFile, rewards)
+	}
+	return reward, 0, false, nil
+}
+
+// ReferralSummary — итоги реферальной программы пользователя
+type ReferralSummary struct {
+	Invited    int   // пришло по ссылке
+	Pending    int   // бонус ждёт оплаты или активности друга
+	Rewarded   int   // подтверждённые друзья
+	Rejected   int   // бонус отклонён
+	EarnedDays int64 // начислено за всё время, без аннулированных наград
+}
+
+// ReferralSummary считает приглашения пользователя и заработанные на них дни
+func (s *Store) ReferralSummary(referrerID string) (ReferralSummary, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var sum ReferralSummary
+	referrals, err := s.loadReferralsLocked()
+	if err != nil {
+		return sum, err
+	}
+	rewards, err := s.loadReferralRewardsLocked()
+	if err != nil {
+		return sum, err
+	}
+	for _, r := range referrals {
+		if r.ReferrerID != referrerID {
+			continue
+		}
+		sum.Invited++
+		switch r.Status {
+		case ReferralPending:
+			sum.Pending++
+		case ReferralRewarded:
+			sum.Rewarded++
+			if !r.Voided {
+				sum.EarnedDays += r.Days
+			}
+		case ReferralRejected:
+			sum.Rejected++
+		}
+	}
+	for _, r := range rewards {
+		if r.ReferrerID == referrerID && !r.Voided {
+			sum.EarnedDays += r.Days
+		}
+	}
+	return sum, nil
+}
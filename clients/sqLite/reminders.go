@@ -0,0 +1,48 @@
+package sqlite
+
+import "fmt"
+
+// SetRemindersEnabled включает или выключает напоминания об окончании баланса
+func (s *Store) SetRemindersEnabled(userID string, enabled bool) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	ud.RemindersOff = !enabled
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
+// SetTimezone сохраняет часовой пояс пользователя (имя из базы IANA, например Europe/Moscow)
+func (s *Store) SetTimezone(userID, timezone string) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	ud.Timezone = timezone
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
+// SetReminderSent запоминает порог (в днях), о котором уже напомнили в текущем цикле; 0 — начать цикл заново
+func (s *Store) SetReminderSent(userID string, days int64) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	ud, ok := db[userID]
+	if !ok {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	ud.ReminderSent = days
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
@@ -11,18 +12,63 @@ import (
 )
 
 type Store struct {
-	path string
+	path      string
+	plansPath string
 }
 
 type UserData struct {
-	Days           int64  `json:"days"`
+	Days           int64  `json:"days"`                 // остаток в днях с округлением вверх, вычисляется из PaidUntil
+	PaidUntil      string `json:"paid_until,omitempty"` // ISO8601 timestamp, до которого оплачена подписка
 	CertRef        string `json:"certref"`
-	LastDeduct     string `json:"last_deduct"`     // ISO8601 timestamp
+	LastDeduct     string `json:"last_deduct"`     // ISO8601 timestamp; устарело, нужно только для перевода старых балансов
 	ReferredBy     string `json:"referred_by"`     // ID пользователя, который пригласил
 	ReferralUsed   bool   `json:"referral_used"`   // использовал ли свой реферальный бонус
 	ReferralsCount int    `json:"referrals_count"` // сколько человек пригласил
 	Email          string `json:"email"`
-	ConsentAt      string `json:"consent_at"` // ISO8601 timestamp, когда принял политику
+	Phone          string `json:"phone,omitempty"` // телефон для чеков (E.164 без «+»)
+	ConsentAt      string `json:"consent_at"`      // ISO8601 timestamp, когда принял политику
+
+	AutopayEnabled  bool   `json:"autopay_enabled"`            // включено ли автопродление
+	AutopayPlanID   string `json:"autopay_plan_id"`            // тариф, который списывается при автопродлении
+	PaymentMethodID string `json:"payment_method_id"`          // сохранённый способ оплаты YooKassa
+	AutopayPending  string `json:"autopay_pending"`            // ID автоплатежа, ожидающего подтверждения
+	AutopayAttempt  string `json:"autopay_attempt"`            // ISO8601 timestamp последней попытки автосписания
+	AutopayFailures int    `json:"autopay_failures,omitempty"` // сколько автосписаний подряд отклонено
+
+	Devices     []Device `json:"devices,omitempty"`      // дополнительные устройства, основное — CertRef
+	DeviceLimit int      `json:"device_limit,omitempty"` // сколько устройств разрешено; 0 — одно
+	LimitsUntil string   `json:"limits_until,omitempty"` // ISO8601 timestamp, до которого лимиты оплачены тарифом, который их задал
+
+	FamilyOwner  string `json:"family_owner,omitempty"`  // ID владельца семьи, с чьего баланса списываются дни
+	FamilyLimit  int    `json:"family_limit,omitempty"`  // сколько участников владелец может пригласить
+	FamilyInvite string `json:"family_invite,omitempty"` // токен ссылки-приглашения в семью
+
+	FrozenAt          string `json:"frozen_at,omitempty"`           // ISO8601 timestamp начала заморозки; пусто — не заморожен
+	FreezeLimit       int    `json:"freeze_limit,omitempty"`        // дней заморозки за период по тарифу
+	FreezeUsed        int    `json:"freeze_used,omitempty"`         // сколько дней заморозки израсходовано в текущем периоде
+	FreezePeriodStart string `json:"freeze_period_start,omitempty"` // ISO8601 timestamp начала периода заморозки
+
+	RemindersOff bool   `json:"reminders_off,omitempty"` // отключены ли напоминания об окончании баланса
+	Timezone     string `json:"timezone,omitempty"`      // часовой пояс IANA для тихих часов; пусто — по умолчанию
+	ReminderSent int64  `json:"reminder_sent,omitempty"` // порог в днях, о котором уже напомнили в этом цикле
+
+	GraceUntil string `json:"grace_until,omitempty"` // ISO8601 timestamp конца льготного периода; пусто — его нет
+
+	FamilyJoinedAt string `json:"family_joined_at,omitempty"` // ISO8601 timestamp, с которого собственный баланс участника семьи стоит
+
+	CreatedAt string `json:"created_at,omitempty"` // ISO8601 timestamp регистрации
+	ExpiredAt string `json:"expired_at,omitempty"` // ISO8601 timestamp последнего окончания подписки
+
+	BlockedAt    string `json:"blocked_at,omitempty"`    // ISO8601 timestamp, когда пользователь заблокировал бота
+	MarketingOff bool   `json:"marketing_off,omitempty"` // отписался от рекламных рассылок
+
+	BannedAt        string `json:"banned_at,omitempty"`        // ISO8601 timestamp блокировки администратором; пусто — не заблокирован
+	BanUntil        string `json:"ban_until,omitempty"`        // ISO8601 timestamp окончания временной блокировки; пусто — бессрочно
+	BanReason       string `json:"ban_reason,omitempty"`       // причина блокировки, видна только администраторам
+	BannedBy        string `json:"banned_by,omitempty"`        // ID администратора, который заблокировал
+	SuspendedAt     string `json:"suspended_at,omitempty"`     // ISO8601 timestamp приостановки доступа (/revoke, API); пусто — не приостановлен
+	SuspendedBy     string `json:"suspended_by,omitempty"`     // кто приостановил: ID администратора или api:<name>
+	ReferralsVoided int    `json:"referrals_voided,omitempty"` // устарело: аннулированные приглашения теперь в referrals.json, нужно для переноса
 }
 
 var (
@@ -36,33 +82,68 @@ func New(path string) *Store {
 	}
 }
 
-func (s *Store) loadUsersLocked() {
+// loadUsersLocked читает data.json и пересчитывает остатки в днях. Возвращает число
+// пользователей, чей баланс пришлось перевести из старого формата.
+func (s *Store) loadUsersLocked() int {
 	data, err := os.ReadFile(s.path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// file doesn't exist yet — initialize empty DB
 			db = make(map[string]UserData)
-			return
+			return 0
 		}
 		// other read errors: keep db nil/empty
-		return
+		return 0
 	}
 
 	if len(data) == 0 {
 		db = make(map[string]UserData)
-		return
+		return 0
 	}
 
 	var tmp map[string]UserData
 	if err := json.Unmarshal(data, &tmp); err != nil {
 		// invalid JSON — initialize empty DB (could also choose to preserve existing)
 		db = make(map[string]UserData)
-		return
+		return 0
+	}
+
+	now := time.Now().UTC()
+	migrated := 0
+	for id, ud := range tmp {
+		if ud.migrateDays(now) {
+			migrated++
+		}
+		ud.backfillCreatedAt()
+		ud.refresh(now)
+		tmp[id] = ud
 	}
 	db = tmp
+	return migrated
+}
+
+// backfillCreatedAt восстанавливает дату регистрации у записей, созданных до появления
+// CreatedAt: по согласию с политикой, а если его нет — по последнему списанию
+func (u *UserData) backfillCreatedAt() {
+	if u.CreatedAt == "" {
+		if u.ConsentAt != "" {
+			u.CreatedAt = u.ConsentAt
+		} else {
+			u.CreatedAt = u.LastDeduct
+		}
+	}
 }
 
 func (s *Store) saveUsersLocked() error {
+	// новые записи получают дату регистрации при первом сохранении
+	now := time.Now().UTC().Format(time.RFC3339)
+	for id, ud := range db {
+		if ud.CreatedAt == "" {
+			ud.CreatedAt = now
+			db[id] = ud
+		}
+	}
+
 	data, err := json.MarshalIndent(db, "", "  ")
 	if err != nil {
 		return err
@@ -75,6 +156,43 @@ func (s *Store) saveUsersLocked() error {
 	return nil
 }
 
+// siblingPath возвращает путь к дополнительному файлу рядом с основной БД пользователей
+func (s *Store) siblingPath(name string) string {
+	return filepath.Join(filepath.Dir(s.path), name)
+}
+
+// loadJSONLocked читает дополнительный JSON-файл хранилища. Отсутствующий или пустой файл
+// не считается ошибкой — v остаётся нетронутым.
+func (s *Store) loadJSONLocked(name string, v interface{}) error {
+	return s.loadJSONFileLocked(s.siblingPath(name), v)
+}
+
+func (s *Store) saveJSONLocked(name string, v interface{}) error {
+	return s.saveJSONFileLocked(s.siblingPath(name), v)
+}
+
+func (s *Store) loadJSONFileLocked(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *Store) saveJSONFileLocked(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
 func (s *Store) AddDays(userID string, days int64) error {
 	dbMu.Lock()
 	defer dbMu.Unlock()
@@ -82,22 +200,8 @@ func (s *Store) AddDays(userID string, days int64) error {
 	s.loadUsersLocked()
 
 	now := time.Now().UTC()
-	userData, exist := db[userID]
-
-	if !exist {
-		userData = UserData{
-			Days:       days,
-			LastDeduct: now.Format(time.RFC3339),
-		}
-	} else {
-		prev := userData.Days
-		userData.Days += days
-		// если пополнение было с нуля -> начать новый 24ч цикл от момента пополнения
-		if prev == 0 && userData.Days > 0 {
-			userData.LastDeduct = now.Format(time.RFC3339)
-		}
-	}
-
+	userData := db[userID]
+	userData.extend(time.Duration(days)*Day, now)
 	db[userID] = userData
 
 	return s.saveUsersLocked()
@@ -112,6 +216,10 @@ func (s *Store) GetDays(userID string) (int64, error) {
 	userData, exist := db[userID]
 
 	if exist {
+		// участник семьи пользуется балансом владельца
+		if userData.FamilyOwner != "" {
+			return db[userData.FamilyOwner].Days, nil
+		}
 		return userData.Days, nil
 	} else {
 		return 0, colorfulprint.PrintError(fmt.Sprintf("userid(%s) does not exist in DataBase", userID), nil)
@@ -133,45 +241,6 @@ func (s *Store) GetCertRef(userID string) (string, error) {
 	}
 }
 
-func (s *Store) ConsumeDays(userID string, days int64, nextCheck time.Time) (int64, error) {
-	if days <= 0 {
-		return 0, fmt.Errorf("days to consume must be positive")
-	}
-
-	dbMu.Lock()
-	defer dbMu.Unlock()
-
-	s.loadUsersLocked()
-
-	userData, exist := db[userID]
-	if !exist {
-		return 0, fmt.Errorf("user %s not found", userID)
-	}
-
-	if userData.Days <= 0 {
-		return userData.Days, nil
-	}
-
-	if days > userData.Days {
-		days = userData.Days
-	}
-
-	userData.Days -= days
-	if nextCheck.IsZero() {
-		nextCheck = time.Now().UTC()
-	} else {
-		nextCheck = nextCheck.UTC()
-	}
-	userData.LastDeduct = nextCheck.Format(time.RFC3339)
-	db[userID] = userData
-
-	if err := s.saveUsersLocked(); err != nil {
-		return 0, err
-	}
-
-	return userData.Days, nil
-}
-
 func (s *Store) GetAllUsers() map[string]UserData {
 	dbMu.Lock()
 	defer dbMu.Unlock()
@@ -220,6 +289,22 @@ func (s *Store) SetEmail(userID, email string) error {
 	return s.saveUsersLocked()
 }
 
+// SetPhone сохраняет телефон пользователя для отправки чеков
+func (s *Store) SetPhone(userID, phone string) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+
+	ud := db[userID]
+	if ud.LastDeduct == "" {
+		ud.LastDeduct = time.Now().UTC().Format(time.RFC3339)
+	}
+	ud.Phone = phone
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
 // GetEmail возвращает email пользователя, если задан
 func (s *Store) GetEmail(userID string) (string, error) {
 	dbMu.Lock()
@@ -261,41 +346,148 @@ func (s *Store) IsNewUser(userID string) bool {
 	return !exists
 }
 
-// RecordReferral записывает реферальную связь между новым пользователем и пригласившим
-func (s *Store) RecordReferral(newUserID, referrerID string) error {
+// GetReferralsCount возвращает количество приглашенных пользователей
+func (s *Store) GetReferralsCount(userID string) int {
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
 	s.loadUsersLocked()
 
-	// Проверяем, не использовал ли уже новый пользователь реферальный код
-	if newUser, exists := db[newUserID]; exists && newUser.ReferredBy != "" {
-		return fmt.Errorf("user %s already used referral code", newUserID)
+	if userData, exist := db[userID]; exist {
+		return userData.ReferralsCount
 	}
+	return 0
+}
 
-	// Обновляем нового пользователя
-	newUser := db[newUserID]
-	newUser.ReferredBy = referrerID
-	newUser.ReferralUsed = true
-	db[newUserID] = newUser
+// GetUser возвращает копию данных пользователя
+func (s *Store) GetUser(userID string) (UserData, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
 
-	// Увеличиваем счетчик рефералов у пригласившего
-	referrer := db[referrerID]
-	referrer.ReferralsCount++
-	db[referrerID] = referrer
+	s.loadUsersLocked()
 
+	ud, ok := db[userID]
+	if !ok {
+		return UserData{}, fmt.Errorf("user %s not found", userID)
+	}
+	return ud, nil
+}
+
+// SetAutopay включает или выключает автопродление. При выключении сохранённый
+// способ оплаты удаляется, чтобы списания больше не проводились.
+func (s *Store) SetAutopay(userID string, enabled bool) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+
+	ud := db[userID]
+	if ud.LastDeduct == "" {
+		ud.LastDeduct = time.Now().UTC().Format(time.RFC3339)
+	}
+	ud.AutopayEnabled = enabled
+	ud.AutopayFailures = 0
+	if !enabled {
+		ud.PaymentMethodID = ""
+		ud.AutopayPending = ""
+	}
+	db[userID] = ud
 	return s.saveUsersLocked()
 }
 
-// GetReferralsCount возвращает количество приглашенных пользователей
-func (s *Store) GetReferralsCount(userID string) int {
+// SavePaymentMethod запоминает способ оплаты и тариф для автопродления
+func (s *Store) SavePaymentMethod(userID, paymentMethodID, planID string) error {
 	dbMu.Lock()
 	defer dbMu.Unlock()
 
 	s.loadUsersLocked()
 
-	if userData, exist := db[userID]; exist {
-		return userData.ReferralsCount
+	ud := db[userID]
+	if ud.LastDeduct == "" {
+		ud.LastDeduct = time.Now().UTC().Format(time.RFC3339)
 	}
-	return 0
+	ud.PaymentMethodID = paymentMethodID
+	ud.AutopayFailures = 0
+	if planID != "" {
+		ud.AutopayPlanID = planID
+	}
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
+// SetAutopayAttempt фиксирует попытку автосписания и ID платежа, если он ещё не завершён
+func (s *Store) SetAutopayAttempt(userID, pendingPaymentID string, at time.Time) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+
+	ud, ok := db[userID]
+	if !ok {
+		return fmt.Errorf("user %s not found", userID)
+	}
+	ud.AutopayPending = pendingPaymentID
+	ud.AutopayAttempt = at.UTC().Format(time.RFC3339)
+	db[userID] = ud
+	return s.saveUsersLocked()
+}
+
+// CompleteAutopay начисляет дни за успешный автоплатёж paymentID и снимает его с ожидания.
+// Начисление и снятие сохраняются одной записью, поэтому повторная обработка того же
+// платежа дни второй раз не начислит. Возвращает false, если платёж уже обработан.
+func (s *Store) CompleteAutopay(userID, paymentID string, days int64, now time.Time) (bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+
+	ud, ok := db[userID]
+	if !ok {
+		return false, fmt.Errorf("user %s not found", userID)
+	}
+	if paymentID == "" || ud.AutopayPending != paymentID {
+		return false, nil
+	}
+	ud.extend(time.Duration(days)*Day, now)
+	ud.AutopayPending = ""
+	ud.AutopayFailures = 0
+	db[userID] = ud
+	if err := s.saveUsersLocked(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// FailAutopay снимает с ожидания отклонённый автоплатёж paymentID и считает неудачу.
+// После maxFailures неудач подряд автопродление выключается, а сохранённый способ
+// оплаты удаляется. Возвращает число неудач подряд (0 — платёж уже обработан) и
+// признак того, что автопродление выключено.
+func (s *Store) FailAutopay(userID, paymentID string, maxFailures int, now time.Time) (int, bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+
+	ud, ok := db[userID]
+	if !ok {
+		return 0, false, fmt.Errorf("user %s not found", userID)
+	}
+	if paymentID == "" || ud.AutopayPending != paymentID {
+		return 0, false, nil
+	}
+	ud.AutopayPending = ""
+	ud.AutopayAttempt = now.UTC().Format(time.RFC3339)
+	ud.AutopayFailures++
+	failures := ud.AutopayFailures
+	disabled := failures >= maxFailures
+	if disabled {
+		ud.AutopayEnabled = false
+		ud.PaymentMethodID = ""
+		ud.AutopayFailures = 0
+	}
+	db[userID] = ud
+	if err := s.saveUsersLocked(); err != nil {
+		return 0, false, err
+	}
+	return failures, disabled, nil
 }
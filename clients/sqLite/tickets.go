@@ -0,0 +1,177 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const ticketsFile = "tickets.json"
+
+// Статусы обращения в поддержку
+const (
+	TicketOpen   = "open"
+	TicketClosed = "closed"
+)
+
+// Авторы сообщений обращения
+const (
+	TicketFromUser    = "user"
+	TicketFromSupport = "support"
+)
+
+var ErrTicketClosed = errors.New("обращение уже закрыто")
+
+// Ticket — обращение пользователя в поддержку
+type Ticket struct {
+	ID        int             `json:"id"`
+	UserID    string          `json:"user_id"`
+	Status    string          `json:"status"`
+	CreatedAt string          `json:"created_at"` // ISO8601 timestamp
+	ClosedAt  string          `json:"closed_at,omitempty"`
+	ClosedBy  string          `json:"closed_by,omitempty"` // ID закрывшего: пользователя или сотрудника поддержки
+	Messages  []TicketMessage `json:"messages"`
+	// RelayIDs — сообщения обращения в чате поддержки; ответ на любое из них уходит пользователю
+	RelayIDs []int `json:"relay_ids"`
+}
+
+// TicketMessage — сообщение в истории обращения
+type TicketMessage struct {
+	At      string `json:"at"`
+	From    string `json:"from"`               // TicketFromUser или TicketFromSupport
+	AgentID string `json:"agent_id,omitempty"` // сотрудник поддержки, ответивший пользователю
+	Text    string `json:"text,omitempty"`     // текст или подпись к вложению
+	FileID  string `json:"file_id,omitempty"`  // вложение: фото, документ, видео
+}
+
+// OpenTicketFor возвращает открытое обращение пользователя
+func (s *Store) OpenTicketFor(userID string) (Ticket, bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var tickets []Ticket
+	if err := s.loadJSONLocked(ticketsFile, &tickets); err != nil {
+		return Ticket{}, false, err
+	}
+	for _, t := range tickets {
+		if t.UserID == userID && t.Status == TicketOpen {
+			return t, true, nil
+		}
+	}
+	return Ticket{}, false, nil
+}
+
+// CreateTicket открывает обращение; если у пользователя уже есть открытое, возвращает его
+func (s *Store) CreateTicket(userID string, now time.Time) (Ticket, bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var tickets []Ticket
+	if err := s.loadJSONLocked(ticketsFile, &tickets); err != nil {
+		return Ticket{}, false, err
+	}
+	nextID := 1
+	for _, t := range tickets {
+		if t.UserID == userID && t.Status == TicketOpen {
+			return t, false, nil
+		}
+		if t.ID >= nextID {
+			nextID = t.ID + 1
+		}
+	}
+	t := Ticket{
+		ID:        nextID,
+		UserID:    userID,
+		Status:    TicketOpen,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+	}
+	tickets = append(tickets, t)
+	return t, true, s.saveJSONLocked(ticketsFile, tickets)
+}
+
+// AddTicketMessage дописывает сообщение в историю обращения. relayID — сообщение в чате
+// поддержки, ответ на которое должен уйти пользователю; 0 — не запоминать.
+func (s *Store) AddTicketMessage(ticketID int, m TicketMessage, relayID int) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var tickets []Ticket
+	if err := s.loadJSONLocked(ticketsFile, &tickets); err != nil {
+		return err
+	}
+	for i := range tickets {
+		if tickets[i].ID != ticketID {
+			continue
+		}
+		if m.At == "" {
+			m.At = time.Now().UTC().Format(time.RFC3339)
+		}
+		tickets[i].Messages = append(tickets[i].Messages, m)
+		if relayID != 0 {
+			tickets[i].RelayIDs = append(tickets[i].RelayIDs, relayID)
+		}
+		return s.saveJSONLocked(ticketsFile, tickets)
+	}
+	return fmt.Errorf("ticket %d not found", ticketID)
+}
+
+// TicketByRelay находит обращение по сообщению в чате поддержки
+func (s *Store) TicketByRelay(relayID int) (Ticket, bool, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var tickets []Ticket
+	if err := s.loadJSONLocked(ticketsFile, &tickets); err != nil {
+		return Ticket{}, false, err
+	}
+	for _, t := range tickets {
+		for _, id := range t.RelayIDs {
+			if id == relayID {
+				return t, true, nil
+			}
+		}
+	}
+	return Ticket{}, false, nil
+}
+
+// CloseTicket закрывает обращение
+func (s *Store) CloseTicket(ticketID int, closedBy string, now time.Time) (Ticket, error) {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var tickets []Ticket
+	if err := s.loadJSONLocked(ticketsFile, &tickets); err != nil {
+		return Ticket{}, err
+	}
+	for i := range tickets {
+		if tickets[i].ID != ticketID {
+			continue
+		}
+		if tickets[i].Status == TicketClosed {
+			return tickets[i], ErrTicketClosed
+		}
+		tickets[i].Status = TicketClosed
+		tickets[i].ClosedAt = now.UTC().Format(time.RFC3339)
+		tickets[i].ClosedBy = closedBy
+		return tickets[i], s.saveJSONLocked(ticketsFile, tickets)
+	}
+	return Ticket{}, fmt.Errorf("ticket %d not found", ticketID)
+}
+
+// AddTicketRelay запоминает сообщение в чате поддержки, ответ на которое уходит пользователю
+func (s *Store) AddTicketRelay(ticketID int, relayID int) error {
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	var tickets []Ticket
+	if err := s.loadJSONLocked(ticketsFile, &tickets); err != nil {
+		return err
+	}
+	for i := range tickets {
+		if tickets[i].ID == ticketID {
+			tickets[i].RelayIDs = append(tickets[i].RelayIDs, relayID)
+			return s.saveJSONLocked(ticketsFile, tickets)
+		}
+	}
+	return fmt.Errorf("ticket %d not found", ticketID)
+}
@@ -0,0 +1,228 @@
+package sqlite
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const transfersFile = "transfers.json"
+
+var (
+	ErrTransferSelf            = errors.New("нельзя перевести дни самому себе")
+	ErrTransferNoRecipient     = errors.New("получатель ещё не пользовался ботом")
+	ErrTransferInsufficient    = errors.New("на балансе недостаточно дней")
+	ErrTransferTooLarge        = errors.New("превышен лимит дней на один перевод")
+	ErrTransferTooMany         = errors.New("превышен лимит переводов за период")
+	ErrTransferFrozen          = errors.New("баланс заморожен")
+	ErrTransferFamily          = errors.New("участники семьи не могут переводить общий баланс")
+	ErrTransferUnpaid          = errors.New("переводить дни можно только после первой оплаты")
+	ErrMigrationTargetBusy     = errors.New("у получателя уже есть сертификат")
+	ErrMigrationTargetInFamily = errors.New("получатель состоит в семье, сначала он должен из неё выйти")
+)
+
+// TransferRecord — запись о переводе дней между пользователями
+type TransferRecord struct {
+	ID        string `json:"id"`
+	FromID    string `json:"from_id"`
+	ToID      string `json:"to_id"`
+	Days      int64  `json:"days"`
+	CreatedAt string `json:"created_at"`          // ISO8601 timestamp
+	ByAdmin   string `json:"by_admin,omitempty"`  // ID администратора, если перевод сделал он
+	Migration bool   `json:"migration,omitempty"` // перенос аккаунта целиком, включая сертификаты
+}
+
+// TransferLimits — ограничения самостоятельных переводов. Нулевые значения снимают ограничение.
+type TransferLimits struct {
+	MaxDays      int64         // максимум дней за один перевод
+	MaxTransfers int           // сколько переводов можно сделать за Period
+	Period       time.Duration // окно для MaxTransfers
+	// RequirePayment — переводить может только тот, у кого есть оплата без возврата: иначе
+	// приветственные дни одноразовых аккаунтов можно собирать на одном
+	RequirePayment bool
+}
+
+func (s *Store) loadTransfersLocked() ([]TransferRecord, error) {
+	var transfers []TransferRecord
+	if err := s.loadJSONLocked(transfersFile, &transfers); err != nil {
+		return nil, err
+	}
+	return transfers, nil
+}
+
+// creditDaysLocked продлевает подписку получателя так же, как AddDays
+func creditDaysLocked(userID string, days int64, now time.Time) {
+	ud := db[userID]
+	ud.extend(time.Duration(days)*Day, now)
+	db[userID] = ud
+}
+
+// TransferDays списывает дни у отправителя и начисляет получателю одной операцией.
+// limits == nil — перевод администратора без ограничений.
+func (s *Store) TransferDays(fromID, toID string, days int64, now time.Time, limits *TransferLimits, byAdmin string) (TransferRecord, error) {
+	if days <= 0 {
+		return TransferRecord{}, fmt.Errorf("days to transfer must be positive")
+	}
+	if fromID == toID {
+		return TransferRecord{}, ErrTransferSelf
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	from, ok := db[fromID]
+	if !ok {
+		return TransferRecord{}, fmt.Errorf("user %s not found", fromID)
+	}
+	if _, ok := db[toID]; !ok {
+		return TransferRecord{}, ErrTransferNoRecipient
+	}
+	if from.Frozen() {
+		return TransferRecord{}, ErrTransferFrozen
+	}
+	if from.FamilyOwner != "" && limits != nil {
+		return TransferRecord{}, ErrTransferFamily
+	}
+	if from.Remaining(now) < time.Duration(days)*Day {
+		return TransferRecord{}, ErrTransferInsufficient
+	}
+
+	transfers, err := s.loadTransfersLocked()
+	if err != nil {
+		return TransferRecord{}, err
+	}
+	if limits != nil {
+		if limits.RequirePayment {
+			payments, err := s.loadPaymentsLocked()
+			if err != nil {
+				return TransferRecord{}, err
+			}
+			if !hasPaidLocked(payments, fromID) {
+				return TransferRecord{}, ErrTransferUnpaid
+			}
+		}
+		if limits.MaxDays > 0 && days > limits.MaxDays {
+			return TransferRecord{}, ErrTransferTooLarge
+		}
+		if limits.MaxTransfers > 0 {
+			recent := 0
+			for _, t := range transfers {
+				at, err := time.Parse(time.RFC3339, t.CreatedAt)
+				if t.FromID == fromID && t.ByAdmin == "" && err == nil && now.Sub(at) < limits.Period {
+					recent++
+				}
+			}
+			if recent >= limits.MaxTransfers {
+				return TransferRecord{}, ErrTransferTooMany
+			}
+		}
+	}
+
+	from.debit(time.Duration(days)*Day, now)
+	db[fromID] = from
+	creditDaysLocked(toID, days, now)
+
+	record := TransferRecord{
+		ID:        fmt.Sprintf("tr_%d", now.UnixNano()),
+		FromID:    fromID,
+		ToID:      toID,
+		Days:      days,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		ByAdmin:   byAdmin,
+	}
+	transfers = append(transfers, record)
+	if err := s.saveJSONLocked(transfersFile, transfers); err != nil {
+		return TransferRecord{}, err
+	}
+	return record, s.saveUsersLocked()
+}
+
+// MigrateAccount переносит аккаунт целиком на новый Telegram ID: баланс, сертификаты
+// (CertRef и устройства), лимиты тарифа, контакты для чеков и автопродление.
+// Участники семьи старого аккаунта переходят к новому владельцу.
+func (s *Store) MigrateAccount(fromID, toID string, now time.Time, byAdmin string) (TransferRecord, error) {
+	if fromID == toID {
+		return TransferRecord{}, ErrTransferSelf
+	}
+
+	dbMu.Lock()
+	defer dbMu.Unlock()
+
+	s.loadUsersLocked()
+	from, ok := db[fromID]
+	if !ok {
+		return TransferRecord{}, fmt.Errorf("user %s not found", fromID)
+	}
+	to := db[toID]
+	if to.CertRef != "" || len(to.Devices) > 0 {
+		return TransferRecord{}, ErrMigrationTargetBusy
+	}
+	// владелец семьи не может сам быть участником другой семьи: GetDays разрешает только один уровень
+	if to.FamilyOwner != "" {
+		return TransferRecord{}, ErrMigrationTargetInFamily
+	}
+
+	// заморозка и льготный период переносятся как есть, если у нового аккаунта нет своего баланса
+	switch {
+	case to.Remaining(now) <= 0 && from.Frozen():
+		to.PaidUntil, to.FrozenAt = from.PaidUntil, from.FrozenAt
+		to.refresh(now)
+	case to.Remaining(now) <= 0 && from.InGrace():
+		to.PaidUntil, to.GraceUntil = from.PaidUntil, from.GraceUntil
+		to.refresh(now)
+	case from.Remaining(now) > 0:
+		to.extend(from.Remaining(now), now)
+	}
+	to.CertRef = from.CertRef
+	to.Devices = from.Devices
+	to.DeviceLimit = from.DeviceLimit
+	to.LimitsUntil = from.LimitsUntil
+	to.FamilyLimit = from.FamilyLimit
+	to.FamilyInvite = from.FamilyInvite
+	to.FreezeLimit = from.FreezeLimit
+	to.FreezeUsed = from.FreezeUsed
+	to.FreezePeriodStart = from.FreezePeriodStart
+	if to.Email == "" && to.Phone == "" {
+		to.Email, to.Phone, to.ConsentAt = from.Email, from.Phone, from.ConsentAt
+	}
+	to.AutopayEnabled, to.AutopayPlanID, to.PaymentMethodID = from.AutopayEnabled, from.AutopayPlanID, from.PaymentMethodID
+	db[toID] = to
+
+	for _, memberID := range familyMembersLocked(fromID) {
+		member := db[memberID]
+		member.FamilyOwner = toID
+		db[memberID] = member
+	}
+
+	moved := from.Days
+	from.PaidUntil = ""
+	from.CertRef = ""
+	from.Devices = nil
+	from.FamilyLimit = 0
+	from.FamilyInvite = ""
+	from.FrozenAt = ""
+	from.GraceUntil = ""
+	from.AutopayEnabled, from.AutopayPlanID, from.PaymentMethodID, from.AutopayPending = false, "", "", ""
+	from.refresh(now)
+	db[fromID] = from
+
+	transfers, err := s.loadTransfersLocked()
+	if err != nil {
+		return TransferRecord{}, err
+	}
+	record := TransferRecord{
+		ID:        fmt.Sprintf("tr_%d", now.UnixNano()),
+		FromID:    fromID,
+		ToID:      toID,
+		Days:      moved,
+		CreatedAt: now.UTC().Format(time.RFC3339),
+		ByAdmin:   byAdmin,
+		Migration: true,
+	}
+	transfers = append(transfers, record)
+	if err := s.saveJSONLocked(transfersFile, transfers); err != nil {
+		return TransferRecord{}, err
+	}
+	return record, s.saveUsersLocked()
+}
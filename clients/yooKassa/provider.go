@@ -0,0 +1,167 @@
+package yookassa
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Asort97/vpnBot/clients/payment"
+)
+
+var _ payment.PaymentProvider = (*YooKassaClient)(nil)
+
+// yookassaNotificationNets — адреса, с которых YooKassa отправляет HTTP-уведомления
+var yookassaNotificationNets = []string{
+	"185.71.76.0/27",
+	"185.71.77.0/27",
+	"77.75.153.0/25",
+	"77.75.156.11/32",
+	"77.75.156.35/32",
+	"77.75.154.128/25",
+	"2a02:5180::/32",
+}
+
+func (y *YooKassaClient) Name() string {
+	return "yookassa"
+}
+
+// CreatePayment создаёт платёж со ссылкой на оплату либо, если указан
+// PaymentMethodID, списывает деньги с сохранённого способа оплаты.
+func (y *YooKassaClient) CreatePayment(req payment.CreateRequest) (*payment.Payment, error) {
+	var (
+		resp *YooKassaPaymentResponse
+		err  error
+	)
+	if req.Currency != "" && req.Currency != "RUB" {
+		return nil, fmt.Errorf("валюта %s не поддерживается магазином", req.Currency)
+	}
+	customer := ReceiptCustomer{Email: req.Email, Phone: req.Phone}
+	if req.PaymentMethodID != "" {
+		resp, err = y.ChargeSavedPaymentMethod(req.PaymentMethodID, req.Amount, req.Description, req.ChatID, req.Metadata, customer)
+	} else {
+		resp, err = y.CreateYooKassaPayment(req.Amount, req.Description, req.ChatID, req.Description, req.Metadata, customer, req.SavePaymentMethod)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.toPayment(), nil
+}
+
+func (y *YooKassaClient) GetPayment(paymentID string) (*payment.Payment, error) {
+	resp, err := y.GetYooKassaPaymentStatus(paymentID)
+	if err != nil {
+		return nil, err
+	}
+	return resp.toPayment(), nil
+}
+
+// Refund возвращает платёж полностью или частично
+func (y *YooKassaClient) Refund(paymentID string, amount float64, currency string) error {
+	if currency == "" {
+		currency = "RUB"
+	}
+	refundReq := map[string]interface{}{
+		"payment_id": paymentID,
+		"amount": map[string]string{
+			"value":    fmt.Sprintf("%.2f", amount),
+			"currency": currency,
+		},
+	}
+
+	jsonData, err := json.Marshal(refundReq)
+	if err != nil {
+		return fmt.Errorf("не удалось подготовить тело запроса: %v", err)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	req, err := http.NewRequest("POST", "https://api.yookassa.ru/v3/refunds", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("не удалось создать запрос к YooKassa: %v", err)
+	}
+
+	auth := fmt.Sprintf("%s:%s", y.yookassaShopID, y.yookassaSecretKey)
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	req.Header.Set("Content-Type", "application/json")
+	// ключ уникален для каждого возврата: с ключом, общим для платежа, YooKassa вернула бы
+	// второй частичный возврат как повтор первого
+	req.Header.Set("Idempotence-Key", fmt.Sprintf("refund_%s_%d", paymentID, time.Now().UnixNano()))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("не удалось выполнить запрос к YooKassa: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("ошибка API YooKassa: %s, ответ: %s", resp.Status, string(body))
+	}
+	return nil
+}
+
+// VerifyNotification проверяет, что уведомление пришло с адресов YooKassa, и
+// перезапрашивает платёж через API — статусу из тела уведомления не доверяем.
+func (y *YooKassaClient) VerifyNotification(body []byte, remoteAddr string) (*payment.Payment, error) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isYooKassaAddress(ip) {
+		return nil, fmt.Errorf("уведомление с недоверенного адреса %s", remoteAddr)
+	}
+
+	var notification struct {
+		Type   string `json:"type"`
+		Event  string `json:"event"`
+		Object struct {
+			ID string `json:"id"`
+		} `json:"object"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		return nil, fmt.Errorf("не удалось разобрать уведомление: %v", err)
+	}
+	if notification.Type != "notification" || notification.Object.ID == "" {
+		return nil, fmt.Errorf("неизвестный формат уведомления")
+	}
+
+	return y.GetPayment(notification.Object.ID)
+}
+
+func isYooKassaAddress(ip net.IP) bool {
+	for _, cidr := range yookassaNotificationNets {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *YooKassaPaymentResponse) toPayment() *payment.Payment {
+	p := &payment.Payment{
+		ID:       r.ID,
+		Status:   r.Status,
+		Paid:     r.Paid,
+		Metadata: r.Metadata,
+	}
+	if v, ok := r.Amount["value"].(string); ok {
+		p.Amount, _ = strconv.ParseFloat(v, 64)
+	}
+	if v, ok := r.Amount["currency"].(string); ok {
+		p.Currency = v
+	}
+	if v, ok := r.Confirmation["confirmation_url"].(string); ok {
+		p.ConfirmationURL = v
+	}
+	if r.PaymentMethod != nil {
+		p.PaymentMethodID = r.PaymentMethod.ID
+		p.PaymentMethodSaved = r.PaymentMethod.Saved
+	}
+	return p
+}
@@ -4,37 +4,78 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"sync"
 	"time"
-
-	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
 type YooKassaClient struct {
 	yookassaShopID    string
 	yookassaSecretKey string
+	receiptSettings   ReceiptSettings
+}
+
+// ReceiptSettings — параметры фискального чека по 54-ФЗ
+type ReceiptSettings struct {
+	VatCode        int    // ставка НДС (1 — без НДС)
+	TaxSystemCode  int    // система налогообложения магазина; 0 — не передавать
+	PaymentSubject string // признак предмета расчёта, например service
+	PaymentMode    string // признак способа расчёта, например full_payment
+	Required       bool   // магазин обязан фискализировать каждый платёж
+}
+
+// DefaultReceiptSettings повторяет прежние значения чека: без НДС, услуга, полный расчёт
+func DefaultReceiptSettings() ReceiptSettings {
+	return ReceiptSettings{
+		VatCode:        1,
+		PaymentSubject: "service",
+		PaymentMode:    "full_payment",
+	}
 }
 
+// ReceiptCustomer — контакт, на который отправляется чек. Достаточно e-mail или телефона.
+type ReceiptCustomer struct {
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"` // в формате E.164 без «+», например 79001234567
+}
+
+// Empty сообщает, что отправить чек некуда
+func (c ReceiptCustomer) Empty() bool {
+	return c.Email == "" && c.Phone == ""
+}
+
+// ErrReceiptContactRequired — магазин требует фискализацию, а контакта для чека нет
+var ErrReceiptContactRequired = errors.New("для чека нужен e-mail или телефон покупателя")
+
 type YooKassaPaymentRequest struct {
 	Amount struct {
 		Value    string `json:"value"`
 		Currency string `json:"currency"`
 	} `json:"amount"`
-	Capture      bool                   `json:"capture"`
-	Confirmation map[string]interface{} `json:"confirmation"`
-	Description  string                 `json:"description"`
-	Metadata     map[string]interface{} `json:"metadata"`
-	Receipt      *Receipt               `json:"receipt,omitempty"`
+	Capture           bool                   `json:"capture"`
+	Confirmation      map[string]interface{} `json:"confirmation,omitempty"`
+	Description       string                 `json:"description"`
+	Metadata          map[string]interface{} `json:"metadata"`
+	Receipt           *Receipt               `json:"receipt,omitempty"`
+	SavePaymentMethod bool                   `json:"save_payment_method,omitempty"`
+	PaymentMethodID   string                 `json:"payment_method_id,omitempty"`
+}
+
+// PaymentMethod описывает способ оплаты, которым был проведён платёж.
+// Если Saved == true, ID можно использовать для повторных списаний без редиректа.
+type PaymentMethod struct {
+	ID    string `json:"id"`
+	Type  string `json:"type"`
+	Saved bool   `json:"saved"`
+	Title string `json:"title"`
 }
 
 type Receipt struct {
-	Customer struct {
-		Email string `json:"email"`
-	} `json:"customer"`
-	Items []ReceiptItem `json:"items"`
+	Customer      ReceiptCustomer `json:"customer"`
+	Items         []ReceiptItem   `json:"items"`
+	TaxSystemCode int             `json:"tax_system_code,omitempty"`
 }
 
 type ReceiptItem struct {
@@ -49,12 +90,6 @@ type ReceiptItem struct {
 	PaymentSubject string `json:"payment_subject"`
 }
 
-var (
-	userPayments      = make(map[int64][]string) // хранит историю платежей пользователя (последние N)
-	processedPayments = make(map[string]bool)    // ID уже обработанных платежей (idempotency)
-	payMu             sync.Mutex
-)
-
 type YooKassaPaymentResponse struct {
 	ID           string                 `json:"id"`
 	Status       string                 `json:"status"`
@@ -67,26 +102,60 @@ type YooKassaPaymentResponse struct {
 	Refundable   bool                   `json:"refundable"`
 	Metadata     map[string]interface{} `json:"metadata"`
 	Receipt      *Receipt               `json:"receipt,omitempty"`
+
+	PaymentMethod *PaymentMethod `json:"payment_method,omitempty"`
 }
 
 func New(shopID, apiKey string) *YooKassaClient {
 	return &YooKassaClient{
 		yookassaShopID:    shopID,
 		yookassaSecretKey: apiKey,
+		receiptSettings:   DefaultReceiptSettings(),
 	}
 }
 
-func (y *YooKassaClient) CreateYooKassaPayment(amount float64, description string, chatID int64, product string, extraMeta map[string]interface{}, userEmail string) (*YooKassaPaymentResponse, error) {
-	paymentReq := YooKassaPaymentRequest{}
-
-	paymentReq.Amount.Value = fmt.Sprintf("%.2f", amount)
-	paymentReq.Amount.Currency = "RUB"
-	paymentReq.Capture = true
+// SetReceiptSettings задаёт параметры чека для всех последующих платежей
+func (y *YooKassaClient) SetReceiptSettings(settings ReceiptSettings) {
+	y.receiptSettings = settings
+}
 
+func (y *YooKassaClient) CreateYooKassaPayment(amount float64, description string, chatID int64, product string, extraMeta map[string]interface{}, customer ReceiptCustomer, savePaymentMethod bool) (*YooKassaPaymentResponse, error) {
+	paymentReq, err := y.buildPaymentRequest(amount, description, chatID, product, extraMeta, customer)
+	if err != nil {
+		return nil, err
+	}
 	paymentReq.Confirmation = map[string]interface{}{
 		"type":       "redirect",
 		"return_url": "https://t.me/happyCatVpnBot",
 	}
+	paymentReq.SavePaymentMethod = savePaymentMethod
+
+	return y.postPayment(paymentReq)
+}
+
+// ChargeSavedPaymentMethod проводит повторное списание по сохранённому способу оплаты
+// (автоплатёж). Подтверждение пользователя не требуется, поэтому confirmation не передаётся.
+func (y *YooKassaClient) ChargeSavedPaymentMethod(paymentMethodID string, amount float64, description string, chatID int64, extraMeta map[string]interface{}, customer ReceiptCustomer) (*YooKassaPaymentResponse, error) {
+	if paymentMethodID == "" {
+		return nil, fmt.Errorf("не указан сохранённый способ оплаты")
+	}
+
+	paymentReq, err := y.buildPaymentRequest(amount, description, chatID, description, extraMeta, customer)
+	if err != nil {
+		return nil, err
+	}
+	paymentReq.PaymentMethodID = paymentMethodID
+	paymentReq.Metadata["autopay"] = true
+
+	return y.postPayment(paymentReq)
+}
+
+func (y *YooKassaClient) buildPaymentRequest(amount float64, description string, chatID int64, product string, extraMeta map[string]interface{}, customer ReceiptCustomer) (YooKassaPaymentRequest, error) {
+	paymentReq := YooKassaPaymentRequest{}
+
+	paymentReq.Amount.Value = fmt.Sprintf("%.2f", amount)
+	paymentReq.Amount.Currency = "RUB"
+	paymentReq.Capture = true
 
 	paymentReq.Description = description
 
@@ -100,28 +169,44 @@ func (y *YooKassaClient) CreateYooKassaPayment(amount float64, description strin
 		paymentReq.Metadata[k] = v
 	}
 
-	if userEmail != "" {
-		paymentReq.Receipt = &Receipt{
-			Items: []ReceiptItem{
-				{
-					Description: description,
-					Quantity:    "1.00",
-					Amount: struct {
-						Value    string `json:"value"`
-						Currency string `json:"currency"`
-					}{
-						Value:    fmt.Sprintf("%.2f", amount),
-						Currency: "RUB",
-					},
-					VatCode:        1,
-					PaymentMode:    "full_payment",
-					PaymentSubject: "service",
+	if customer.Empty() {
+		if y.receiptSettings.Required {
+			return paymentReq, ErrReceiptContactRequired
+		}
+	} else {
+		paymentReq.Receipt = y.receiptSettings.NewReceipt(description, amount, customer)
+	}
+
+	return paymentReq, nil
+}
+
+// NewReceipt формирует чек на одну позицию. Используется как при создании платежа
+// через API, так и в provider_data при оплате через счёт Telegram.
+func (rs ReceiptSettings) NewReceipt(description string, amount float64, customer ReceiptCustomer) *Receipt {
+	receipt := &Receipt{
+		Customer:      customer,
+		TaxSystemCode: rs.TaxSystemCode,
+		Items: []ReceiptItem{
+			{
+				Description: description,
+				Quantity:    "1.00",
+				Amount: struct {
+					Value    string `json:"value"`
+					Currency string `json:"currency"`
+				}{
+					Value:    fmt.Sprintf("%.2f", amount),
+					Currency: "RUB",
 				},
+				VatCode:        rs.VatCode,
+				PaymentMode:    rs.PaymentMode,
+				PaymentSubject: rs.PaymentSubject,
 			},
-		}
-		paymentReq.Receipt.Customer.Email = userEmail
+		},
 	}
+	return receipt
+}
 
+func (y *YooKassaClient) postPayment(paymentReq YooKassaPaymentRequest) (*YooKassaPaymentResponse, error) {
 	jsonData, err := json.Marshal(paymentReq)
 	if err != nil {
 		return nil, fmt.Errorf("не удалось подготовить тело запроса: %v", err)
@@ -193,115 +278,3 @@ func (y *YooKassaClient) GetYooKassaPaymentStatus(paymentID string) (*YooKassaPa
 
 	return &paymentResp, nil
 }
-
-func (y *YooKassaClient) sendYooKassaPaymentButton(bot *tgbotapi.BotAPI, chatID int64, messageID int, amount float64, productName string, metadata map[string]interface{}, userEmail string) (int, bool, error) {
-	payment, err := y.CreateYooKassaPayment(
-		amount,
-		productName,
-		chatID,
-		productName,
-		metadata,
-		userEmail,
-	)
-	if err != nil {
-		return messageID, false, fmt.Errorf("не удалось создать платёж: %v", err)
-	}
-
-	// записываем ID платежа в историю пользователя
-	payMu.Lock()
-	userPayments[chatID] = append(userPayments[chatID], payment.ID)
-	// ограничим историю до 5 последних записей, чтобы не разрасталась
-	if len(userPayments[chatID]) > 5 {
-		userPayments[chatID] = userPayments[chatID][len(userPayments[chatID])-5:]
-	}
-	payMu.Unlock()
-
-	confirmationURL := ""
-	if confirmation, ok := payment.Confirmation["confirmation_url"].(string); ok {
-		confirmationURL = confirmation
-	} else {
-		return messageID, false, fmt.Errorf("не получена ссылка на оплату от YooKassa")
-	}
-
-	message := fmt.Sprintf(`💳 *%s*
-
-💰 Сумма к оплате: *%.2f ₽*
-📝 Описание: %s
-
-Нажмите «Оплатить», чтобы продолжить.`,
-		productName, amount, productName)
-
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonURL("💳 Оплатить", confirmationURL),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("✅ Я оплатил", "check_payment"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в меню", "nav_menu"),
-		),
-	)
-
-	if messageID > 0 {
-		edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, message, keyboard)
-		edit.ParseMode = "Markdown"
-		if _, err := bot.Send(edit); err == nil {
-			return messageID, false, nil
-		}
-	}
-
-	msg := tgbotapi.NewMessage(chatID, message)
-	msg.ParseMode = "Markdown"
-	msg.ReplyMarkup = keyboard
-
-	sent, err := bot.Send(msg)
-	if err != nil {
-		return messageID, false, err
-	}
-
-	return sent.MessageID, true, nil
-}
-
-func (y *YooKassaClient) SendVPNPayment(bot *tgbotapi.BotAPI, chatID int64, messageID int, amount float64, productName string, metadata map[string]interface{}, userEmail string) (int, bool, error) {
-	return y.sendYooKassaPaymentButton(bot, chatID, messageID, amount, productName, metadata, userEmail)
-}
-
-// FindSucceededPayment ищет любой успешный платёж среди последних платежей пользователя.
-// Возвращает платёж и true, если найден успешно оплаченный и ещё не обработанный.
-func (y *YooKassaClient) FindSucceededPayment(chatID int64) (*YooKassaPaymentResponse, bool, error) {
-	payMu.Lock()
-	ids := append([]string(nil), userPayments[chatID]...) // копия
-	payMu.Unlock()
-
-	// обходим от самого нового к старому
-	for i := len(ids) - 1; i >= 0; i-- {
-		id := ids[i]
-		payMu.Lock()
-		already := processedPayments[id]
-		payMu.Unlock()
-		if already {
-			continue
-		}
-
-		payment, err := y.GetYooKassaPaymentStatus(id)
-		if err != nil {
-			// пропускаем сбойные
-			continue
-		}
-		if payment.Status == "succeeded" || payment.Paid {
-			payMu.Lock()
-			processedPayments[id] = true
-			payMu.Unlock()
-			return payment, true, nil
-		}
-	}
-	return nil, false, nil
-}
-
-// ClearPayments очищает историю платежей пользователя
-func (y *YooKassaClient) ClearPayments(chatID int64) {
-	payMu.Lock()
-	delete(userPayments, chatID)
-	payMu.Unlock()
-}
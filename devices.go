@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	pfsense "github.com/Asort97/vpnBot/clients/pfSense"
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	primaryDeviceName = "Основное устройство"
+	maxDeviceNameLen  = 32
+)
+
+// planDeviceLimit — сколько устройств можно подключить по тарифу
+func planDeviceLimit(plan RatePlan) int {
+	if plan.Devices < 1 {
+		return 1
+	}
+	return plan.Devices
+}
+
+func userDeviceLimit(user sqlite.UserData) int {
+	if user.DeviceLimit < 1 {
+		return 1
+	}
+	return user.DeviceLimit
+}
+
+// scheduleRevokeUser отзывает сертификаты всех устройств пользователя (и участников его семьи)
+func scheduleRevokeUser(userID string) {
+	refs, err := sqliteClient.GetFamilyCertRefs(userID)
+	if err != nil {
+		log.Printf("failed to find certrefs of user %s: %v", userID, err)
+		return
+	}
+	revokeAllCertificates(refs, nil)
+}
+
+// scheduleUnrevokeUser возвращает доступ всем устройствам пользователя (и участников его семьи).
+// Сертификаты заблокированных и приостановленных пользователей остаются отозванными.
+func scheduleUnrevokeUser(userID string) {
+	refs, err := sqliteClient.GetFamilyCertRefs(userID)
+	if err != nil {
+		log.Printf("failed to find certrefs of user %s: %v", userID, err)
+		return
+	}
+	locked := lockedCertRefs()
+	for _, ref := range refs {
+		if locked[ref] {
+			log.Printf("certificate %s belongs to a banned or suspended user, not restored", ref)
+			continue
+		}
+		scheduleUnrevoke(ref)
+	}
+}
+
+// attachUserCertificates прикрепляет к пользователю pfSense основной сертификат и
+// сертификаты всех дополнительных устройств
+func attachUserCertificates(pfsenseClient *pfsense.PfSenseClient, pfUserID, telegramUser, primaryRef string) error {
+	refs := []string{primaryRef}
+	for _, d := range sqliteClient.GetDevices(telegramUser) {
+		if d.CertRef != "" && d.CertRef != primaryRef {
+			refs = append(refs, d.CertRef)
+		}
+	}
+	return pfsenseClient.SetUserCertificates(pfUserID, refs)
+}
+
+func deviceCertName(telegramUser string, deviceID int) string {
+	return fmt.Sprintf("Cert%s_dev%d", telegramUser, deviceID)
+}
+
+// devicesKeyboard — по строке на устройство: скачать, переименовать, удалить
+func devicesKeyboard(devices []sqlite.Device, canAdd bool) tgbotapi.InlineKeyboardMarkup {
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬇️ "+primaryDeviceName, "nav_get_vpn"),
+		),
+	}
+	for _, d := range devices {
+		id := strconv.Itoa(d.ID)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬇️ "+d.Name, "dev_get_"+id),
+			tgbotapi.NewInlineKeyboardButtonData("✏️", "dev_rename_"+id),
+			tgbotapi.NewInlineKeyboardButtonData("🗑", "dev_del_"+id),
+		))
+	}
+	if canAdd {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➕ Добавить устройство", "dev_add"),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в профиль", "nav_status"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func showDevices(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, userID string, notice string) {
+	session.PendingDeviceID = 0
+	user, _ := sqliteClient.GetUser(userID)
+	limit := userDeviceLimit(user)
+	total := 1 + len(user.Devices)
+
+	var lines []string
+	lines = append(lines, "1. "+primaryDeviceName)
+	for i, d := range user.Devices {
+		lines = append(lines, fmt.Sprintf("%d. %s", i+2, html.EscapeString(d.Name)))
+	}
+
+	text := fmt.Sprintf("📱 <b>Устройства</b> (%d из %d)\n\n%s\n\nУ каждого устройства свой сертификат и файл .ovpn — так подключения не мешают друг другу.",
+		total, limit, strings.Join(lines, "\n"))
+	if total >= limit {
+		text += "\n\n💡 Чтобы подключить больше устройств, оплатите тариф с большим лимитом."
+	}
+	if notice != "" {
+		text = notice + "\n\n" + text
+	}
+
+	if err := updateSessionText(bot, chatID, session, stateDevices, text, "HTML", devicesKeyboard(user.Devices, total < limit)); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+}
+
+func handleDeviceAdd(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
+	chatID := cq.Message.Chat.ID
+	session.PendingDeviceID = 0
+	user, _ := sqliteClient.GetUser(strconv.FormatInt(cq.From.ID, 10))
+	if 1+len(user.Devices) >= userDeviceLimit(user) {
+		ackCallback(bot, cq, "Достигнут лимит устройств по тарифу")
+		return
+	}
+
+	text := "✏️ Как назвать устройство? Например: «Ноутбук» или «Телефон мамы».\n\nОтправьте название одним сообщением."
+	if err := updateSessionText(bot, chatID, session, stateDeviceName, text, "HTML", singleBackKeyboard("nav_devices")); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+	ackCallback(bot, cq, "")
+}
+
+func handleDeviceRename(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, deviceID int) {
+	chatID := cq.Message.Chat.ID
+	session.PendingDeviceID = deviceID
+	text := "✏️ Отправьте новое название устройства одним сообщением."
+	if err := updateSessionText(bot, chatID, session, stateDeviceName, text, "HTML", singleBackKeyboard("nav_devices")); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+	ackCallback(bot, cq, "")
+}
+
+// handleDeviceNameInput обрабатывает название нового устройства или переименование существующего
+func handleDeviceNameInput(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession, pfsenseClient *pfsense.PfSenseClient) {
+	chatID := msg.Chat.ID
+	userID := strconv.FormatInt(msg.From.ID, 10)
+
+	name := strings.TrimSpace(msg.Text)
+	if name == "" || utf8.RuneCountInString(name) > maxDeviceNameLen {
+		text := fmt.Sprintf("❌ Название должно быть от 1 до %d символов. Попробуйте ещё раз.", maxDeviceNameLen)
+		_ = updateSessionText(bot, chatID, session, stateDeviceName, text, "HTML", singleBackKeyboard("nav_devices"))
+		return
+	}
+
+	if session.PendingDeviceID != 0 {
+		if err := sqliteClient.RenameDevice(userID, session.PendingDeviceID, name); err != nil {
+			log.Printf("RenameDevice error: %v", err)
+		}
+		showDevices(bot, chatID, session, userID, "✅ Название сохранено")
+		return
+	}
+
+	if err := addDevice(bot, chatID, session, msg.From.ID, name, pfsenseClient); err != nil {
+		log.Printf("addDevice error: %v", err)
+		showDevices(bot, chatID, session, userID, "❌ Не удалось добавить устройство. Попробуйте позже или обратитесь в поддержку.")
+	}
+}
+
+// addDevice выпускает отдельный сертификат для нового устройства и отправляет его .ovpn
+func addDevice(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, numericUserID int64, name string, pfsenseClient *pfsense.PfSenseClient) error {
+	telegramUser := strconv.FormatInt(numericUserID, 10)
+
+	user, err := sqliteClient.GetUser(telegramUser)
+	if err != nil {
+		return err
+	}
+	if 1+len(user.Devices) >= userDeviceLimit(user) {
+		return fmt.Errorf("device limit reached")
+	}
+
+	_ = updateSessionText(bot, chatID, session, stateDevices, "Готовим конфигурацию для нового устройства...", "HTML", singleBackKeyboard("nav_devices"))
+
+	// Основной сертификат нужен, чтобы пользователь pfSense точно существовал
+	primaryRef, _, pfUserID, err := ensureUserCertificate(pfsenseClient, telegramUser)
+	if err != nil {
+		return err
+	}
+
+	deviceID := sqliteClient.NextDeviceID(telegramUser)
+	certName := deviceCertName(telegramUser, deviceID)
+	caRef, err := pfsenseClient.GetCARef()
+	if err != nil {
+		return err
+	}
+	// Отдельный CN для каждого устройства — OpenVPN не разрывает сессии с одинаковым CN
+	_, certRef, err := pfsenseClient.CreateCertificate(certName, caRef, "RSA", 2048, certificateLifetimeDays, "", "sha256", fmt.Sprintf("%s_dev%d", telegramUser, deviceID))
+	if err != nil {
+		return err
+	}
+
+	device := sqlite.Device{ID: deviceID, Name: name, CertRef: certRef, CertName: certName}
+	if err := sqliteClient.AddDevice(telegramUser, device); err != nil {
+		return err
+	}
+	if err := attachUserCertificates(pfsenseClient, pfUserID, telegramUser, primaryRef); err != nil {
+		log.Printf("attachUserCertificates error: %v", err)
+	}
+
+	enforceAccess(telegramUser)
+
+	return sendDeviceConfig(bot, chatID, session, numericUserID, device, pfsenseClient)
+}
+
+func sendDeviceConfig(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, numericUserID int64, device sqlite.Device, pfsenseClient *pfsense.PfSenseClient) error {
+	ovpnData, err := pfsenseClient.GenerateOVPN(device.CertRef, "", "213.21.200.205")
+	if err != nil {
+		return err
+	}
+
+	fileBytes := tgbotapi.FileBytes{
+		Name:  device.CertName + ".ovpn",
+		Bytes: ovpnData,
+	}
+	session.CertFileName = fileBytes.Name
+	session.CertFileBytes = ovpnData
+
+	caption := fmt.Sprintf("📱 <b>Конфигурация для устройства «%s»</b>\n\n🪪 ID: <code>%d</code>\n\nИмпортируйте файл в OpenVPN на этом устройстве. Для других устройств используйте их собственные файлы.",
+		html.EscapeString(device.Name), numericUserID)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📚 Инструкции", "nav_instructions"),
+			tgbotapi.NewInlineKeyboardButtonData("📱 Устройства", "nav_devices"),
+		),
+	)
+	return replaceSessionWithDocument(bot, chatID, session, stateDevices, fileBytes, caption, "HTML", keyboard)
+}
+
+func handleDeviceGet(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, deviceID int, pfsenseClient *pfsense.PfSenseClient) {
+	device, ok := findDevice(strconv.FormatInt(cq.From.ID, 10), deviceID)
+	if !ok {
+		ackCallback(bot, cq, "Устройство не найдено")
+		return
+	}
+	if err := sendDeviceConfig(bot, cq.Message.Chat.ID, session, cq.From.ID, device, pfsenseClient); err != nil {
+		log.Printf("sendDeviceConfig error: %v", err)
+		ackCallback(bot, cq, "Не удалось подготовить файл. Попробуйте позже.")
+		return
+	}
+	ackCallback(bot, cq, "")
+}
+
+func handleDeviceDeleteConfirm(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, deviceID int) {
+	device, ok := findDevice(strconv.FormatInt(cq.From.ID, 10), deviceID)
+	if !ok {
+		ackCallback(bot, cq, "Устройство не найдено")
+		return
+	}
+
+	text := fmt.Sprintf("🗑 Удалить устройство «%s»? Его конфигурация перестанет работать.", html.EscapeString(device.Name))
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🗑 Удалить", fmt.Sprintf("dev_delok_%d", deviceID)),
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Отмена", "nav_devices"),
+		),
+	)
+	if err := updateSessionText(bot, cq.Message.Chat.ID, session, stateDevices, text, "HTML", kb); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+	ackCallback(bot, cq, "")
+}
+
+// handleDeviceDelete открепляет и удаляет сертификат устройства в pfSense
+func handleDeviceDelete(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, deviceID int, pfsenseClient *pfsense.PfSenseClient) {
+	userID := strconv.FormatInt(cq.From.ID, 10)
+	device, err := sqliteClient.RemoveDevice(userID, deviceID)
+	if err != nil {
+		ackCallback(bot, cq, "Устройство не найдено")
+		return
+	}
+
+	if pfUserID, exists := pfsenseClient.IsUserExist(userID); exists {
+		if primaryRef, err := sqliteClient.GetCertRef(userID); err == nil && primaryRef != "" {
+			if err := attachUserCertificates(pfsenseClient, pfUserID, userID, primaryRef); err != nil {
+				log.Printf("attachUserCertificates error: %v", err)
+			}
+		}
+	}
+
+	certID, _, err := pfsenseClient.GetCertificateIDByRefid(device.CertRef)
+	if err == nil {
+		err = pfsenseClient.DeleteUserCertificate(certID)
+	}
+	if err != nil {
+		// сертификат не удалился — хотя бы отзываем его, чтобы конфиг перестал работать
+		log.Printf("delete device certificate %s error: %v", device.CertRef, err)
+		scheduleRevoke(device.CertRef)
+	}
+
+	showDevices(bot, cq.Message.Chat.ID, session, userID, fmt.Sprintf("🗑 Устройство «%s» удалено", html.EscapeString(device.Name)))
+	ackCallback(bot, cq, "")
+}
+
+func findDevice(userID string, deviceID int) (sqlite.Device, bool) {
+	for _, d := range sqliteClient.GetDevices(userID) {
+		if d.ID == deviceID {
+			return d, true
+		}
+	}
+	return sqlite.Device{}, false
+}
+
+// handleDeviceCallback разбирает callback'и вида dev_<действие>_<id>
+func handleDeviceCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, data string, pfsenseClient *pfsense.PfSenseClient) {
+	if data == "dev_add" {
+		handleDeviceAdd(bot, cq, session)
+		return
+	}
+
+	idx := strings.LastIndex(data, "_")
+	deviceID, err := strconv.Atoi(data[idx+1:])
+	if err != nil {
+		ackCallback(bot, cq, "")
+		return
+	}
+
+	switch data[:idx] {
+	case "dev_get":
+		handleDeviceGet(bot, cq, session, deviceID, pfsenseClient)
+	case "dev_rename":
+		handleDeviceRename(bot, cq, session, deviceID)
+	case "dev_del":
+		handleDeviceDeleteConfirm(bot, cq, session, deviceID)
+	case "dev_delok":
+		handleDeviceDelete(bot, cq, session, deviceID, pfsenseClient)
+	default:
+		ackCallback(bot, cq, "")
+	}
+}
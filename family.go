@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func familyInviteLink(bot *tgbotapi.BotAPI, token string) string {
+	return fmt.Sprintf("https://t.me/%s?start=family_%s", bot.Self.UserName, token)
+}
+
+func familyMemberLink(memberID string) string {
+	return fmt.Sprintf(`<a href="tg://user?id=%s">%s</a>`, memberID, memberID)
+}
+
+// showFamily показывает экран семьи: участнику — чей баланс он расходует,
+// владельцу — участников и ссылку-приглашение
+func showFamily(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, userID string, notice string) {
+	user, _ := sqliteClient.GetUser(userID)
+
+	var text string
+	var rows [][]tgbotapi.InlineKeyboardButton
+
+	switch {
+	case user.FamilyOwner != "":
+		days, _ := sqliteClient.GetDays(userID)
+		text = fmt.Sprintf("👨‍👩‍👧 <b>Семья</b>\n\nВы участник семьи %s и пользуетесь её общим балансом: <b>%d дней</b>.", familyMemberLink(user.FamilyOwner), days)
+		if user.Days > 0 {
+			text += fmt.Sprintf("\n\nВаш личный баланс (%d дней) не расходуется, пока вы в семье.", user.Days)
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🚪 Выйти из семьи", "family_leave"),
+		))
+
+	case user.FamilyLimit > 0:
+		members := sqliteClient.FamilyMembers(userID)
+		text = fmt.Sprintf("👨‍👩‍👧 <b>Семья</b> (%d из %d)\n\nУчастники пользуются вашим балансом, дни списываются один раз в сутки на всю семью.", len(members), user.FamilyLimit)
+		if len(members) > 0 {
+			var lines []string
+			for i, id := range members {
+				lines = append(lines, fmt.Sprintf("%d. %s", i+1, familyMemberLink(id)))
+			}
+			text += "\n\n" + strings.Join(lines, "\n")
+		}
+		for _, id := range members {
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("❌ Исключить "+id, "family_rm_"+id),
+			))
+		}
+		if len(members) < user.FamilyLimit {
+			token, err := sqliteClient.FamilyInvite(userID, false)
+			if err != nil {
+				log.Printf("FamilyInvite error: %v", err)
+			} else {
+				link := familyInviteLink(bot, token)
+				text += fmt.Sprintf("\n\n🔗 Ссылка-приглашение:\n<code>%s</code>", html.EscapeString(link))
+				rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+					tgbotapi.NewInlineKeyboardButtonURL("📤 Пригласить", "https://t.me/share/url?url="+url.QueryEscape(link)),
+					tgbotapi.NewInlineKeyboardButtonData("🔄 Новая ссылка", "family_link"),
+				))
+			}
+		}
+
+	default:
+		text = "👨‍👩‍👧 <b>Семья</b>\n\nС семейным тарифом вы оплачиваете один общий баланс, а близкие подключаются к нему по вашей ссылке-приглашению."
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💰 Выбрать тариф", "nav_topup"),
+		))
+	}
+
+	if notice != "" {
+		text = notice + "\n\n" + text
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в профиль", "nav_status"),
+	))
+
+	if err := updateSessionText(bot, chatID, session, stateStatus, text, "HTML", tgbotapi.NewInlineKeyboardMarkup(rows...)); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+}
+
+// joinFamily обрабатывает ссылку /start family_<токен>
+func joinFamily(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession, token string) {
+	chatID := msg.Chat.ID
+	userID := strconv.FormatInt(msg.From.ID, 10)
+
+	ownerID, err := sqliteClient.JoinFamily(token, userID)
+	if err != nil {
+		var text string
+		switch {
+		case errors.Is(err, sqlite.ErrFamilyInviteInvalid), errors.Is(err, sqlite.ErrFamilyFull),
+			errors.Is(err, sqlite.ErrFamilySelf), errors.Is(err, sqlite.ErrFamilyAlreadyMember),
+			errors.Is(err, sqlite.ErrFamilyIsOwner):
+			text = fmt.Sprintf("❌ Не удалось вступить в семью: %v.", err)
+		default:
+			log.Printf("JoinFamily error: %v", err)
+			text = "❌ Не удалось вступить в семью. Попробуйте позже."
+		}
+		_ = updateSessionText(bot, chatID, session, stateMenu, composeMenuText()+"\n\n"+text, "HTML", mainMenuInlineKeyboard())
+		return
+	}
+
+	// пока подписка владельца заморожена или приостановлена, сертификаты участника не работают
+	if accessAllowed(userID) {
+		scheduleUnrevokeUser(userID)
+	} else {
+		enforceAccess(userID)
+	}
+
+	text := "👨‍👩‍👧 <b>Вы вступили в семью!</b>\n\nДни теперь списываются с общего баланса семьи. Получите конфигурацию в разделе «🔐 Подключить VPN»."
+	_ = updateSessionText(bot, chatID, session, stateMenu, text+"\n\n<b>Выберите нужный раздел ниже:</b>", "HTML", mainMenuInlineKeyboard())
+
+	if ownerChatID, err := strconv.ParseInt(ownerID, 10, 64); err == nil {
+		notify := tgbotapi.NewMessage(ownerChatID, fmt.Sprintf("👨‍👩‍👧 %s вступил(а) в вашу семью.", familyMemberLink(userID)))
+		notify.ParseMode = "HTML"
+		bot.Send(notify)
+	}
+}
+
+// releaseFamilyMember отзывает сертификаты бывшего участника, если своих дней у него нет
+func releaseFamilyMember(memberID string) {
+	if days, _ := sqliteClient.GetDays(memberID); days <= 0 {
+		scheduleRevokeUser(memberID)
+	}
+}
+
+func handleFamilyCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, data string) {
+	chatID := cq.Message.Chat.ID
+	userID := strconv.FormatInt(cq.From.ID, 10)
+
+	switch {
+	case data == "family_link":
+		if _, err := sqliteClient.FamilyInvite(userID, true); err != nil {
+			log.Printf("FamilyInvite error: %v", err)
+		}
+		showFamily(bot, chatID, session, userID, "🔄 Старая ссылка больше не действует")
+
+	case data == "family_leave":
+		ownerID, err := sqliteClient.LeaveFamily(userID)
+		if err != nil {
+			ackCallback(bot, cq, "Вы не состоите в семье")
+			return
+		}
+		releaseFamilyMember(userID)
+		showFamily(bot, chatID, session, userID, "🚪 Вы вышли из семьи")
+		if ownerChatID, err := strconv.ParseInt(ownerID, 10, 64); err == nil {
+			notify := tgbotapi.NewMessage(ownerChatID, fmt.Sprintf("👨‍👩‍👧 %s вышел(а) из вашей семьи.", familyMemberLink(userID)))
+			notify.ParseMode = "HTML"
+			bot.Send(notify)
+		}
+
+	case strings.HasPrefix(data, "family_rm_"):
+		memberID := strings.TrimPrefix(data, "family_rm_")
+		if err := sqliteClient.RemoveFamilyMember(userID, memberID); err != nil {
+			log.Printf("RemoveFamilyMember error: %v", err)
+			ackCallback(bot, cq, "Участник не найден")
+			return
+		}
+		releaseFamilyMember(memberID)
+		showFamily(bot, chatID, session, userID, "❌ Участник исключён")
+		if memberChatID, err := strconv.ParseInt(memberID, 10, 64); err == nil {
+			bot.Send(tgbotapi.NewMessage(memberChatID, "👨‍👩‍👧 Владелец исключил вас из семьи. Пополните свой баланс, чтобы продолжить пользоваться VPN."))
+		}
+	}
+
+	ackCallback(bot, cq, "")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	pfsense "github.com/Asort97/vpnBot/clients/pfSense"
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// freezeStatusLine — строка о заморозке для профиля; пустая, если заморозка недоступна
+func freezeStatusLine(user sqlite.UserData, now time.Time) string {
+	if user.Frozen() {
+		return fmt.Sprintf("⏸ <b>Подписка заморожена</b> до %s — дни не списываются, VPN отключён.", user.FreezeEndsAt(now).Local().Format("02.01.2006 15:04"))
+	}
+	if left := user.FreezeDaysLeft(now); left > 0 && user.FamilyOwner == "" {
+		return fmt.Sprintf("⏸ Доступно дней заморозки: %d", left)
+	}
+	return ""
+}
+
+// freezeButton возвращает кнопку заморозки или возобновления, если она применима
+func freezeButton(user sqlite.UserData, now time.Time) (tgbotapi.InlineKeyboardButton, bool) {
+	if user.Frozen() {
+		return tgbotapi.NewInlineKeyboardButtonData("▶️ Возобновить", "freeze_resume"), true
+	}
+	if user.FamilyOwner == "" && user.Days > 0 && user.FreezeDaysLeft(now) > 0 {
+		return tgbotapi.NewInlineKeyboardButtonData("⏸ Заморозить", "freeze_on"), true
+	}
+	return tgbotapi.InlineKeyboardButton{}, false
+}
+
+func handleFreeze(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, pfsenseClient *pfsense.PfSenseClient) {
+	userID := strconv.FormatInt(cq.From.ID, 10)
+	now := time.Now()
+
+	if user, err := sqliteClient.GetUser(userID); err == nil && user.FamilyOwner != "" {
+		ackCallback(bot, cq, "Участники семьи не могут замораживать общий баланс")
+		return
+	}
+
+	if err := sqliteClient.Freeze(userID, now); err != nil {
+		if !errors.Is(err, sqlite.ErrAlreadyFrozen) && !errors.Is(err, sqlite.ErrNoFreezeDays) && !errors.Is(err, sqlite.ErrNothingToFreeze) {
+			log.Printf("Freeze error: %v", err)
+		}
+		ackCallback(bot, cq, fmt.Sprintf("Не удалось заморозить: %v", err))
+		return
+	}
+
+	scheduleRevokeUser(userID)
+	log.Printf("user %s froze subscription", userID)
+
+	handleStatusDirect(bot, cq.Message.Chat.ID, session, pfsenseClient, int(cq.From.ID))
+	ackCallback(bot, cq, "Подписка заморожена")
+}
+
+func handleResume(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, pfsenseClient *pfsense.PfSenseClient) {
+	userID := strconv.FormatInt(cq.From.ID, 10)
+	days, err := resumeSubscription(userID, time.Now())
+	if err != nil {
+		ackCallback(bot, cq, "Подписка не заморожена")
+		return
+	}
+
+	handleStatusDirect(bot, cq.Message.Chat.ID, session, pfsenseClient, int(cq.From.ID))
+	ackCallback(bot, cq, fmt.Sprintf("Подписка возобновлена, использовано дней заморозки: %d", days))
+}
+
+// resumeSubscription снимает заморозку и возвращает доступ всем устройствам пользователя
+func resumeSubscription(userID string, now time.Time) (int, error) {
+	days, err := sqliteClient.Resume(userID, now)
+	if err != nil {
+		return 0, err
+	}
+	scheduleUnrevokeUser(userID)
+	log.Printf("user %s resumed subscription after %d freeze day(s)", userID, days)
+	return days, nil
+}
+
+// resumeIfFrozen снимает заморозку при пополнении баланса: оплативший ждёт, что VPN заработает
+func resumeIfFrozen(userID string) {
+	if user, err := sqliteClient.GetUser(userID); err == nil && user.Frozen() {
+		if _, err := resumeSubscription(userID, time.Now()); err != nil {
+			log.Printf("resume on top-up error for user %s: %v", userID, err)
+		}
+	}
+}
+
+// checkFreezeExpired возобновляет подписку, когда лимит заморозки исчерпан
+func checkFreezeExpired(bot *tgbotapi.BotAPI, userID string, userData sqlite.UserData, now time.Time) {
+	if now.Before(userData.FreezeEndsAt(now)) {
+		return
+	}
+	if _, err := resumeSubscription(userID, now); err != nil {
+		log.Printf("auto resume error for user %s: %v", userID, err)
+		return
+	}
+	if chatID, err := strconv.ParseInt(userID, 10, 64); err == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, "▶️ Лимит заморозки закончился — подписка возобновлена, VPN снова работает."))
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	pfsense "github.com/Asort97/vpnBot/clients/pfSense"
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// giftTTLDays — сколько дней действует подарочный код (GIFT_TTL_DAYS)
+var giftTTLDays = 90
+
+func giftSelectionKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var currentRow []tgbotapi.InlineKeyboardButton
+
+	for _, plan := range visiblePlans() {
+		btn := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%.0f ₽", plan.Amount), "gift_"+plan.ID)
+		currentRow = append(currentRow, btn)
+		if len(currentRow) == 3 {
+			rows = append(rows, currentRow)
+			currentRow = nil
+		}
+	}
+	if len(currentRow) > 0 {
+		rows = append(rows, currentRow)
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в меню", "nav_menu"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func showGiftSelection(bot *tgbotapi.BotAPI, chatID int64, session *UserSession) error {
+	session.PendingPlanID = ""
+	session.PendingPromoCode = ""
+	session.GiftPurchase = false
+
+	var lines []string
+	for _, p := range visiblePlans() {
+		lines = append(lines, fmt.Sprintf("%.0f₽→%dд.", p.Amount, p.Days))
+	}
+
+	message := "🎁 <b>Подарить VPN</b>\n\nОплатите тариф — мы пришлём ссылку, которую можно переслать родным или друзьям. Дни начислятся тому, кто её откроет.\n\n" +
+		strings.Join(lines, "\n")
+
+	return updateSessionText(bot, chatID, session, stateChooseRate, message, "HTML", giftSelectionKeyboard())
+}
+
+// handleGiftPlanSelection запоминает тариф для подарка и сразу переходит к оплате.
+// Промокоды к подаркам не применяются.
+func handleGiftPlanSelection(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, plan RatePlan) {
+	session.PendingPlanID = plan.ID
+	session.PendingPromoCode = ""
+	session.GiftPurchase = true
+
+	proceedToPayment(bot, cq, session)
+}
+
+func giftLink(bot *tgbotapi.BotAPI, code string) string {
+	return fmt.Sprintf("https://t.me/%s?start=gift_%s", bot.Self.UserName, code)
+}
+
+// deliverGift выпускает подарочный код за оплаченный тариф и показывает покупателю ссылку
+func deliverGift(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, buyer *tgbotapi.User, plan RatePlan, paymentID string) (sqlite.Gift, error) {
+	buyerID := strconv.FormatInt(buyer.ID, 10)
+	gift, err := sqliteClient.CreateGift(buyerID, plan.ID, plan.Days, paymentID, time.Duration(giftTTLDays)*24*time.Hour)
+	if err != nil {
+		return gift, err
+	}
+
+	session.PendingPlanID = ""
+	session.PendingPromoCode = ""
+	session.GiftPurchase = false
+
+	link := giftLink(bot, gift.Code)
+	expires := gift.ExpiresAt
+	if t, err := time.Parse(time.RFC3339, gift.ExpiresAt); err == nil {
+		expires = t.Format("02.01.2006")
+	}
+
+	text := fmt.Sprintf(`🎁 <b>Подарок готов!</b>
+
+Тариф: «%s» (+%d дней)
+Код: <code>%s</code>
+
+Ссылка для получателя:
+%s
+
+Ссылка одноразовая и действует до %s. Мы сообщим, когда подарок активируют.`,
+		html.EscapeString(plan.Title), gift.Days, gift.Code, link, expires)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("📤 Переслать подарок", "https://t.me/share/url?url="+url.QueryEscape(link)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в меню", "nav_menu"),
+		),
+	)
+	if err := updateSessionText(bot, chatID, session, stateMenu, text, "HTML", keyboard); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+
+	notifyAdmins(bot, eventPayment, fmt.Sprintf("🎁 Пользователь id:%d купил подарок «%s», код %s", buyer.ID, plan.Title, gift.Code), buyer.UserName, buyer.ID)
+	return gift, nil
+}
+
+// redeemGift активирует подарок по ссылке /start gift_<код>: начисляет дни, выдаёт
+// конфигурацию VPN и уведомляет покупателя
+func redeemGift(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession, pfsenseClient *pfsense.PfSenseClient, code string) {
+	chatID := msg.Chat.ID
+	userID := strconv.FormatInt(msg.From.ID, 10)
+
+	gift, err := sqliteClient.RedeemGift(code, userID, time.Now())
+	if err != nil {
+		var text string
+		switch {
+		case errors.Is(err, sqlite.ErrGiftNotFound):
+			text = "❌ Подарок не найден. Проверьте ссылку."
+		case errors.Is(err, sqlite.ErrGiftRedeemed):
+			text = "❌ Этот подарок уже активирован."
+		case errors.Is(err, sqlite.ErrGiftExpired):
+			text = "❌ Срок действия подарка истёк."
+		case errors.Is(err, sqlite.ErrGiftCanceled):
+			text = "❌ Подарок отменён."
+		default:
+			log.Printf("RedeemGift error: %v", err)
+			text = "❌ Не удалось активировать подарок. Попробуйте позже или напишите в поддержку."
+		}
+		if err := updateSessionText(bot, chatID, session, stateMenu, composeMenuText()+"\n\n"+text, "HTML", mainMenuInlineKeyboard()); err != nil {
+			log.Printf("updateSessionText error: %v", err)
+		}
+		return
+	}
+
+	if err := sqliteClient.AddDays(userID, int64(gift.Days)); err != nil {
+		log.Printf("AddDays error for gift %s: %v", gift.Code, err)
+	}
+	if plan, ok := planByID(gift.PlanID); ok {
+		applyPlanLimits(userID, plan)
+	}
+	resumeIfFrozen(userID)
+
+	certRefID, _, _, err := ensureUserCertificate(pfsenseClient, userID)
+	if err == nil {
+		scheduleUnrevokeUser(userID)
+		err = sendCertificate(certRefID, userID, chatID, gift.Days, msg.From.ID, pfsenseClient, bot, session)
+	}
+	if err != nil {
+		log.Printf("gift %s provisioning error: %v", gift.Code, err)
+		text := fmt.Sprintf("🎁 <b>Подарок активирован!</b> Начислено %d дней.\n\nКонфигурацию VPN можно получить в разделе «🔐 Подключить VPN».", gift.Days)
+		_ = updateSessionText(bot, chatID, session, stateMenu, text, "HTML", mainMenuInlineKeyboard())
+	}
+
+	if gift.BuyerID != userID {
+		if buyerChatID, err := strconv.ParseInt(gift.BuyerID, 10, 64); err == nil {
+			bot.Send(tgbotapi.NewMessage(buyerChatID, fmt.Sprintf("🎉 Ваш подарок %s активирован! Получателю начислено %d дней.", gift.Code, gift.Days)))
+		}
+	}
+	notifyAdmins(bot, eventGift, fmt.Sprintf("🎁 Подарок %s активирован пользователем id:%s (+%d дней, покупатель %s)", gift.Code, userID, gift.Days, gift.BuyerID), msg.From.UserName, msg.From.ID)
+}
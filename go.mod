@@ -3,12 +3,10 @@ module github.com/Asort97/vpnBot
 go 1.25.0
 
 require (
+	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1
 	github.com/gofrs/flock v0.13.0
 	golang.org/x/crypto v0.41.0
 	software.sslmate.com/src/go-pkcs12 v0.6.0
 )
 
-require (
-	github.com/go-telegram-bot-api/telegram-bot-api/v5 v5.5.1 // indirect
-	golang.org/x/sys v0.37.0 // indirect
-)
+require golang.org/x/sys v0.37.0 // indirect
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// graceHours — сколько часов VPN продолжает работать после обнуления баланса (GRACE_HOURS, 0 — отключить)
+var graceHours = 24
+
+// startGrace начинает льготный период вместо немедленного отзыва сертификатов.
+// zeroAt — момент, когда подписка фактически закончилась. Возвращает false, если
+// льготный период отключён или уже прошёл и сертификаты нужно отозвать сразу.
+func startGrace(store *sqlite.Store, bot *tgbotapi.BotAPI, userID string, zeroAt, now time.Time) bool {
+	until := zeroAt.Add(time.Duration(graceHours) * time.Hour)
+	if graceHours <= 0 || !now.Before(until) {
+		return false
+	}
+	started, err := store.StartGrace(userID, until, now)
+	if err != nil {
+		log.Printf("StartGrace error for user %s: %v", userID, err)
+		return false
+	}
+	if !started {
+		return true
+	}
+
+	chatID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		log.Printf("failed to parse chat id %s: %v", userID, err)
+		return true
+	}
+	user, _ := store.GetUser(userID)
+	text := fmt.Sprintf("⚠️ <b>Баланс исчерпан.</b>\n\nVPN продолжит работать до %s. Если пополнить баланс раньше, доступ не прервётся, а время после обнуления будет списано из новых дней.",
+		formatUserTime(user, until))
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "HTML"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💳 Продлить", "remind_renew"),
+		),
+	)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("send grace warning to %s error: %v", userID, err)
+	}
+	return true
+}
+
+// checkGraceExpired завершает истёкший льготный период и возвращает сертификаты, которые нужно отозвать
+func checkGraceExpired(store *sqlite.Store, bot *tgbotapi.BotAPI, userID string, userData sqlite.UserData, now time.Time) []string {
+	if now.Before(userData.GraceEndsAt()) {
+		return nil
+	}
+	return expireSubscription(store, bot, userID, now)
+}
+
+// graceStatusLine — строка профиля о льготном периоде, пустая, если его нет
+func graceStatusLine(user sqlite.UserData) string {
+	if !user.InGrace() || user.Days > 0 {
+		return ""
+	}
+	return fmt.Sprintf("⚠️ <b>Баланс исчерпан:</b> VPN работает до %s. Пополните баланс, чтобы не потерять доступ.",
+		formatUserTime(user, user.GraceEndsAt()))
+}
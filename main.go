@@ -1,16 +1,20 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"html"
 	"log"
+	"math"
 	"net/mail"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	instruct "github.com/Asort97/vpnBot/clients/instruction"
+	"github.com/Asort97/vpnBot/clients/payment"
 	pfsense "github.com/Asort97/vpnBot/clients/pfSense"
 	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
 	yookassa "github.com/Asort97/vpnBot/clients/yooKassa"
@@ -31,10 +35,22 @@ const startText = `
 
 var lastActionKey = make(map[int64]map[string]time.Time)
 
-var yookassaClient *yookassa.YooKassaClient
+var paymentProvider payment.PaymentProvider
 var sqliteClient *sqlite.Store
 var privacyURL string
 
+// Способ оформления оплаты: ссылка YooKassa (по умолчанию) или нативный счёт Telegram
+const (
+	checkoutRedirect = "redirect"
+	checkoutInvoice  = "invoice"
+)
+
+var checkoutMode = checkoutRedirect
+var paymentProviderToken string
+
+// receiptSettings — параметры фискального чека (54-ФЗ), см. loadReceiptSettings
+var receiptSettings = yookassa.DefaultReceiptSettings()
+
 // pfSense async job dispatcher to run heavy revoke/unrevoke in background
 type pfOpType int
 
@@ -120,45 +136,25 @@ func scheduleUnrevoke(certRef string) {
 type SessionState string
 
 const (
-	stateMenu         SessionState = "menu"
-	stateGetVPN       SessionState = "get_vpn"
-	stateTopUp        SessionState = "top_up"
-	stateTrial        SessionState = "trial"
-	stateStatus       SessionState = "status"
-	stateSupport      SessionState = "support"
-	stateInstructions SessionState = "instructions"
-	stateChooseRate   SessionState = "choose_rate"
-	stateCollectEmail SessionState = "collect_email"
-	stateEditEmail    SessionState = "edit_email"
+	stateMenu              SessionState = "menu"
+	stateGetVPN            SessionState = "get_vpn"
+	stateTopUp             SessionState = "top_up"
+	stateTrial             SessionState = "trial"
+	stateStatus            SessionState = "status"
+	stateSupport           SessionState = "support"
+	stateInstructions      SessionState = "instructions"
+	stateChooseRate        SessionState = "choose_rate"
+	stateCollectEmail      SessionState = "collect_email"
+	stateEditEmail         SessionState = "edit_email"
+	stateEnterPromo        SessionState = "enter_promo"
+	stateDevices           SessionState = "devices"
+	stateDeviceName        SessionState = "device_name"
+	stateTransferDays      SessionState = "transfer_days"
+	stateTransferRecipient SessionState = "transfer_recipient"
+	stateBroadcastCompose  SessionState = "broadcast_compose"
+	stateBroadcastReferrer SessionState = "broadcast_referrer"
 )
 
-// RatePlan описывает тариф, который пользователь может выбрать.
-type RatePlan struct {
-	ID          string
-	Title       string
-	Amount      float64
-	Days        int
-	Description string
-}
-
-// ratePlans содержит список доступных тарифов. При необходимости поменяйте названия и цены.
-var ratePlans = []RatePlan{
-	{ID: "15d", Title: "15 дней", Amount: 25, Days: 15, Description: "Идеально, чтобы протестировать сервис или уехать на короткое время."},
-	{ID: "30d", Title: "30 дней", Amount: 50, Days: 30, Description: "Идеально, чтобы протестировать сервис или уехать на короткое время."},
-	{ID: "60d", Title: "60 дней", Amount: 100, Days: 60, Description: "Базовая подписка для постоянного доступа без ограничений."},
-	{ID: "120d", Title: "120 дней", Amount: 200, Days: 120, Description: "Полугодовой тариф со скидкой по сравнению с помесячной оплатой."},
-	{ID: "240d", Title: "240 дней", Amount: 300, Days: 240, Description: "Полугодовой тариф со скидкой по сравнению с помесячной оплатой."},
-	{ID: "365d", Title: "365 дней", Amount: 400, Days: 365, Description: "Максимальная выгода для тех, кто пользуется VPN круглый год."},
-}
-
-var ratePlanByID = func() map[string]RatePlan {
-	result := make(map[string]RatePlan)
-	for _, plan := range ratePlans {
-		result[plan.ID] = plan
-	}
-	return result
-}()
-
 type userState struct {
 	TelegramUser string
 	UserID       string
@@ -225,7 +221,7 @@ func createAndAttachCertificate(pfsenseClient *pfsense.PfSenseClient, telegramUs
 	certName := fmt.Sprintf("Cert%s_permanent", telegramUser)
 
 	if existingRefID, existingID, err := pfsenseClient.GetCertificateIDByName(certName); err == nil {
-		if err := pfsenseClient.AttachCertificateToUser(userID, existingRefID); err != nil {
+		if err := attachUserCertificates(pfsenseClient, userID, telegramUser, existingRefID); err != nil {
 			return "", "", err
 		}
 		_, expiresAt, _, _, err := pfsenseClient.GetDateOfCertificate(existingID)
@@ -245,7 +241,7 @@ func createAndAttachCertificate(pfsenseClient *pfsense.PfSenseClient, telegramUs
 		return "", "", err
 	}
 
-	if err := pfsenseClient.AttachCertificateToUser(userID, certRefID); err != nil {
+	if err := attachUserCertificates(pfsenseClient, userID, telegramUser, certRefID); err != nil {
 		return "", "", err
 	}
 
@@ -298,7 +294,7 @@ func ensureUserCertificate(pfsenseClient *pfsense.PfSenseClient, telegramUser st
 		}
 		createdNew = true
 	} else {
-		if err := pfsenseClient.AttachCertificateToUser(userID, certRefID); err != nil {
+		if err := attachUserCertificates(pfsenseClient, userID, telegramUser, certRefID); err != nil {
 			return "", "", "", err
 		}
 		if expiresAt == "" {
@@ -352,9 +348,11 @@ func issuePlanCertificate(bot *tgbotapi.BotAPI, chatID int64, session *UserSessi
 			log.Printf("sqliteClient.AddDays error: %v", err)
 		}
 	}
+	applyPlanLimits(telegramUser, plan)
+	resumeIfFrozen(telegramUser)
 
 	// Run unrevoke asynchronously to avoid blocking
-	scheduleUnrevoke(certRefID)
+	scheduleUnrevokeUser(telegramUser)
 
 	return sendCertificate(certRefID, telegramUser, chatID, plan.Days, numericUserID, pfsenseClient, bot, session)
 }
@@ -365,7 +363,7 @@ func resolvePlanFromMetadata(meta map[string]interface{}, session *UserSession)
 		if v, ok := meta["plan_id"]; ok {
 			id := fmt.Sprint(v)
 			plan.ID = id
-			if preset, ok := ratePlanByID[id]; ok {
+			if preset, ok := planByID(id); ok {
 				plan = preset
 			}
 		}
@@ -406,7 +404,7 @@ func resolvePlanFromMetadata(meta map[string]interface{}, session *UserSession)
 	}
 
 	if plan.ID != "" {
-		if preset, ok := ratePlanByID[plan.ID]; ok {
+		if preset, ok := planByID(plan.ID); ok {
 			if plan.Title == "" {
 				plan.Title = preset.Title
 			}
@@ -424,7 +422,7 @@ func resolvePlanFromMetadata(meta map[string]interface{}, session *UserSession)
 	}
 
 	if plan.Days == 0 && session != nil && session.PendingPlanID != "" {
-		if preset, ok := ratePlanByID[session.PendingPlanID]; ok {
+		if preset, ok := planByID(session.PendingPlanID); ok {
 			if plan.ID == "" {
 				plan.ID = preset.ID
 			}
@@ -447,7 +445,7 @@ func resolvePlanFromMetadata(meta map[string]interface{}, session *UserSession)
 	}
 
 	if plan.Title == "" && plan.ID != "" {
-		if preset, ok := ratePlanByID[plan.ID]; ok {
+		if preset, ok := planByID(plan.ID); ok {
 			plan.Title = preset.Title
 			if plan.Amount == 0 {
 				plan.Amount = preset.Amount
@@ -458,7 +456,7 @@ func resolvePlanFromMetadata(meta map[string]interface{}, session *UserSession)
 	}
 
 	if plan.Amount == 0 && plan.ID != "" {
-		if preset, ok := ratePlanByID[plan.ID]; ok {
+		if preset, ok := planByID(plan.ID); ok {
 			plan.Amount = preset.Amount
 		}
 	}
@@ -496,8 +494,22 @@ type UserSession struct {
 	State         SessionState
 	ContentType   string
 	PendingPlanID string
-	CertFileName  string // Имя файла сертификата для повторной отправки
-	CertFileBytes []byte // Данные сертификата для прикрепления к инструкциям
+	// PendingPromoCode — промокод, применённый к PendingPlanID на шаге «🎟 Промокод»
+	PendingPromoCode string
+	// GiftPurchase — PendingPlanID покупается в подарок, дни получит тот, кто активирует код
+	GiftPurchase bool
+	// PendingDeviceID — устройство, которое переименовывают; 0 — вводится имя нового устройства
+	PendingDeviceID int
+	// TransferDays и TransferTo — перевод дней, ожидающий подтверждения
+	TransferDays int64
+	TransferTo   string
+	// BroadcastMsgID — черновик рассылки (ID сообщения в чате администратора),
+	// BroadcastSegment — выбранный сегмент, BroadcastService — рассылка сервисная, а не рекламная
+	BroadcastMsgID   int
+	BroadcastSegment string
+	BroadcastService bool
+	CertFileName     string // Имя файла сертификата для повторной отправки
+	CertFileBytes    []byte // Данные сертификата для прикрепления к инструкциям
 }
 
 var userSessions = make(map[int64]*UserSession)
@@ -603,6 +615,9 @@ func mainMenuInlineKeyboard() tgbotapi.InlineKeyboardMarkup {
 			tgbotapi.NewInlineKeyboardButtonData("📚 Инструкции", "nav_instructions"),
 			tgbotapi.NewInlineKeyboardButtonData("💬 Поддержка", "nav_support"),
 		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎁 Подарить", "nav_gift"),
+		),
 	)
 }
 
@@ -633,7 +648,7 @@ func rateSelectionKeyboard() tgbotapi.InlineKeyboardMarkup {
 	var rows [][]tgbotapi.InlineKeyboardButton
 	var currentRow []tgbotapi.InlineKeyboardButton
 
-	for _, plan := range ratePlans {
+	for _, plan := range visiblePlans() {
 		// На кнопке оставляем только цену — описание сверху объясняет, чему соответствует цена
 		label := fmt.Sprintf("%.0f ₽", plan.Amount)
 		btn := tgbotapi.NewInlineKeyboardButtonData(label, "rate_"+plan.ID)
@@ -651,6 +666,10 @@ func rateSelectionKeyboard() tgbotapi.InlineKeyboardMarkup {
 		rows = append(rows, currentRow)
 	}
 
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⭐️ Оплатить Telegram Stars", "nav_stars"),
+	))
+
 	// Кнопка "Назад" всегда на отдельной строке
 	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
 		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в меню", "nav_menu"),
@@ -658,13 +677,66 @@ func rateSelectionKeyboard() tgbotapi.InlineKeyboardMarkup {
 	return tgbotapi.NewInlineKeyboardMarkup(rows...)
 }
 
+func starsSelectionKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var currentRow []tgbotapi.InlineKeyboardButton
+
+	for _, plan := range visiblePlans() {
+		if plan.Stars <= 0 {
+			continue
+		}
+		btn := tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("⭐️ %d", plan.Stars), "stars_"+plan.ID)
+		currentRow = append(currentRow, btn)
+		if len(currentRow) == 3 {
+			rows = append(rows, currentRow)
+			currentRow = nil
+		}
+	}
+	if len(currentRow) > 0 {
+		rows = append(rows, currentRow)
+	}
+
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("💳 Оплатить картой", "nav_topup"),
+	))
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в меню", "nav_menu"),
+	))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func showStarsSelection(bot *tgbotapi.BotAPI, chatID int64, session *UserSession) error {
+	session.PendingPlanID = ""
+	session.PendingPromoCode = ""
+	session.GiftPurchase = false
+	var lines []string
+	for _, p := range visiblePlans() {
+		if p.Stars > 0 {
+			lines = append(lines, fmt.Sprintf("⭐️%d→%dд.", p.Stars, p.Days))
+		}
+	}
+
+	message := "⭐️ <b>Оплата Telegram Stars</b>\n\n" + strings.Join(lines, "\n") +
+		"\n\n<i>Звёзды можно купить прямо в Telegram — российская карта не нужна.</i>"
+
+	return updateSessionText(bot, chatID, session, stateChooseRate, message, "HTML", starsSelectionKeyboard())
+}
 func showRateSelection(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, intro string) error {
 	session.PendingPlanID = ""
+	session.PendingPromoCode = ""
+	session.GiftPurchase = false
 	// Всегда показываем сопоставление: "цена -> дни" в заголовке.
 	var lines []string
-	for _, p := range ratePlans {
+	for _, p := range visiblePlans() {
 		// Ещё более компактный формат: "25₽→15д." (без пробелов)
-		lines = append(lines, fmt.Sprintf("%.0f₽→%dд.", p.Amount, p.Days))
+		line := fmt.Sprintf("%.0f₽→%dд.", p.Amount, p.Days)
+		if p.Devices > 1 {
+			line += fmt.Sprintf(" (%d устр.)", p.Devices)
+		}
+		if p.Family > 0 {
+			line += fmt.Sprintf(" (семья +%d)", p.Family)
+		}
+		lines = append(lines, line)
 	}
 
 	var header string
@@ -703,11 +775,32 @@ func main() {
 	botToken := os.Getenv("TG_BOT_TOKEN")
 	tlsKey := os.Getenv("TLS_CRYPT_KEY")
 	privacyURL = os.Getenv("PRIVACY_URL")
+	paymentProviderToken = os.Getenv("YOOKASSA_PROVIDER_TOKEN")
+	if mode := strings.TrimSpace(os.Getenv("CHECKOUT_MODE")); mode == checkoutInvoice && paymentProviderToken != "" {
+		checkoutMode = checkoutInvoice
+	}
 	tlsBytes, _ := os.ReadFile(tlsKey)
 
 	pfsenseClient := pfsense.New(pfsenseApiKey, []byte(tlsBytes))
-	yookassaClient = yookassa.New(yookassaStoreID, yookassaApiKey)
+	receiptSettings = loadReceiptSettings()
+	yookassaClient := yookassa.New(yookassaStoreID, yookassaApiKey)
+	yookassaClient.SetReceiptSettings(receiptSettings)
+	paymentProvider = yookassaClient
+	giftTTLDays = envInt("GIFT_TTL_DAYS", giftTTLDays)
+	loadReminderSettings()
+	graceHours = envInt("GRACE_HOURS", graceHours)
+	loadAdmins()
+	loadNotifySettings()
+	loadSupportSettings()
+	loadReferralSettings()
 	sqliteClient = sqlite.New("database/data.json")
+	if migrated, err := sqliteClient.MigrateBalances(); err != nil {
+		log.Printf("MigrateBalances error: %v", err)
+	} else if migrated > 0 {
+		log.Printf("converted %d day balance(s) to paid_until", migrated)
+	}
+	initPlanCatalog(sqliteClient)
+	go planCatalogWatcher(sqliteClient)
 
 	// Start pfSense async workers (do not block bot on revoke/unrevoke)
 	startPfWorkers(pfsenseClient, 5)
@@ -716,7 +809,11 @@ func main() {
 		log.Panic(err)
 	}
 
-	go dailyDeductWorker(sqliteClient, bot, pfsenseClient)
+	go expiryWorker(sqliteClient, bot, pfsenseClient)
+	go digestWorker(bot)
+	go referralWorker(bot)
+	startAdminPanel(bot, pfsenseClient, botToken)
+	startManagementAPI(bot, pfsenseClient)
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
@@ -730,28 +827,71 @@ func main() {
 		}
 
 		if msg := update.Message; msg != nil {
-			// handle admin commands safely only when message is present
-			if msg.Text == "/revoke" {
-				// example: schedule a test revoke without blocking
-				scheduleRevoke("68b043fdeeb8d")
+			if supportChatID != 0 && msg.Chat.ID == supportChatID {
+				handleSupportChatMessage(bot, msg)
 				continue
 			}
-			if msg.Text == "/unrevoke" {
-				// example: schedule a test unrevoke without blocking
-				scheduleUnrevoke("68b043fdeeb8d")
+			if msg.From != nil && msg.SuccessfulPayment == nil && rejectBanned(bot, msg.From.ID, msg.Chat.ID, nil) {
 				continue
 			}
-
 			handleIncomingMessage(bot, msg, pfsenseClient)
 			continue
 		}
 
 		if cq := update.CallbackQuery; cq != nil && cq.Message != nil {
+			if supportChatID != 0 && cq.Message.Chat.ID == supportChatID {
+				handleSupportChatCallback(bot, cq)
+				continue
+			}
+			if rejectBanned(bot, cq.From.ID, cq.Message.Chat.ID, cq) {
+				continue
+			}
 			handleCallback(bot, cq, pfsenseClient)
 		}
 	}
 }
 
+// loadReceiptSettings читает параметры чека из переменных окружения
+func loadReceiptSettings() yookassa.ReceiptSettings {
+	settings := yookassa.DefaultReceiptSettings()
+	settings.VatCode = envInt("YOOKASSA_VAT_CODE", settings.VatCode)
+	settings.TaxSystemCode = envInt("YOOKASSA_TAX_SYSTEM_CODE", settings.TaxSystemCode)
+	if v := strings.TrimSpace(os.Getenv("YOOKASSA_PAYMENT_SUBJECT")); v != "" {
+		settings.PaymentSubject = v
+	}
+	if v := strings.TrimSpace(os.Getenv("YOOKASSA_PAYMENT_MODE")); v != "" {
+		settings.PaymentMode = v
+	}
+	settings.Required = envBool("YOOKASSA_RECEIPT_REQUIRED", settings.Required)
+	return settings
+}
+
+func envInt(name string, def int) int {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %d", name, v, def)
+		return def
+	}
+	return n
+}
+
+func envBool(name string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(name))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid %s=%q, using %t", name, v, def)
+		return def
+	}
+	return b
+}
+
 func revokeAllCertificates(certs []string, _ *pfsense.PfSenseClient) {
 	// Schedule all revokes asynchronously; don't block caller
 	for _, ref := range certs {
@@ -768,25 +908,107 @@ func handleIncomingMessage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, pfsenseC
 	session := getSession(chatID)
 
 	if msg.SuccessfulPayment != nil {
-		plan, ok := ratePlanByID[session.PendingPlanID]
+		plan, promoCode, ok := planFromInvoicePayload(msg.SuccessfulPayment.InvoicePayload)
+		if ok && strings.HasPrefix(msg.SuccessfulPayment.InvoicePayload, giftPayloadPrefix) {
+			gift, err := deliverGift(bot, chatID, session, msg.From, plan, msg.SuccessfulPayment.TelegramPaymentChargeID)
+			if err != nil {
+				log.Printf("deliverGift error: %v", err)
+				_ = updateSessionText(bot, chatID, session, stateTopUp, "❌ Не удалось выпустить подарок. Напишите в поддержку.", "", singleBackKeyboard("nav_menu"))
+			}
+			recordInvoicePayment(msg, plan, "", gift.Code)
+			return
+		}
+		if ok {
+			if promoCode != "" {
+				// оплата уже прошла, а использование забронировано при выставлении счёта,
+				// поэтому лимиты промокода не перепроверяем
+				promo, err := sqliteClient.GetPromoCode(promoCode)
+				if err != nil {
+					log.Printf("GetPromoCode error for paid invoice of %d: %v", msg.From.ID, err)
+					notifyAdminsError(bot, fmt.Sprintf("Оплата от id:%d по промокоду %s: %v. Бонусные дни не начислены — начислите их через /grant", msg.From.ID, promoCode, err))
+				}
+				plan = applyPromo(plan, promo)
+			}
+			recordInvoicePayment(msg, plan, promoCode, "")
+		}
 		if !ok {
-			log.Printf("successful payment received but plan is unknown")
+			log.Printf("successful payment received but plan is unknown (payload %q)", msg.SuccessfulPayment.InvoicePayload)
+			notifyAdminsError(bot, fmt.Sprintf("Оплата от id:%d с неизвестным тарифом (payload %q)", msg.From.ID, msg.SuccessfulPayment.InvoicePayload))
 			_ = updateSessionText(bot, chatID, session, stateTopUp, "❌ Не нашли информацию об оплате. Напишите в поддержку.", "", singleBackKeyboard("nav_menu"))
 			return
 		}
 		if err := handleSuccessfulPayment(bot, msg, pfsenseClient, plan, session); err != nil {
 			log.Printf("handleSuccessfulPayment error: %v", err)
+			notifyAdminsError(bot, fmt.Sprintf("Оплата от id:%d прошла, но не обработана: %v", msg.From.ID, err))
 			_ = updateSessionText(bot, chatID, session, stateTopUp, "❌ Не удалось обработать оплату. Попробуйте позже.", "", singleBackKeyboard("nav_menu"))
+			return
+		}
+		if promoCode != "" {
+			redeemPromoCode(promoCode, strconv.FormatInt(msg.From.ID, 10))
 		}
 		return
 	}
 
 	if msg.IsCommand() {
+		// служебные команды доступны только администраторам с подходящей ролью
+		if roles, ok := adminCommandRoles[msg.Command()]; ok && !authorizeAdminCommand(bot, msg, roles) {
+			return
+		}
 		switch msg.Command() {
 		case "start":
 			handleStart(bot, msg, session, pfsenseClient)
 		case "referral":
 			handleReferralStats(bot, msg)
+		case "refund":
+			handleRefundCommand(bot, msg)
+		case "promo_add":
+			handlePromoAddCommand(bot, msg)
+		case "promo_list":
+			handlePromoListCommand(bot, msg)
+		case "promo_del":
+			handlePromoDeleteCommand(bot, msg)
+		case "transfer":
+			handleTransferCommand(bot, msg, session)
+		case "transfer_force":
+			handleAdminTransferCommand(bot, msg)
+		case "migrate":
+			handleMigrateCommand(bot, msg, pfsenseClient)
+		case "plans":
+			handlePlansCommand(bot, msg)
+		case "plan_set":
+			handlePlanSetCommand(bot, msg)
+		case "plan_hide":
+			handlePlanVisibilityCommand(bot, msg, true)
+		case "plan_show":
+			handlePlanVisibilityCommand(bot, msg, false)
+		case "user":
+			handleUserCommand(bot, msg)
+		case "grant":
+			handleGrantCommand(bot, msg)
+		case "revoke":
+			handleAdminRevokeCommand(bot, msg, true)
+		case "unrevoke":
+			handleAdminRevokeCommand(bot, msg, false)
+		case "resendcert":
+			handleResendCertCommand(bot, msg, pfsenseClient)
+		case "audit":
+			handleAuditCommand(bot, msg)
+		case "stats":
+			handleStatsCommand(bot, msg)
+		case "broadcast":
+			handleBroadcastCommand(bot, msg, session)
+		case "notify":
+			handleNotifyCommand(bot, msg)
+		case "ban":
+			handleBanCommand(bot, msg, false)
+		case "suspend":
+			handleBanCommand(bot, msg, true)
+		case "unban":
+			handleUnbanCommand(bot, msg)
+		case "bans":
+			handleBansCommand(bot, msg)
+		case "refreport":
+			handleReferralReportCommand(bot, msg)
 		case "pay":
 			fakeCallback := &tgbotapi.CallbackQuery{Message: msg, From: msg.From}
 			handleGetVPN(bot, fakeCallback, session, pfsenseClient)
@@ -796,14 +1018,44 @@ func handleIncomingMessage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, pfsenseC
 		return
 	}
 
-	// Обработка шага ввода e-mail для согласия с политикой
+	if session.State == stateEnterPromo {
+		handlePromoInput(bot, msg, session)
+		return
+	}
+
+	if session.State == stateDeviceName {
+		handleDeviceNameInput(bot, msg, session, pfsenseClient)
+		return
+	}
+
+	if session.State == stateBroadcastCompose && isAdmin(msg.From.ID) {
+		handleBroadcastDraft(bot, msg, session)
+		return
+	}
+
+	if session.State == stateBroadcastReferrer && isAdmin(msg.From.ID) {
+		handleBroadcastReferrerInput(bot, msg, session)
+		return
+	}
+
+	if session.State == stateTransferDays {
+		handleTransferDaysInput(bot, msg, session)
+		return
+	}
+
+	if session.State == stateTransferRecipient {
+		handleTransferRecipientInput(bot, msg, session)
+		return
+	}
+
+	// Обработка шага ввода e-mail (или телефона для чека) для согласия с политикой
 	if session.State == stateCollectEmail {
 		userID := strconv.FormatInt(msg.From.ID, 10)
-		addr, err := mail.ParseAddress(strings.TrimSpace(msg.Text))
-		if err != nil || addr.Address == "" || !strings.Contains(addr.Address, "@") {
+		email, phone, ok := parseReceiptContact(msg.Text)
+		if !ok {
 			_ = updateSessionText(
 				bot, chatID, session, stateCollectEmail,
-				"❌ Похоже, это не e-mail. Отправьте корректный адрес, например: name@example.com",
+				"❌ Похоже, это не e-mail и не телефон. Отправьте, например: name@example.com или +79001234567",
 				"HTML",
 				tgbotapi.NewInlineKeyboardMarkup(
 					tgbotapi.NewInlineKeyboardRow(
@@ -817,13 +1069,13 @@ func handleIncomingMessage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, pfsenseC
 			return
 		}
 
-		// Сохраняем e-mail и фиксируем согласие
-		_ = sqliteClient.SetEmail(userID, addr.Address)
+		// Сохраняем контакт для чека и фиксируем согласие
+		saveReceiptContact(userID, email, phone)
 		_ = sqliteClient.AcceptPrivacy(userID, time.Now())
 
 		// Переходим к оплате выбранного тарифа
 		planID := session.PendingPlanID
-		plan, ok := ratePlanByID[planID]
+		plan, ok := planByID(planID)
 		if !ok {
 			_ = updateSessionText(bot, chatID, session, stateTopUp, "❌ Не удалось определить выбранный тариф. Выберите снова.", "HTML", rateSelectionKeyboard())
 			return
@@ -836,14 +1088,14 @@ func handleIncomingMessage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, pfsenseC
 		return
 	}
 
-	// Обработка редактирования e-mail
+	// Обработка редактирования e-mail или телефона
 	if session.State == stateEditEmail {
 		userID := strconv.FormatInt(msg.From.ID, 10)
-		addr, err := mail.ParseAddress(strings.TrimSpace(msg.Text))
-		if err != nil || addr.Address == "" || !strings.Contains(addr.Address, "@") {
+		email, phone, ok := parseReceiptContact(msg.Text)
+		if !ok {
 			_ = updateSessionText(
 				bot, chatID, session, stateEditEmail,
-				"❌ Неверный формат. Отправьте корректный e-mail.",
+				"❌ Неверный формат. Отправьте корректный e-mail или телефон.",
 				"HTML",
 				tgbotapi.NewInlineKeyboardMarkup(
 					tgbotapi.NewInlineKeyboardRow(
@@ -854,12 +1106,65 @@ func handleIncomingMessage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, pfsenseC
 			return
 		}
 
-		_ = sqliteClient.SetEmail(userID, addr.Address)
+		saveReceiptContact(userID, email, phone)
 
 		// Возвращаемся к статусу без дополнительных сообщений
 		handleStatusDirect(bot, chatID, session, pfsenseClient, int(msg.From.ID))
 		return
 	}
+
+	// Остальные сообщения в разделе поддержки и при открытом обращении уходят в поддержку
+	handleTicketMessage(bot, msg, session)
+}
+
+// parseReceiptContact распознаёт e-mail или российский номер телефона для отправки чека.
+// Телефон возвращается в формате E.164 без «+», как его ожидает YooKassa.
+func parseReceiptContact(text string) (string, string, bool) {
+	text = strings.TrimSpace(text)
+	if addr, err := mail.ParseAddress(text); err == nil && strings.Contains(addr.Address, "@") {
+		return addr.Address, "", true
+	}
+
+	digits := strings.Builder{}
+	for _, r := range text {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' || r == ' ' || r == '-' || r == '(' || r == ')':
+		default:
+			return "", "", false
+		}
+	}
+	phone := digits.String()
+	if len(phone) == 11 && phone[0] == '8' {
+		phone = "7" + phone[1:]
+	}
+	if len(phone) != 11 || phone[0] != '7' {
+		return "", "", false
+	}
+	return "", phone, true
+}
+
+func saveReceiptContact(userID, email, phone string) {
+	if email != "" {
+		if err := sqliteClient.SetEmail(userID, email); err != nil {
+			log.Printf("SetEmail error: %v", err)
+		}
+	}
+	if phone != "" {
+		if err := sqliteClient.SetPhone(userID, phone); err != nil {
+			log.Printf("SetPhone error: %v", err)
+		}
+	}
+}
+
+// receiptContact возвращает контакт пользователя для чека
+func receiptContact(userID string) yookassa.ReceiptCustomer {
+	user, err := sqliteClient.GetUser(userID)
+	if err != nil {
+		return yookassa.ReceiptCustomer{}
+	}
+	return yookassa.ReceiptCustomer{Email: strings.TrimSpace(user.Email), Phone: user.Phone}
 }
 
 func handleStart(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession, pfsenseClient *pfsense.PfSenseClient) {
@@ -869,6 +1174,13 @@ func handleStart(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSessi
 	// Проверяем, новый ли пользователь
 	isNew := sqliteClient.IsNewUser(userID)
 
+	// Пользователь вернулся после блокировки бота — снова включаем его в рассылки
+	if !isNew {
+		if err := sqliteClient.SetBlocked(userID, false, time.Now()); err != nil {
+			log.Printf("SetBlocked error: %v", err)
+		}
+	}
+
 	// Парсим аргументы команды (реферальный код)
 	args := msg.CommandArguments()
 	referrerID := ""
@@ -876,43 +1188,30 @@ func handleStart(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSessi
 		referrerID = strings.TrimPrefix(args, "ref_")
 	}
 
-	// Если новый пользователь
-	if isNew {
-		// Даем 7 дней новому пользователю
-		if err := sqliteClient.AddDays(userID, 7); err != nil {
-			log.Printf("AddDays error for new user %s: %v", userID, err)
-		} else {
-			log.Printf("New user %s received 7 days welcome bonus", userID)
+	// Приглашение в семью: новый пользователь тоже получает приветственный бонус
+	if strings.HasPrefix(args, "family_") {
+		if isNew {
+			grantWelcomeBonus(userID)
 		}
+		joinFamily(bot, msg, session, strings.TrimPrefix(args, "family_"))
+		return
+	}
 
-		// Если пришел по реферальной ссылке
-		if referrerID != "" && referrerID != userID {
-			// Записываем реферала
-			if err := sqliteClient.RecordReferral(userID, referrerID); err != nil {
-				log.Printf("RecordReferral error: %v", err)
-			} else {
-				// Даем 15 дней пригласившему
-				if err := sqliteClient.AddDays(referrerID, 15); err != nil {
-					log.Printf("AddDays error for referrer %s: %v", referrerID, err)
-				} else {
-					log.Printf("Referrer %s received 15 days bonus", referrerID)
-
-					// Уведомляем пригласившего
-					referrerChatID, _ := strconv.ParseInt(referrerID, 10, 64)
-					notifyMsg := tgbotapi.NewMessage(referrerChatID, "🎉 По вашей реферальной ссылке зарегистрировался новый пользователь! Вам начислено 15 дней.")
-					notifyMsg.ParseMode = "HTML"
-					bot.Send(notifyMsg)
-
-					// Уведомляем админа о реферальной регистрации
-					sendMessageToAdmin(
-						fmt.Sprintf("🎁 Новая реферальная регистрация!\n• Новый пользователь: %s\n• Пригласивший: %s\n• Бонус рефереру: +15 дней", userID, referrerID),
-						msg.From.UserName,
-						bot,
-						msg.From.ID,
-					)
-				}
-			}
+	// Подарочная ссылка: новый пользователь получает и приветственный бонус, и дни подарка
+	if strings.HasPrefix(args, "gift_") {
+		if isNew {
+			grantWelcomeBonus(userID)
+		}
+		redeemGift(bot, msg, session, pfsenseClient, strings.TrimPrefix(args, "gift_"))
+		return
+	}
+
+	// Если новый пользователь
+	if isNew {
+		grantWelcomeBonus(userID)
 
+		// Если пришел по реферальной ссылке. Бонус пригласившему начисляется позже, см. referrals.go.
+		if referrerID != "" && referrerID != userID && registerReferral(bot, msg, userID, referrerID) {
 			// Приветствие с упоминанием реферального бонуса
 			welcomeText := startText + "\n\n🎁 <b>Вы получили 7 дней в подарок за регистрацию по реферальной ссылке!</b>"
 			if err := updateSessionText(bot, chatID, session, stateMenu, welcomeText+"\n\n<b>Выберите нужный раздел ниже:</b>", "HTML", mainMenuInlineKeyboard()); err != nil {
@@ -935,31 +1234,20 @@ func handleStart(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSessi
 	}
 }
 
-func handleReferralStats(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
-	chatID := msg.Chat.ID
-	userID := strconv.FormatInt(msg.From.ID, 10)
-
-	// Генерируем реферальную ссылку
-	botUsername := bot.Self.UserName
-	referralLink := fmt.Sprintf("https://t.me/%s?start=ref_%s", botUsername, userID)
-
-	// Получаем статистику
-	referralsCount := sqliteClient.GetReferralsCount(userID)
-
-	statsText := fmt.Sprintf(`🔗 <b>Ваша реферальная ссылка:</b>
-<code>%s</code>
+// welcomeBonusDays — подарок каждому новому пользователю, в том числе пришедшему по приглашению
+const welcomeBonusDays = 7
 
-📊 <b>Статистика:</b>
-• Приглашено пользователей: %d
-• Заработано дней: %d
-
-💡 <b>Как это работает?</b>
-• Вы получаете <b>15 дней</b> за каждого приглашенного
-• Ваш друг получает <b>7 дней</b> в подарок
-
-Поделитесь ссылкой с друзьями!`, referralLink, referralsCount, referralsCount*15)
+// grantWelcomeBonus начисляет новому пользователю welcomeBonusDays дней
+func grantWelcomeBonus(userID string) {
+	if err := sqliteClient.AddDays(userID, welcomeBonusDays); err != nil {
+		log.Printf("AddDays error for new user %s: %v", userID, err)
+	} else {
+		log.Printf("New user %s received %d days welcome bonus", userID, welcomeBonusDays)
+	}
+}
 
-	reply := tgbotapi.NewMessage(chatID, statsText)
+func handleReferralStats(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	reply := tgbotapi.NewMessage(msg.Chat.ID, referralProgramText(bot, strconv.FormatInt(msg.From.ID, 10)))
 	reply.ParseMode = "HTML"
 	bot.Send(reply)
 }
@@ -983,23 +1271,30 @@ func handleCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, pfsenseCli
 		handleStatus(bot, cq, session, pfsenseClient)
 	case data == "edit_email":
 		handleEditEmail(bot, cq, session)
+	case data == "autopay_on":
+		handleAutopayToggle(bot, cq, session, pfsenseClient, true)
+	case data == "autopay_off":
+		handleAutopayToggle(bot, cq, session, pfsenseClient, false)
 	case data == "nav_referral":
 		handleReferralCallback(bot, cq, session)
-		sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d открыл реферальную программу", cq.From.ID), cq.From.UserName, bot, int64(cq.From.ID))
+		notifyAdmins(bot, eventActivity, fmt.Sprintf("Пользователь id:%d открыл реферальную программу", cq.From.ID), cq.From.UserName, int64(cq.From.ID))
 	case data == "nav_support":
 		handleSupport(bot, cq, session)
+	case data == "ticket_close":
+		handleTicketCloseCallback(bot, cq, session)
+		return
 	case data == "nav_instructions":
 		handleInstructionsMenu(bot, cq, session)
-		sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d открыл меню инструкций", cq.From.ID), cq.From.UserName, bot, int64(cq.From.ID))
+		notifyAdmins(bot, eventActivity, fmt.Sprintf("Пользователь id:%d открыл меню инструкций", cq.From.ID), cq.From.UserName, int64(cq.From.ID))
 	case data == "windows":
 		handleInstructionSelection(bot, cq, session, instruct.Windows)
-		sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d открыл инструкцию для Windows", cq.From.ID), cq.From.UserName, bot, int64(cq.From.ID))
+		notifyAdmins(bot, eventActivity, fmt.Sprintf("Пользователь id:%d открыл инструкцию для Windows", cq.From.ID), cq.From.UserName, int64(cq.From.ID))
 	case data == "android":
 		handleInstructionSelection(bot, cq, session, instruct.Android)
-		sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d открыл инструкцию для Android", cq.From.ID), cq.From.UserName, bot, int64(cq.From.ID))
+		notifyAdmins(bot, eventActivity, fmt.Sprintf("Пользователь id:%d открыл инструкцию для Android", cq.From.ID), cq.From.UserName, int64(cq.From.ID))
 	case data == "ios":
 		handleInstructionSelection(bot, cq, session, instruct.IOS)
-		sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d открыл инструкцию для iOS", cq.From.ID), cq.From.UserName, bot, int64(cq.From.ID))
+		notifyAdmins(bot, eventActivity, fmt.Sprintf("Пользователь id:%d открыл инструкцию для iOS", cq.From.ID), cq.From.UserName, int64(cq.From.ID))
 	case strings.HasPrefix(data, "win_prev_"):
 		step, _ := strconv.Atoi(strings.TrimPrefix(data, "win_prev_"))
 		instruct.InstructionWindows(chatID, bot, step-1)
@@ -1037,11 +1332,80 @@ func handleCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, pfsenseCli
 		} else {
 			ackText = "❌ Сертификат не найден. Получите его через меню 'Подключить VPN'"
 		}
+	case data == "nav_stars":
+		if err := showStarsSelection(bot, chatID, session); err != nil {
+			log.Printf("showStarsSelection error: %v", err)
+		}
+	case strings.HasPrefix(data, "stars_"):
+		planID := strings.TrimPrefix(data, "stars_")
+		plan, ok := purchasablePlanByID(planID)
+		if !ok || plan.Stars <= 0 {
+			ackText = "❌ Неизвестный тариф"
+			break
+		}
+		if err := sendStarsInvoice(bot, chatID, session, plan); err != nil {
+			log.Printf("sendStarsInvoice error: %v", err)
+			ackText = "Не удалось сформировать счет"
+		}
+	case data == "transfer_confirm":
+		handleTransferConfirm(bot, cq, session)
+		return
+	case data == "freeze_on":
+		handleFreeze(bot, cq, session, pfsenseClient)
+		return
+	case data == "freeze_resume":
+		handleResume(bot, cq, session, pfsenseClient)
+		return
+	case data == "nav_family":
+		showFamily(bot, chatID, session, strconv.FormatInt(cq.From.ID, 10), "")
+	case strings.HasPrefix(data, "family_"):
+		handleFamilyCallback(bot, cq, session, data)
+		return
+	case strings.HasPrefix(data, "notify_toggle_"):
+		handleNotifyCallback(bot, cq, data)
+		return
+	case strings.HasPrefix(data, "bc_"):
+		handleBroadcastCallback(bot, cq, session, data)
+		return
+	case data == "unsub_marketing", data == "sub_marketing":
+		handleMarketingCallback(bot, cq, session, data == "unsub_marketing")
+		return
+	case data == "nav_reminders":
+		showReminders(bot, chatID, session, strconv.FormatInt(cq.From.ID, 10))
+	case strings.HasPrefix(data, "remind_"):
+		handleReminderCallback(bot, cq, session, data)
+		return
+	case data == "nav_devices":
+		showDevices(bot, chatID, session, strconv.FormatInt(cq.From.ID, 10), "")
+	case strings.HasPrefix(data, "dev_"):
+		handleDeviceCallback(bot, cq, session, data, pfsenseClient)
+		return
+	case data == "nav_gift":
+		if err := showGiftSelection(bot, chatID, session); err != nil {
+			log.Printf("showGiftSelection error: %v", err)
+		}
+	case strings.HasPrefix(data, "gift_"):
+		planID := strings.TrimPrefix(data, "gift_")
+		if plan, ok := purchasablePlanByID(planID); ok {
+			handleGiftPlanSelection(bot, cq, session, plan)
+			return
+		}
+		ackText = "❌ Неизвестный тариф"
+	case data == "promo_enter":
+		handlePromoEnter(bot, cq, session)
+	case data == "promo_clear":
+		session.PendingPromoCode = ""
+		if plan, ok := planByID(session.PendingPlanID); ok {
+			showPromoStep(bot, chatID, session, plan, "")
+		}
+	case data == "promo_continue":
+		proceedToPayment(bot, cq, session)
+		return
 	case data == "check_payment":
 		handleCheckPayment(bot, cq, session, pfsenseClient)
 	case strings.HasPrefix(data, "rate_"):
 		planID := strings.TrimPrefix(data, "rate_")
-		if plan, ok := ratePlanByID[planID]; ok {
+		if plan, ok := purchasablePlanByID(planID); ok {
 			handleRateSelection(bot, cq, session, plan, pfsenseClient)
 			return
 		}
@@ -1053,13 +1417,12 @@ func handleCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, pfsenseCli
 	ackCallback(bot, cq, ackText)
 }
 
-func dailyDeductWorker(store *sqlite.Store, bot *tgbotapi.BotAPI, pfsenseClient *pfsense.PfSenseClient) {
-	const (
-		checkInterval   = time.Hour
-		consumptionStep = 24 * time.Hour
-	)
+// expiryWorker следит за окончанием подписок: напоминает, запускает автопродление,
+// льготный период и отзывает сертификаты, когда оплаченное время закончилось
+func expiryWorker(store *sqlite.Store, bot *tgbotapi.BotAPI, pfsenseClient *pfsense.PfSenseClient) {
+	const checkInterval = 5 * time.Minute
 
-	ticker := time.NewTicker(checkInterval) // регулярная проверка баланса
+	ticker := time.NewTicker(checkInterval) // регулярная проверка подписок
 	defer ticker.Stop()
 
 	for range ticker.C {
@@ -1069,52 +1432,47 @@ func dailyDeductWorker(store *sqlite.Store, bot *tgbotapi.BotAPI, pfsenseClient
 		var certsToRevoke []string
 
 		for userID, userData := range users {
-			if userData.Days <= 0 {
-				continue
+			if userData.AutopayPending != "" {
+				checkPendingAutopay(store, bot, userID, userData)
 			}
 
-			lastDeduct, err := time.Parse(time.RFC3339, userData.LastDeduct)
-			if err != nil {
-				log.Printf("invalid lastDeduct for user %s: %v", userID, err)
-				continue
+			if userData.BannedAt != "" && !userData.Banned(now) {
+				liftExpiredBan(bot, userID)
 			}
 
-			elapsed := now.Sub(lastDeduct)
-			if elapsed < consumptionStep {
+			// у замороженной подписки время стоит, пока не кончится лимит заморозки
+			if userData.Frozen() {
+				checkFreezeExpired(bot, userID, userData, now)
 				continue
 			}
 
-			daysToCharge := int64(elapsed / consumptionStep)
-			if daysToCharge <= 0 {
+			// участники семьи пользуются подпиской владельца, её окончание обрабатывается у владельца
+			if userData.PaidUntil == "" || userData.FamilyOwner != "" {
 				continue
 			}
 
-			nextCheckpoint := lastDeduct.Add(time.Duration(daysToCharge) * consumptionStep)
-			remaining, err := store.ConsumeDays(userID, daysToCharge, nextCheckpoint)
-			if err != nil {
-				log.Printf("failed to deduct %d day(s) for user %s: %v", daysToCharge, userID, err)
+			if remaining := userData.Remaining(now); remaining > 0 {
+				checkExpiryReminder(store, bot, userID, userData, now)
+				if remaining <= autopayThreshold {
+					tryAutopay(store, bot, userID, userData, now)
+				}
 				continue
 			}
 
-			log.Printf("deducted %d day(s) from user %s (remaining: %d)", daysToCharge, userID, remaining)
-
-			if remaining == 0 {
-				certRef, err := store.GetCertRef(userID)
-				if err != nil {
-					log.Printf("failed to find certref of user %s: %v", userID, err)
-					continue
-				}
-				if certRef != "" {
-					certsToRevoke = append(certsToRevoke, certRef)
-				}
+			if userData.InGrace() {
+				certsToRevoke = append(certsToRevoke, checkGraceExpired(store, bot, userID, userData, now)...)
+				continue
+			}
+			if userData.AutopayPending != "" || tryAutopay(store, bot, userID, userData, now) {
+				continue
+			}
 
-				chatID, err := strconv.ParseInt(userID, 10, 64)
-				if err != nil {
-					log.Printf("failed to parse chat id %s: %v", userID, err)
-					continue
-				}
-				notifyUserSubscriptionExpired(bot, chatID)
+			// в льготный период VPN ещё работает, сертификаты отзываются по его окончании
+			paidUntil, _ := time.Parse(time.RFC3339, userData.PaidUntil)
+			if startGrace(store, bot, userID, paidUntil, now) {
+				continue
 			}
+			certsToRevoke = append(certsToRevoke, expireSubscription(store, bot, userID, now)...)
 		}
 
 		if len(certsToRevoke) > 0 {
@@ -1123,6 +1481,188 @@ func dailyDeductWorker(store *sqlite.Store, bot *tgbotapi.BotAPI, pfsenseClient
 	}
 }
 
+// expireSubscription закрывает истёкшую подписку, уведомляет пользователя и возвращает
+// сертификаты (его и всей семьи), которые нужно отозвать
+func expireSubscription(store *sqlite.Store, bot *tgbotapi.BotAPI, userID string, now time.Time) []string {
+	expired, err := store.ExpireIfDue(userID, now)
+	if err != nil {
+		log.Printf("ExpireIfDue error for user %s: %v", userID, err)
+		return nil
+	}
+	if !expired {
+		return nil
+	}
+	log.Printf("subscription of user %s expired", userID)
+
+	// участники семьи со своим балансом продолжают пользоваться VPN за свой счёт
+	detached, err := store.DetachPaidMembers(userID, now)
+	if err != nil {
+		log.Printf("DetachPaidMembers error for owner %s: %v", userID, err)
+	}
+	for _, memberID := range detached {
+		log.Printf("family member %s of expired owner %s switched to own balance", memberID, userID)
+		if chatID, err := strconv.ParseInt(memberID, 10, 64); err == nil {
+			days, _ := store.GetDays(memberID)
+			bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("👨‍👩‍👧 Подписка владельца семьи закончилась, и вы вышли из семьи. VPN продолжает работать за счёт вашего баланса: %d дн.", days)))
+		}
+	}
+
+	certRefs, err := store.GetFamilyCertRefs(userID)
+	if err != nil {
+		log.Printf("failed to find certrefs of user %s: %v", userID, err)
+		return nil
+	}
+	if chatID, err := strconv.ParseInt(userID, 10, 64); err == nil {
+		notifyUserSubscriptionExpired(bot, chatID)
+	} else {
+		log.Printf("failed to parse chat id %s: %v", userID, err)
+	}
+	return certRefs
+}
+
+const (
+	// autopayThreshold — при каком остатке подписки пытаемся продлить её автоматически
+	autopayThreshold = 24 * time.Hour
+	// autopayRetryInterval — не чаще одной попытки автосписания за этот интервал
+	autopayRetryInterval = 12 * time.Hour
+)
+
+// tryAutopay списывает стоимость выбранного тарифа с сохранённой карты пользователя.
+// Возвращает true, если дни уже начислены.
+func tryAutopay(store *sqlite.Store, bot *tgbotapi.BotAPI, userID string, userData sqlite.UserData, now time.Time) bool {
+	if !userData.AutopayEnabled || userData.PaymentMethodID == "" || userData.AutopayPending != "" || userData.Banned(now) {
+		return false
+	}
+	if last, err := time.Parse(time.RFC3339, userData.AutopayAttempt); err == nil && now.Sub(last) < autopayRetryInterval {
+		return false
+	}
+
+	plan, ok := planByID(userData.AutopayPlanID)
+	if !ok {
+		log.Printf("autopay: unknown plan %q for user %s", userData.AutopayPlanID, userID)
+		return false
+	}
+
+	chatID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		log.Printf("failed to parse chat id %s: %v", userID, err)
+		return false
+	}
+
+	metadata := map[string]interface{}{
+		"plan_id":     plan.ID,
+		"plan_title":  plan.Title,
+		"plan_days":   plan.Days,
+		"plan_amount": plan.Amount,
+	}
+	pay, err := paymentProvider.CreatePayment(payment.CreateRequest{
+		Amount:          plan.Amount,
+		Currency:        plan.Currency,
+		Description:     plan.Title,
+		ChatID:          chatID,
+		Metadata:        metadata,
+		Email:           userData.Email,
+		Phone:           userData.Phone,
+		PaymentMethodID: userData.PaymentMethodID,
+	})
+	if err != nil {
+		log.Printf("autopay charge error for user %s: %v", userID, err)
+		notifyAdminsError(bot, fmt.Sprintf("Автоплатёж id:%s не создан: %v", userID, err))
+		_ = store.SetAutopayAttempt(userID, "", now)
+		return false
+	}
+
+	// платёж запоминается до начисления: если начислить дни не удастся,
+	// checkPendingAutopay повторит попытку на следующем тике
+	if err := store.SetAutopayAttempt(userID, pay.ID, now); err != nil {
+		log.Printf("SetAutopayAttempt error for user %s: %v", userID, err)
+		notifyAdminsError(bot, fmt.Sprintf("Автоплатёж %s id:%s не сохранён: %v", pay.ID, userID, err))
+		return false
+	}
+	// незавершённый платёж проверим на следующем тике
+	return settleAutopay(store, bot, userID, chatID, plan, pay)
+}
+
+// checkPendingAutopay проверяет автоплатёж, который на момент создания ещё не был завершён
+func checkPendingAutopay(store *sqlite.Store, bot *tgbotapi.BotAPI, userID string, userData sqlite.UserData) {
+	pay, err := paymentProvider.GetPayment(userData.AutopayPending)
+	if err != nil {
+		log.Printf("autopay status error for user %s: %v", userID, err)
+		return
+	}
+
+	chatID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		log.Printf("failed to parse chat id %s: %v", userID, err)
+		return
+	}
+
+	settleAutopay(store, bot, userID, chatID, resolvePlanFromMetadata(pay.Metadata, nil), pay)
+}
+
+// autopayMaxFailures — после стольких отклонённых автосписаний подряд автопродление выключается
+const autopayMaxFailures = 3
+
+// settleAutopay обрабатывает завершённый автоплатёж, ожидающий подтверждения.
+// Возвращает true, если дни начислены.
+func settleAutopay(store *sqlite.Store, bot *tgbotapi.BotAPI, userID string, chatID int64, plan RatePlan, pay *payment.Payment) bool {
+	switch pay.Status {
+	case payment.StatusSucceeded:
+		return creditAutopay(store, bot, userID, chatID, plan, pay)
+	case payment.StatusCanceled:
+		failures, disabled, err := store.FailAutopay(userID, pay.ID, autopayMaxFailures, time.Now())
+		if err != nil {
+			log.Printf("FailAutopay error for user %s: %v", userID, err)
+			return false
+		}
+		switch {
+		case disabled:
+			log.Printf("autopay of user %s disabled after %d declined charges", userID, failures)
+			notifyAutopayDisabled(bot, chatID, plan)
+		case failures == 1:
+			notifyAutopayFailed(bot, chatID, plan)
+		}
+	}
+	return false
+}
+
+// creditAutopay записывает успешный автоплатёж и начисляет по нему дни. Пока дни не
+// начислены, платёж остаётся ожидающим, и следующий тик повторит начисление.
+func creditAutopay(store *sqlite.Store, bot *tgbotapi.BotAPI, userID string, chatID int64, plan RatePlan, pay *payment.Payment) bool {
+	recordProviderPayment(userID, pay, plan, "")
+	credited, err := store.CompleteAutopay(userID, pay.ID, int64(plan.Days), time.Now())
+	if err != nil {
+		log.Printf("autopay CompleteAutopay error for user %s: %v", userID, err)
+		notifyAdminsError(bot, fmt.Sprintf("Автоплатёж %s id:%s прошёл, но дни пока не начислены, повторим: %v", pay.ID, userID, err))
+		return false
+	}
+	if !credited {
+		// платёж уже обработан раньше
+		return false
+	}
+	applyPlanLimits(userID, plan)
+	scheduleUnrevokeUser(userID)
+
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("🔁 Подписка продлена автоматически: «%s» (+%d дней, %.0f ₽).\nОтключить автопродление можно в профиле.", plan.Title, plan.Days, plan.Amount))
+	msg.ParseMode = "HTML"
+	bot.Send(msg)
+
+	notifyAdmins(bot, eventPayment, fmt.Sprintf("Пользователь id:%s продлил подписку автоплатежом «%s»", userID, plan.Title), "", chatID)
+	return true
+}
+
+func notifyAutopayFailed(bot *tgbotapi.BotAPI, chatID int64, plan RatePlan) {
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("⚠️ Не удалось автоматически продлить подписку «%s». Проверьте карту или оплатите вручную через «💰 Пополнить баланс». Мы повторим попытку позже.", plan.Title))
+	msg.ParseMode = "HTML"
+	bot.Send(msg)
+}
+
+func notifyAutopayDisabled(bot *tgbotapi.BotAPI, chatID int64, plan RatePlan) {
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("⚠️ Автопродление подписки «%s» отключено: банк несколько раз подряд отклонил списание, карта удалена. Оплатите вручную через «💰 Пополнить баланс» — включить автопродление снова можно в профиле.", plan.Title))
+	msg.ParseMode = "HTML"
+	bot.Send(msg)
+}
+
 func notifyUserSubscriptionExpired(bot *tgbotapi.BotAPI, chatID int64) {
 	msg := tgbotapi.NewMessage(chatID, "⚠️ Баланс исчерпан! Продлите подписку, чтобы продолжить пользоваться VPN.")
 	msg.ParseMode = "HTML"
@@ -1131,6 +1671,8 @@ func notifyUserSubscriptionExpired(bot *tgbotapi.BotAPI, chatID int64) {
 
 func showMainMenu(bot *tgbotapi.BotAPI, chatID int64, session *UserSession) error {
 	session.PendingPlanID = ""
+	session.PendingPromoCode = ""
+	session.GiftPurchase = false
 	return updateSessionText(bot, chatID, session, stateMenu, composeMenuText(), "HTML", mainMenuInlineKeyboard())
 }
 
@@ -1149,28 +1691,29 @@ func handleGetVPN(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *Use
 	}
 
 	session.PendingPlanID = ""
+	session.PendingPromoCode = ""
+	session.GiftPurchase = false
 	telegramUser := fmt.Sprint(userID)
 
 	// Проверяем, новый ли пользователь, и даём бонус
 	if sqliteClient.IsNewUser(telegramUser) {
-		if err := sqliteClient.AddDays(telegramUser, 7); err != nil {
+		if err := sqliteClient.AddDays(telegramUser, welcomeBonusDays); err != nil {
 			log.Printf("AddDays error for new user %s: %v", telegramUser, err)
 		} else {
-			log.Printf("New user %s received 7 days welcome bonus via GetVPN", telegramUser)
+			log.Printf("New user %s received %d days welcome bonus via GetVPN", telegramUser, welcomeBonusDays)
 		}
 	}
 
 	certRefID, _, _, err := ensureUserCertificate(pfsenseClient, telegramUser)
 	if err != nil {
 		log.Printf("ensureUserCertificate error: %v", err)
+		notifyAdminsError(bot, fmt.Sprintf("Не удалось выпустить сертификат id:%s: %v", telegramUser, err))
 		_ = updateSessionText(bot, chatID, session, stateGetVPN, "Не удалось подготовить сертификат. Попробуйте позже или обратитесь в поддержку.", "", singleBackKeyboard("nav_menu"))
 		return
 	}
 
-	// Если на балансе 0 дней, гарантируем, что сертификат остаётся ревоукнутым (на всякий случай)
-	if days, _ := sqliteClient.GetDays(telegramUser); days <= 0 {
-		scheduleRevoke(certRefID)
-	}
+	// Без оплаченных дней, при заморозке или приостановке доступа сертификат остаётся отозванным
+	enforceAccess(telegramUser)
 
 	if err := sendCertificate(certRefID, telegramUser, chatID, 0, userID, pfsenseClient, bot, session); err != nil {
 		log.Printf("sendCertificate error: %v", err)
@@ -1178,7 +1721,7 @@ func handleGetVPN(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *Use
 		return
 	}
 
-	sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d запросил выдачу VPN-конфига", cq.From.ID), cq.From.UserName, bot, userID)
+	notifyAdmins(bot, eventActivity, fmt.Sprintf("Пользователь id:%d запросил выдачу VPN-конфига", cq.From.ID), cq.From.UserName, userID)
 }
 
 func handleTopUp(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, pfsenseClient *pfsense.PfSenseClient) {
@@ -1203,7 +1746,7 @@ func handleTopUp(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *User
 		return
 	}
 
-	sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d открыл меню пополнения.", cq.From.ID), cq.From.UserName, bot, userID)
+	notifyAdmins(bot, eventActivity, fmt.Sprintf("Пользователь id:%d открыл меню пополнения.", cq.From.ID), cq.From.UserName, userID)
 }
 
 func handleStatus(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, pfsenseClient *pfsense.PfSenseClient) {
@@ -1216,7 +1759,7 @@ func handleStatus(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *Use
 	}
 
 	handleStatusDirect(bot, chatID, session, pfsenseClient, int(userID))
-	sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d проверил статус сертификата", cq.From.ID), cq.From.UserName, bot, userID)
+	notifyAdmins(bot, eventActivity, fmt.Sprintf("Пользователь id:%d проверил статус сертификата", cq.From.ID), cq.From.UserName, userID)
 }
 
 func handleStatusDirect(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, pfsenseClient *pfsense.PfSenseClient, userID int) {
@@ -1225,20 +1768,59 @@ func handleStatusDirect(bot *tgbotapi.BotAPI, chatID int64, session *UserSession
 		log.Printf("buildStatusText error: %v", err)
 		text = "❌ Не удалось получить информацию о сертификате. Попробуйте позже."
 	}
-	email, _ := sqliteClient.GetEmail(strconv.Itoa(userID))
-	if strings.TrimSpace(email) == "" {
+	contact := receiptContact(strconv.Itoa(userID))
+	email := contact.Email
+	if email == "" {
 		email = "—"
 	}
+	phoneLine := ""
+	if contact.Phone != "" {
+		phoneLine = fmt.Sprintf("├ 📞 Телефон: +%s\n", contact.Phone)
+	}
 	finalText := fmt.Sprintf(
 		"<b>👤 Профиль:</b>\n"+
 			"├ 🪪 ID: <code>%d</code>\n"+
+			"%s"+
 			"└ ✉️ Mail: %s\n"+
 			"%s",
-		userID, email, text,
+		userID, phoneLine, email, text,
 	)
+	autopayButton := tgbotapi.NewInlineKeyboardButtonData("🔁 Включить автопродление", "autopay_on")
+	user, userErr := sqliteClient.GetUser(strconv.Itoa(userID))
+	if line := freezeStatusLine(user, time.Now()); userErr == nil && line != "" {
+		finalText += "\n\n" + line
+	}
+	if line := graceStatusLine(user); userErr == nil && line != "" {
+		finalText += "\n\n" + line
+	}
+	if userErr == nil && user.AutopayEnabled {
+		planTitle := user.AutopayPlanID
+		if plan, ok := planByID(user.AutopayPlanID); ok {
+			planTitle = plan.Title
+		}
+		if user.PaymentMethodID != "" {
+			finalText += fmt.Sprintf("\n\n🔁 <b>Автопродление:</b> включено (%s)", html.EscapeString(planTitle))
+		} else {
+			finalText += "\n\n🔁 <b>Автопродление:</b> карта будет сохранена при следующей оплате"
+		}
+		autopayButton = tgbotapi.NewInlineKeyboardButtonData("⛔️ Отключить автопродление", "autopay_off")
+	}
 	kb := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("✏️ Изменить e-mail", "edit_email"),
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Изменить e-mail / телефон", "edit_email"),
+		),
+		tgbotapi.NewInlineKeyboardRow(autopayButton),
+	)
+	if btn, ok := freezeButton(user, time.Now()); userErr == nil && ok {
+		kb.InlineKeyboard = append(kb.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(btn))
+	}
+	kb.InlineKeyboard = append(kb.InlineKeyboard,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("📱 Устройства", "nav_devices"),
+			tgbotapi.NewInlineKeyboardButtonData("👨‍👩‍👧 Семья", "nav_family"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔔 Напоминания", "nav_reminders"),
 		),
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в меню", "nav_menu"),
@@ -1251,7 +1833,7 @@ func handleStatusDirect(bot *tgbotapi.BotAPI, chatID int64, session *UserSession
 
 func handleEditEmail(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
 	chatID := cq.Message.Chat.ID
-	text := "✉️ Отправьте новый e-mail одним сообщением:"
+	text := "✉️ Отправьте новый e-mail или телефон для чеков одним сообщением:"
 	kb := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
 			tgbotapi.NewInlineKeyboardButtonData("⬅️ Отмена", "nav_status"),
@@ -1260,48 +1842,59 @@ func handleEditEmail(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *
 	if err := updateSessionText(bot, chatID, session, stateEditEmail, text, "HTML", kb); err != nil {
 		log.Printf("updateSessionText error: %v", err)
 	}
-	ackCallback(bot, cq, "✏️ Введите новый e-mail")
-}
-
-func handleSupport(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
-	chatID := cq.Message.Chat.ID
-	supportText := `📞 <b>Служба поддержки HappyCat VPN</b>
-
-Напиши нам в Telegram: @happycatvpn
-<i>Мы отвечаем 24/7 и всегда рядом, если нужна помощь.</i>`
-
-	if err := updateSessionText(bot, chatID, session, stateSupport, supportText, "HTML", singleBackKeyboard("nav_menu")); err != nil {
-		log.Printf("updateSessionText error: %v", err)
-	}
-
-	sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d открыл раздел поддержки", cq.From.ID), cq.From.UserName, bot, int64(cq.From.ID))
+	ackCallback(bot, cq, "✏️ Введите новый e-mail или телефон")
 }
 
-func handleReferralCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
+// handleAutopayToggle включает автопродление (карта сохраняется при следующей оплате)
+// или отключает его вместе с удалением сохранённого способа оплаты.
+func handleAutopayToggle(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, pfsenseClient *pfsense.PfSenseClient, enable bool) {
 	chatID := cq.Message.Chat.ID
 	userID := strconv.FormatInt(cq.From.ID, 10)
 
-	botUsername := bot.Self.UserName
-	referralLink := fmt.Sprintf("https://t.me/%s?start=ref_%s", botUsername, userID)
-
-	referralsCount := sqliteClient.GetReferralsCount(userID)
-
-	statsText := fmt.Sprintf(`🎁 <b>Реферальная программа</b>
+	if err := sqliteClient.SetAutopay(userID, enable); err != nil {
+		log.Printf("SetAutopay error: %v", err)
+		ackCallback(bot, cq, "❌ Не удалось изменить настройки автопродления")
+		return
+	}
 
-🔗 <b>Ваша ссылка:</b>
-<code>%s</code>
+	if !enable {
+		handleStatusDirect(bot, chatID, session, pfsenseClient, int(cq.From.ID))
+		ackCallback(bot, cq, "Автопродление отключено, карта удалена")
+		return
+	}
 
-📊 <b>Статистика:</b>
-• Приглашено: %d чел.
-• Заработано: %d дней
+	user, _ := sqliteClient.GetUser(userID)
+	if user.PaymentMethodID != "" {
+		handleStatusDirect(bot, chatID, session, pfsenseClient, int(cq.From.ID))
+		ackCallback(bot, cq, "Автопродление включено")
+		return
+	}
 
-💡 <b>Условия:</b>
-• Вы: <b>+15 дней</b> за каждого друга
-• Друг: <b>+7 дней</b> в подарок
+	intro := "🔁 <b>Автопродление</b>\nВыберите тариф и оплатите его картой — мы сохраним её и будем продлевать подписку автоматически, когда баланс подойдёт к концу."
+	if err := showRateSelection(bot, chatID, session, intro); err != nil {
+		log.Printf("showRateSelection error: %v", err)
+	}
+	ackCallback(bot, cq, "Выберите тариф для автопродления")
+}
 
-Поделитесь ссылкой и получайте дни!`, referralLink, referralsCount, referralsCount*15)
+// rememberPaymentMethod сохраняет способ оплаты из успешного платежа, если пользователь
+// включил автопродление и YooKassa подтвердила сохранение.
+func rememberPaymentMethod(userID string, pay *payment.Payment, plan RatePlan) {
+	if pay == nil || pay.PaymentMethodID == "" || !pay.PaymentMethodSaved {
+		return
+	}
+	user, err := sqliteClient.GetUser(userID)
+	if err != nil || !user.AutopayEnabled {
+		return
+	}
+	if err := sqliteClient.SavePaymentMethod(userID, pay.PaymentMethodID, plan.ID); err != nil {
+		log.Printf("SavePaymentMethod error: %v", err)
+	}
+}
 
-	if err := updateSessionText(bot, chatID, session, stateMenu, statsText, "HTML", singleBackKeyboard("nav_menu")); err != nil {
+func handleReferralCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
+	text := referralProgramText(bot, strconv.FormatInt(cq.From.ID, 10))
+	if err := updateSessionText(bot, cq.Message.Chat.ID, session, stateMenu, text, "HTML", singleBackKeyboard("nav_menu")); err != nil {
 		log.Printf("updateSessionText error: %v", err)
 	}
 }
@@ -1319,14 +1912,31 @@ func handleRateSelection(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, sessi
 	chatID := cq.Message.Chat.ID
 	_ = pfsenseClient
 
-	// Сохраняем выбранный тариф, чтобы вернуться к оплате после ввода e-mail
+	// Сохраняем выбранный тариф, чтобы вернуться к оплате после промокода и ввода e-mail
 	session.PendingPlanID = plan.ID
+	session.PendingPromoCode = ""
+	session.GiftPurchase = false
+
+	showPromoStep(bot, chatID, session, plan, "")
+	ackCallback(bot, cq, "")
+}
+
+// proceedToPayment запрашивает контакт для чека (если его ещё нет) и выставляет счёт
+// за выбранный тариф с учётом промокода из сессии.
+func proceedToPayment(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
+	chatID := cq.Message.Chat.ID
+	plan, ok := planByID(session.PendingPlanID)
+	if !ok {
+		_ = updateSessionText(bot, chatID, session, stateTopUp, "❌ Не удалось определить выбранный тариф. Выберите снова.", "HTML", rateSelectionKeyboard())
+		ackCallback(bot, cq, "")
+		return
+	}
 
-	// Проверяем наличие e-mail
+	// Проверяем наличие контакта для чека
 	userID := strconv.FormatInt(cq.From.ID, 10)
-	if email, _ := sqliteClient.GetEmail(userID); strings.TrimSpace(email) == "" {
+	if receiptContact(userID).Empty() {
 		text := fmt.Sprintf(
-			"Укажите e-mail, написав его в чате. Продолжая вы подтверждаете согласие с <a href=\"%s\">Политикой конфиденциальности</a>.\n\nОтправьте ваш e-mail одним сообщением.",
+			"Укажите e-mail или телефон для чека, написав его в чате. Продолжая вы подтверждаете согласие с <a href=\"%s\">Политикой конфиденциальности</a>.\n\nОтправьте e-mail или телефон одним сообщением.",
 			getPrivacyURL(),
 		)
 		kb := tgbotapi.NewInlineKeyboardMarkup(
@@ -1344,7 +1954,7 @@ func handleRateSelection(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, sessi
 		return
 	}
 
-	// Если e-mail уже есть — фиксируем согласие и продолжаем к оплате
+	// Если контакт уже есть — фиксируем согласие и продолжаем к оплате
 	_ = sqliteClient.AcceptPrivacy(userID, time.Now())
 	if err := startPaymentForPlan(bot, chatID, session, plan); err != nil {
 		log.Printf("startPaymentForPlan error: %v", err)
@@ -1357,6 +1967,16 @@ func handleRateSelection(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, sessi
 }
 
 func startPaymentForPlan(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, plan RatePlan) error {
+	if checkoutMode == checkoutInvoice {
+		return sendPlanInvoice(bot, chatID, session, plan)
+	}
+
+	basePlanID := plan.ID
+	plan, promo, err := applySessionPromo(session, strconv.FormatInt(chatID, 10), plan)
+	if err != nil {
+		return err
+	}
+
 	metadataPlanID := plan.ID
 	if metadataPlanID == "" {
 		metadataPlanID = strings.ReplaceAll(strings.ToLower(plan.Title), " ", "_")
@@ -1367,25 +1987,135 @@ func startPaymentForPlan(bot *tgbotapi.BotAPI, chatID int64, session *UserSessio
 		"plan_days":   plan.Days,
 		"plan_amount": plan.Amount,
 	}
+	if promo.Code != "" {
+		metadata["promo_code"] = promo.Code
+		metadata["bonus_days"] = promo.BonusDays
+	}
+	if session.GiftPurchase {
+		metadata["gift"] = true
+	}
+
+	// Передаём e-mail или телефон в YooKassa, чтобы сформировать чек
+	contact := receiptContact(strconv.FormatInt(chatID, 10))
+	if receiptSettings.Required && contact.Empty() {
+		return yookassa.ErrReceiptContactRequired
+	}
 
-	// Попытаемся передать e-mail в YooKassa, чтобы сформировать чек
-	email, _ := sqliteClient.GetEmail(strconv.FormatInt(chatID, 10))
-	newID, replaced, err := yookassaClient.SendVPNPayment(bot, chatID, session.MessageID, plan.Amount, plan.Title, metadata, email)
+	// При включённом автопродлении просим YooKassa сохранить способ оплаты
+	savePaymentMethod := false
+	if user, err := sqliteClient.GetUser(strconv.FormatInt(chatID, 10)); err == nil {
+		savePaymentMethod = user.AutopayEnabled && user.PaymentMethodID == "" && !session.GiftPurchase
+	}
+
+	pay, err := paymentProvider.CreatePayment(payment.CreateRequest{
+		Amount:            plan.Amount,
+		Currency:          plan.Currency,
+		Description:       plan.Title,
+		ChatID:            chatID,
+		Metadata:          metadata,
+		Email:             contact.Email,
+		Phone:             contact.Phone,
+		SavePaymentMethod: savePaymentMethod,
+	})
 	if err != nil {
+		return fmt.Errorf("не удалось создать платёж: %v", err)
+	}
+	if pay.ConfirmationURL == "" {
+		return fmt.Errorf("не получена ссылка на оплату")
+	}
+
+	trackPayment(chatID, pay.ID)
+
+	if err := sendPaymentButton(bot, chatID, session, plan.Title, plan.Amount, pay.ConfirmationURL, savePaymentMethod); err != nil {
 		return err
 	}
+	session.PendingPlanID = basePlanID
 
-	if replaced && session.MessageID != 0 && session.MessageID != newID {
-		_, _ = bot.Send(tgbotapi.NewDeleteMessage(chatID, session.MessageID))
+	return nil
+}
+
+// sendPaymentButton показывает сумму к оплате и кнопку со ссылкой провайдера
+func sendPaymentButton(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, productName string, amount float64, confirmationURL string, savePaymentMethod bool) error {
+	message := fmt.Sprintf(`💳 *%s*
+
+💰 Сумма к оплате: *%.2f ₽*
+📝 Описание: %s
+
+Нажмите «Оплатить», чтобы продолжить.`,
+		productName, amount, productName)
+	if savePaymentMethod {
+		message += "\n\n🔁 Карта будет сохранена для автопродления. Отключить можно в профиле."
 	}
 
-	session.MessageID = newID
-	session.State = stateTopUp
-	session.ContentType = "text"
-	session.PendingPlanID = metadataPlanID
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("💳 Оплатить", confirmationURL),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Я оплатил", "check_payment"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в меню", "nav_menu"),
+		),
+	)
 
-	instruct.ResetState(chatID)
-	return nil
+	return updateSessionText(bot, chatID, session, stateTopUp, message, "Markdown", keyboard)
+}
+
+var (
+	userPayments      = make(map[int64][]string) // хранит историю платежей пользователя (последние N)
+	processedPayments = make(map[string]bool)    // ID уже обработанных платежей (idempotency)
+	payMu             sync.Mutex
+)
+
+// trackPayment записывает ID платежа в историю пользователя для кнопки «Я оплатил»
+func trackPayment(chatID int64, paymentID string) {
+	payMu.Lock()
+	defer payMu.Unlock()
+	userPayments[chatID] = append(userPayments[chatID], paymentID)
+	// ограничим историю до 5 последних записей, чтобы не разрасталась
+	if len(userPayments[chatID]) > 5 {
+		userPayments[chatID] = userPayments[chatID][len(userPayments[chatID])-5:]
+	}
+}
+
+// findSucceededPayment ищет любой успешный платёж среди последних платежей пользователя.
+// Возвращает платёж и true, если найден успешно оплаченный и ещё не обработанный.
+func findSucceededPayment(chatID int64) (*payment.Payment, bool, error) {
+	payMu.Lock()
+	ids := append([]string(nil), userPayments[chatID]...) // копия
+	payMu.Unlock()
+
+	// обходим от самого нового к старому
+	for i := len(ids) - 1; i >= 0; i-- {
+		id := ids[i]
+		payMu.Lock()
+		already := processedPayments[id]
+		payMu.Unlock()
+		if already {
+			continue
+		}
+
+		pay, err := paymentProvider.GetPayment(id)
+		if err != nil {
+			// пропускаем сбойные
+			continue
+		}
+		if pay.Succeeded() {
+			payMu.Lock()
+			processedPayments[id] = true
+			payMu.Unlock()
+			return pay, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// clearPayments очищает историю платежей пользователя
+func clearPayments(chatID int64) {
+	payMu.Lock()
+	delete(userPayments, chatID)
+	payMu.Unlock()
 }
 
 func handleInstructionSelection(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, t instruct.InstructType) {
@@ -1414,43 +2144,413 @@ func handleInstructionSelection(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery
 
 func handleCheckPayment(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, pfsenseClient *pfsense.PfSenseClient) {
 	chatID := cq.Message.Chat.ID
-	payment, ok, err := yookassaClient.FindSucceededPayment(chatID)
+	pay, ok, err := findSucceededPayment(chatID)
 	if err != nil {
-		log.Printf("FindSucceededPayment error: %v", err)
+		log.Printf("findSucceededPayment error: %v", err)
 		ackCallback(bot, cq, "Не удалось проверить платеж. Попробуйте позже.")
 		return
 	}
-	if !ok || payment == nil {
+	if !ok || pay == nil {
 		ackCallback(bot, cq, "Платеж еще обрабатывается или не найден. Если вы уже оплатили — подождите 5–10 секунд и нажмите еще раз.")
 		return
 	}
 
 	// очищаем историю платежей после успешной обработки
-	yookassaClient.ClearPayments(chatID)
+	clearPayments(chatID)
 
-	meta := payment.Metadata
+	meta := pay.Metadata
 	plan := resolvePlanFromMetadata(meta, session)
 	if plan.Title == "" {
 		ackCallback(bot, cq, "Не удалось определить выбранный тариф. Напишите в поддержку.")
 		return
 	}
+	promoCode := metaString(meta, "promo_code")
+	if promoCode != "" {
+		plan.Days += metaInt(meta, "bonus_days")
+	}
+
+	if metaString(meta, "gift") == "true" {
+		gift, err := deliverGift(bot, chatID, session, cq.From, plan, pay.ID)
+		if err != nil {
+			log.Printf("deliverGift error: %v", err)
+			ackCallback(bot, cq, "Не удалось выпустить подарок. Свяжитесь с поддержкой.")
+			return
+		}
+		if promoCode != "" {
+			redeemPromoCode(promoCode, strconv.FormatInt(cq.From.ID, 10))
+		}
+		recordProviderPayment(strconv.FormatInt(cq.From.ID, 10), pay, plan, gift.Code)
+		ackCallback(bot, cq, "Оплата подтверждена! Подарок готов.")
+		return
+	}
 
 	fake := &tgbotapi.Message{Chat: cq.Message.Chat, From: cq.From}
 
 	if err := handleSuccessfulPayment(bot, fake, pfsenseClient, plan, session); err != nil {
 		log.Printf("handleSuccessfulPayment error: %v", err)
+		notifyAdminsError(bot, fmt.Sprintf("Оплата от id:%d прошла, но не обработана: %v", cq.From.ID, err))
 		ackCallback(bot, cq, "Не удалось выдать сертификат. Свяжитесь с поддержкой.")
 		return
 	}
 
+	if promoCode != "" {
+		redeemPromoCode(promoCode, strconv.FormatInt(cq.From.ID, 10))
+	}
+	rememberPaymentMethod(strconv.FormatInt(cq.From.ID, 10), pay, plan)
+	recordProviderPayment(strconv.FormatInt(cq.From.ID, 10), pay, plan, "")
+
 	ackCallback(bot, cq, fmt.Sprintf("Оплата подтверждена! Тариф «%s» активирован.", plan.Title))
 }
 
+// Префиксы payload счетов Telegram: "plan:<id>" — рубли через YooKassa, "stars:<id>" — Telegram Stars,
+// "gift:<id>" — тариф в подарок, оплаченный рублями
+const (
+	invoicePayloadPrefix = "plan:"
+	starsPayloadPrefix   = "stars:"
+	giftPayloadPrefix    = "gift:"
+	starsCurrency        = "XTR"
+)
+
+// invoicePayload кодирует тариф и промокод: "plan:<id>" или "plan:<id>|<промокод>"
+func invoicePayload(plan RatePlan, promoCode string) string {
+	if promoCode != "" {
+		return invoicePayloadPrefix + plan.ID + "|" + promoCode
+	}
+	return invoicePayloadPrefix + plan.ID
+}
+
+// planFromInvoicePayload достаёт тариф (без скидки) и промокод из payload счёта,
+// не полагаясь на состояние сессии
+func planFromInvoicePayload(payload string) (RatePlan, string, bool) {
+	var planID, promoCode string
+	switch {
+	case strings.HasPrefix(payload, invoicePayloadPrefix):
+		planID = strings.TrimPrefix(payload, invoicePayloadPrefix)
+	case strings.HasPrefix(payload, starsPayloadPrefix):
+		planID = strings.TrimPrefix(payload, starsPayloadPrefix)
+	case strings.HasPrefix(payload, giftPayloadPrefix):
+		planID = strings.TrimPrefix(payload, giftPayloadPrefix)
+	default:
+		return RatePlan{}, "", false
+	}
+	if idx := strings.Index(planID, "|"); idx != -1 {
+		promoCode = planID[idx+1:]
+		planID = planID[:idx]
+	}
+	plan, ok := planByID(planID)
+	return plan, promoCode, ok
+}
+
+// expectedInvoicePrice возвращает валюту и сумму (в минимальных единицах), которые должны
+// прийти в счёте с данным payload
+func expectedInvoicePrice(payload string, plan RatePlan) (string, int) {
+	if strings.HasPrefix(payload, starsPayloadPrefix) {
+		return starsCurrency, plan.Stars
+	}
+	return plan.Currency, int(math.Round(plan.Amount * 100))
+}
+
+// sendStarsInvoice отправляет счёт в Telegram Stars. Для цифровых товаров provider_token пустой.
+func sendStarsInvoice(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, plan RatePlan) error {
+	invoice := tgbotapi.NewInvoice(
+		chatID,
+		fmt.Sprintf("HappyCat VPN — %s", plan.Title),
+		plan.Description,
+		starsPayloadPrefix+plan.ID,
+		"",
+		"",
+		starsCurrency,
+		[]tgbotapi.LabeledPrice{{Label: plan.Title, Amount: plan.Stars}},
+	)
+	invoice.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.InlineKeyboardButton{Text: fmt.Sprintf("⭐️ Оплатить %d", plan.Stars), Pay: true},
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в меню", "nav_menu"),
+		),
+	)
+
+	sent, err := bot.Send(invoice)
+	if err != nil {
+		return err
+	}
+
+	if session.MessageID != 0 && session.MessageID != sent.MessageID {
+		_, _ = bot.Send(tgbotapi.NewDeleteMessage(chatID, session.MessageID))
+	}
+
+	session.MessageID = sent.MessageID
+	session.State = stateTopUp
+	session.ContentType = "invoice"
+	session.PendingPlanID = plan.ID
+
+	instruct.ResetState(chatID)
+	return nil
+}
+
+// recordInvoicePayment сохраняет оплату счёта Telegram (рубли или Stars) в историю платежей
+func recordInvoicePayment(msg *tgbotapi.Message, plan RatePlan, promoCode, giftCode string) {
+	sp := msg.SuccessfulPayment
+	record := sqlite.PaymentRecord{
+		ID:        sp.TelegramPaymentChargeID,
+		UserID:    strconv.FormatInt(msg.From.ID, 10),
+		Provider:  sqlite.ProviderTelegram,
+		PlanID:    plan.ID,
+		Amount:    float64(sp.TotalAmount) / 100,
+		Currency:  sp.Currency,
+		Days:      plan.Days,
+		PromoCode: promoCode,
+		GiftCode:  giftCode,
+	}
+	if sp.Currency == starsCurrency {
+		record.Provider = sqlite.ProviderStars
+		record.Amount = float64(sp.TotalAmount)
+	}
+	if err := sqliteClient.RecordPayment(record); err != nil {
+		log.Printf("RecordPayment error: %v", err)
+		return
+	}
+	queueReferralCheck(record.UserID)
+}
+
+// recordProviderPayment сохраняет успешный платёж платёжного провайдера в историю платежей
+func recordProviderPayment(userID string, pay *payment.Payment, plan RatePlan, giftCode string) {
+	if pay == nil {
+		return
+	}
+	amount := pay.Amount
+	if amount == 0 {
+		amount = plan.Amount
+	}
+	currency := pay.Currency
+	if currency == "" {
+		currency = "RUB"
+	}
+	record := sqlite.PaymentRecord{
+		ID:        pay.ID,
+		UserID:    userID,
+		Provider:  paymentProvider.Name(),
+		PlanID:    plan.ID,
+		Amount:    amount,
+		Currency:  currency,
+		Days:      plan.Days,
+		PromoCode: metaString(pay.Metadata, "promo_code"),
+		GiftCode:  giftCode,
+	}
+	if err := sqliteClient.RecordPayment(record); err != nil {
+		log.Printf("RecordPayment error: %v", err)
+		return
+	}
+	queueReferralCheck(record.UserID)
+}
+
+// refundStarPayment возвращает звёзды пользователю и списывает начисленные за платёж дни
+func refundStarPayment(bot *tgbotapi.BotAPI, chargeID string) (sqlite.PaymentRecord, error) {
+	record, err := sqliteClient.GetPayment(chargeID)
+	if err != nil {
+		return record, err
+	}
+	if record.Provider != sqlite.ProviderStars {
+		return record, fmt.Errorf("payment %s is not a Stars payment", chargeID)
+	}
+	if record.Refunded {
+		return record, fmt.Errorf("payment %s already refunded", chargeID)
+	}
+
+	params := tgbotapi.Params{
+		"user_id":                    record.UserID,
+		"telegram_payment_charge_id": chargeID,
+	}
+	if _, err := bot.MakeRequest("refundStarPayment", params); err != nil {
+		return record, err
+	}
+
+	return completeRefund(chargeID)
+}
+
+// refundPayment возвращает деньги через того провайдера, которым был проведён платёж
+func refundPayment(bot *tgbotapi.BotAPI, paymentID string) (sqlite.PaymentRecord, error) {
+	record, err := sqliteClient.GetPayment(paymentID)
+	if err != nil {
+		return record, err
+	}
+	switch record.Provider {
+	case sqlite.ProviderStars:
+		return refundStarPayment(bot, paymentID)
+	case paymentProvider.Name():
+		if record.Refunded {
+			return record, fmt.Errorf("payment %s already refunded", paymentID)
+		}
+		if err := paymentProvider.Refund(paymentID, record.Amount, record.Currency); err != nil {
+			return record, err
+		}
+		return completeRefund(paymentID)
+	default:
+		return record, fmt.Errorf("возврат для провайдера %s не поддерживается", record.Provider)
+	}
+}
+
+// completeRefund отмечает возврат в хранилище и отзывает сертификат, если дни закончились
+func completeRefund(paymentID string) (sqlite.PaymentRecord, error) {
+	record, remaining, err := sqliteClient.RefundPayment(paymentID, time.Now())
+	if err != nil {
+		return record, err
+	}
+	// за подарок дни списываются у получателя; если подарок не активирован, списывать не у кого
+	daysUserID := record.UserID
+	if record.GiftCode != "" {
+		gift, _ := sqliteClient.GetGift(record.GiftCode)
+		daysUserID = gift.RedeemedBy
+	}
+	if daysUserID != "" && remaining <= 0 {
+		scheduleRevokeUser(daysUserID)
+	}
+	voidReferralPaymentReward(paymentID)
+	return record, nil
+}
+
+// handleRefundCommand — /refund <id платежа>, доступно только администраторам
+func handleRefundCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	if !isAdmin(msg.From.ID) {
+		return
+	}
+	chargeID := strings.TrimSpace(msg.CommandArguments())
+	if chargeID == "" {
+		reply := tgbotapi.NewMessage(msg.Chat.ID, "Использование: /refund <id платежа или telegram_payment_charge_id>")
+		bot.Send(reply)
+		return
+	}
+
+	record, err := refundPayment(bot, chargeID)
+	if err != nil {
+		log.Printf("refundPayment error: %v", err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось вернуть платёж: %v", err)))
+		return
+	}
+
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Возвращено %s пользователю %s, списано %d дней", formatPaymentAmount(record), record.UserID, record.Days)))
+	notifyRefund(bot, record)
+}
+
+// notifyRefund сообщает пользователю о возврате платежа
+func notifyRefund(bot *tgbotapi.BotAPI, record sqlite.PaymentRecord) {
+	amount := formatPaymentAmount(record)
+	if userChatID, err := strconv.ParseInt(record.UserID, 10, 64); err == nil {
+		text := fmt.Sprintf("↩️ Оплата за тариф возвращена (%s). Начисленные дни списаны с баланса.", amount)
+		if record.GiftCode != "" {
+			text = fmt.Sprintf("↩️ Оплата подарка возвращена (%s). Подарочный код больше не действует.", amount)
+		}
+		bot.Send(tgbotapi.NewMessage(userChatID, text))
+	}
+}
+
+func formatPaymentAmount(record sqlite.PaymentRecord) string {
+	if record.Currency == starsCurrency {
+		return fmt.Sprintf("%.0f ⭐️", record.Amount)
+	}
+	return fmt.Sprintf("%.2f ₽", record.Amount)
+}
+
+// sendPlanInvoice отправляет нативный счёт Telegram через провайдера YooKassa
+func sendPlanInvoice(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, plan RatePlan) error {
+	basePlanID := plan.ID
+	plan, promo, err := applySessionPromo(session, strconv.FormatInt(chatID, 10), plan)
+	if err != nil {
+		return err
+	}
+	payload := invoicePayload(plan, promo.Code)
+	if session.GiftPurchase {
+		payload = giftPayloadPrefix + plan.ID
+	}
+
+	invoice := tgbotapi.NewInvoice(
+		chatID,
+		fmt.Sprintf("HappyCat VPN — %s", plan.Title),
+		plan.Description,
+		payload,
+		paymentProviderToken,
+		"",
+		plan.Currency,
+		[]tgbotapi.LabeledPrice{{Label: plan.Title, Amount: int(math.Round(plan.Amount * 100))}},
+	)
+
+	// Чек для 54-ФЗ передаётся провайдеру через provider_data
+	if contact := receiptContact(strconv.FormatInt(chatID, 10)); !contact.Empty() {
+		providerData, err := json.Marshal(map[string]interface{}{
+			"receipt": receiptSettings.NewReceipt(plan.Title, plan.Amount, contact),
+		})
+		if err == nil {
+			invoice.ProviderData = string(providerData)
+		}
+	} else if receiptSettings.Required {
+		// контакта нет — пусть Telegram запросит e-mail и передаст его провайдеру
+		invoice.NeedEmail = true
+		invoice.SendEmailToProvider = true
+	}
+
+	invoice.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.InlineKeyboardButton{Text: fmt.Sprintf("💳 Оплатить %.0f ₽", plan.Amount), Pay: true},
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад в меню", "nav_menu"),
+		),
+	)
+
+	sent, err := bot.Send(invoice)
+	if err != nil {
+		return err
+	}
+
+	if session.MessageID != 0 && session.MessageID != sent.MessageID {
+		_, _ = bot.Send(tgbotapi.NewDeleteMessage(chatID, session.MessageID))
+	}
+
+	session.MessageID = sent.MessageID
+	session.State = stateTopUp
+	session.ContentType = "invoice"
+	session.PendingPlanID = basePlanID
+
+	instruct.ResetState(chatID)
+	return nil
+}
+
+// handlePreCheckout сверяет тариф и сумму счёта с каталогом перед списанием
 func handlePreCheckout(bot *tgbotapi.BotAPI, pcq *tgbotapi.PreCheckoutQuery) {
 	ans := tgbotapi.PreCheckoutConfig{
 		PreCheckoutQueryID: pcq.ID,
 		OK:                 true,
 	}
+
+	plan, promoCode, ok := planFromInvoicePayload(pcq.InvoicePayload)
+	var promoErr error
+	if ok && promoCode != "" {
+		var promo sqlite.PromoCode
+		// бронь продлевается до списания: если она истекла и код успели занять, деньги не спишутся
+		promo, promoErr = sqliteClient.ReservePromoCode(promoCode, strconv.FormatInt(pcq.From.ID, 10), plan.ID, time.Now())
+		plan = applyPromo(plan, promo)
+	}
+	currency, amount := expectedInvoicePrice(pcq.InvoicePayload, plan)
+	switch {
+	case !ok || amount <= 0:
+		ans.OK = false
+		ans.ErrorMessage = "Тариф не найден. Выберите тариф заново."
+	case userBanned(strconv.FormatInt(pcq.From.ID, 10)):
+		ans.OK = false
+		ans.ErrorMessage = "Аккаунт заблокирован."
+	case promoErr != nil:
+		ans.OK = false
+		ans.ErrorMessage = fmt.Sprintf("Промокод не применён: %v", promoErr)
+	case pcq.Currency != currency || pcq.TotalAmount != amount:
+		ans.OK = false
+		ans.ErrorMessage = "Стоимость тарифа изменилась. Выберите тариф заново."
+	}
+
+	if !ans.OK {
+		log.Printf("precheckout rejected: payload=%q currency=%s amount=%d", pcq.InvoicePayload, pcq.Currency, pcq.TotalAmount)
+	}
+
 	if _, err := bot.Request(ans); err != nil {
 		log.Printf("precheckout answer error: %v", err)
 	}
@@ -1472,8 +2572,10 @@ func handleSuccessfulPayment(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, pfsens
 	}
 
 	session.PendingPlanID = ""
+	session.PendingPromoCode = ""
+	session.GiftPurchase = false
 
-	sendMessageToAdmin(fmt.Sprintf("Пользователь id:%d пополнил баланс пакетом «%s»", msg.From.ID, plan.Title), msg.From.UserName, bot, userID)
+	notifyAdmins(bot, eventPayment, fmt.Sprintf("Пользователь id:%d пополнил баланс пакетом «%s»", msg.From.ID, plan.Title), msg.From.UserName, userID)
 	return nil
 }
 
@@ -1524,6 +2626,11 @@ func buildStatusText(pfsenseClient *pfsense.PfSenseClient, userID int) (string,
 	telegramUser := fmt.Sprint(userID)
 	_, _, err := pfsenseClient.GetAttachedCertRefIDByUserName(telegramUser)
 	days, _ := sqliteClient.GetDays(strconv.Itoa(userID))
+	expiresLine := ""
+	if expiresAt, expErr := sqliteClient.GetExpiresAt(telegramUser, time.Now()); expErr == nil && !expiresAt.IsZero() {
+		user, _ := sqliteClient.GetUser(telegramUser)
+		expiresLine = fmt.Sprintf("\n<b>├ 📅 Действует до:</b> %s", formatUserTime(user, expiresAt))
+	}
 
 	if err != nil {
 		return fmt.Sprintf(`🔒 <b>Статус подписки:</b>
@@ -1540,31 +2647,10 @@ func buildStatusText(pfsenseClient *pfsense.PfSenseClient, userID int) (string,
 	}
 
 	return fmt.Sprintf(`🔒 <b>Статус подписки:</b>
-<b>├ 🟢 Активна</b>
+<b>├ 🟢 Активна</b>%s
 <b>└ ⏳ Дней на балансе:</b> %d
 ────────────────────────
-✅ Отличная новость — VPN работает!`, days), nil
-}
-
-func sendMessageToAdmin(text string, username string, bot *tgbotapi.BotAPI, id int64) {
-	if id == 623290294 {
-		return
-	}
-	var userLink string
-	if username != "" {
-		userLink = fmt.Sprintf("<a href=\"https://t.me/%s\">@%s</a>", html.EscapeString(username), html.EscapeString(username))
-	} else {
-		userLink = fmt.Sprintf("<a href=\"tg://user?id=%d\">Профиль пользователя</a>", id)
-	}
-	newText := fmt.Sprintf("%s:\n%s", userLink, html.EscapeString(text))
-	msg := tgbotapi.NewMessage(623290294, newText)
-	msg.ParseMode = "HTML"
-
-	msg2 := tgbotapi.NewMessage(6365653009, newText)
-	msg2.ParseMode = "HTML"
-	bot.Send(msg)
-	bot.Send(msg2)
-
+✅ Отличная новость — VPN работает!`, expiresLine, days), nil
 }
 
 // getPrivacyURL возвращает ссылку на Политику конфиденциальности
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// adminEvent — тип события, о котором уведомляются администраторы
+type adminEvent string
+
+const (
+	eventPayment  adminEvent = "payment"  // оплаты, автоплатежи и покупка подарков
+	eventReferral adminEvent = "referral" // регистрации по реферальной ссылке
+	eventError    adminEvent = "error"    // сбои оплаты и выдачи сертификатов
+	eventGift     adminEvent = "gift"     // активация подарков
+	eventTransfer adminEvent = "transfer" // переводы дней
+	eventActivity adminEvent = "activity" // переходы по меню: инструкции, пополнение, поддержка
+)
+
+// adminEvents — события в порядке показа в /notify. Immediate-события уходят сразу,
+// остальные копятся и приходят сводкой раз в digestInterval.
+var adminEvents = []struct {
+	Event     adminEvent
+	Title     string
+	Immediate bool
+}{
+	{eventPayment, "💳 Оплаты", true},
+	{eventReferral, "🎁 Реферальные регистрации", true},
+	{eventError, "⚠️ Ошибки", true},
+	{eventGift, "🎀 Активация подарков", false},
+	{eventTransfer, "🔄 Переводы дней", false},
+	{eventActivity, "👀 Действия в меню", false},
+}
+
+// digestMaxLines — сколько событий одного типа показывается в сводке, остальные только считаются
+const digestMaxLines = 10
+
+var (
+	// digestInterval — как часто отправляется сводка (NOTIFY_DIGEST_MINUTES, по умолчанию 60)
+	digestInterval = time.Hour
+
+	digestMu sync.Mutex
+	digest   = make(map[adminEvent][]string)
+)
+
+func loadNotifySettings() {
+	if v := strings.TrimSpace(os.Getenv("NOTIFY_DIGEST_MINUTES")); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			digestInterval = time.Duration(n) * time.Minute
+		} else {
+			log.Printf("invalid NOTIFY_DIGEST_MINUTES=%q, using %v", v, digestInterval)
+		}
+	}
+}
+
+// knownAdminEvent сообщает, есть ли событие в adminEvents
+func knownAdminEvent(event adminEvent) bool {
+	for _, e := range adminEvents {
+		if e.Event == event {
+			return true
+		}
+	}
+	return false
+}
+
+func eventImmediate(event adminEvent) bool {
+	for _, e := range adminEvents {
+		if e.Event == event {
+			return e.Immediate
+		}
+	}
+	return false
+}
+
+// notifyAdmins сообщает администраторам о действии пользователя: важные события — сразу,
+// остальные — в ближайшей сводке. Действия самих администраторов не пересылаются.
+func notifyAdmins(bot *tgbotapi.BotAPI, event adminEvent, text string, username string, id int64) {
+	if isAdmin(id) {
+		return
+	}
+	var userLink string
+	if username != "" {
+		userLink = fmt.Sprintf("<a href=\"https://t.me/%s\">@%s</a>", html.EscapeString(username), html.EscapeString(username))
+	} else {
+		userLink = fmt.Sprintf("<a href=\"tg://user?id=%d\">Профиль пользователя</a>", id)
+	}
+	line := fmt.Sprintf("%s:\n%s", userLink, html.EscapeString(text))
+
+	if !eventImmediate(event) {
+		digestMu.Lock()
+		digest[event] = append(digest[event], line)
+		digestMu.Unlock()
+		return
+	}
+	for adminID := range admins {
+		if subscribed(adminID, event) {
+			sendAdminNotification(bot, adminID, line)
+		}
+	}
+}
+
+// notifyAdminsError сообщает администраторам о сбое, который требует внимания
+func notifyAdminsError(bot *tgbotapi.BotAPI, text string) {
+	for adminID := range admins {
+		if subscribed(adminID, eventError) {
+			sendAdminNotification(bot, adminID, "⚠️ "+html.EscapeString(text))
+		}
+	}
+}
+
+func subscribed(adminID int64, event adminEvent) bool {
+	muted, err := sqliteClient.MutedEvents(strconv.FormatInt(adminID, 10))
+	if err != nil {
+		log.Printf("MutedEvents error: %v", err)
+		return true
+	}
+	return !muted[string(event)]
+}
+
+func sendAdminNotification(bot *tgbotapi.BotAPI, adminID int64, text string) {
+	msg := tgbotapi.NewMessage(adminID, text)
+	msg.ParseMode = "HTML"
+	msg.DisableWebPagePreview = true
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("admin notification to %d error: %v", adminID, err)
+	}
+}
+
+// digestWorker раз в digestInterval рассылает администраторам накопившиеся события
+func digestWorker(bot *tgbotapi.BotAPI) {
+	ticker := time.NewTicker(digestInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		flushDigest(bot)
+	}
+}
+
+func flushDigest(bot *tgbotapi.BotAPI) {
+	digestMu.Lock()
+	pending := digest
+	digest = make(map[adminEvent][]string)
+	digestMu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	for adminID := range admins {
+		var b strings.Builder
+		for _, e := range adminEvents {
+			lines := pending[e.Event]
+			if len(lines) == 0 || !subscribed(adminID, e.Event) {
+				continue
+			}
+			fmt.Fprintf(&b, "\n\n<b>%s</b> (%d)", e.Title, len(lines))
+			for i, line := range lines {
+				if i == digestMaxLines {
+					fmt.Fprintf(&b, "\n…и ещё %d", len(lines)-digestMaxLines)
+					break
+				}
+				b.WriteString("\n• " + line)
+			}
+		}
+		if b.Len() == 0 {
+			continue
+		}
+		sendAdminNotification(bot, adminID, fmt.Sprintf("🗂 <b>Сводка за %s</b>%s", formatDigestInterval(), b.String()))
+	}
+}
+
+func formatDigestInterval() string {
+	if digestInterval%time.Hour == 0 {
+		return fmt.Sprintf("%d ч.", int(digestInterval/time.Hour))
+	}
+	return fmt.Sprintf("%d мин.", int(digestInterval/time.Minute))
+}
+
+// handleNotifyCommand — /notify, подписки администратора на уведомления
+func handleNotifyCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	text, kb := notifySettings(msg.From.ID)
+	reply := tgbotapi.NewMessage(msg.Chat.ID, text)
+	reply.ParseMode = "HTML"
+	reply.ReplyMarkup = kb
+	bot.Send(reply)
+}
+
+func notifySettings(adminID int64) (string, tgbotapi.InlineKeyboardMarkup) {
+	muted, err := sqliteClient.MutedEvents(strconv.FormatInt(adminID, 10))
+	if err != nil {
+		log.Printf("MutedEvents error: %v", err)
+	}
+
+	text := fmt.Sprintf("🔔 <b>Уведомления</b>\n\nОплаты, ошибки и реферальные регистрации приходят сразу, остальные события — сводкой раз в %s. Нажмите на событие, чтобы включить или отключить его.", formatDigestInterval())
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, e := range adminEvents {
+		mark := "✅"
+		if muted[string(e.Event)] {
+			mark = "🔕"
+		}
+		when := "сводка"
+		if e.Immediate {
+			when = "сразу"
+		}
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%s %s · %s", mark, e.Title, when), "notify_toggle_"+string(e.Event)),
+		))
+	}
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+func handleNotifyCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, data string) {
+	if !isAdmin(cq.From.ID) {
+		ackCallback(bot, cq, "⛔️ Недостаточно прав")
+		return
+	}
+	event := strings.TrimPrefix(data, "notify_toggle_")
+	if !knownAdminEvent(adminEvent(event)) {
+		ackCallback(bot, cq, "Неизвестное событие")
+		return
+	}
+	adminID := strconv.FormatInt(cq.From.ID, 10)
+	muted, err := sqliteClient.MutedEvents(adminID)
+	if err == nil {
+		err = sqliteClient.SetEventMuted(adminID, event, !muted[event])
+	}
+	if err != nil {
+		log.Printf("SetEventMuted error: %v", err)
+		ackCallback(bot, cq, "❌ Не удалось сохранить настройку")
+		return
+	}
+
+	text, kb := notifySettings(cq.From.ID)
+	edit := tgbotapi.NewEditMessageTextAndMarkup(cq.Message.Chat.ID, cq.Message.MessageID, text, kb)
+	edit.ParseMode = "HTML"
+	bot.Send(edit)
+	ackCallback(bot, cq, "")
+}
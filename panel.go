@@ -0,0 +1,691 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	pfsense "github.com/Asort97/vpnBot/clients/pfSense"
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+//go:embed web/templates/*.html
+var panelTemplates embed.FS
+
+const (
+	panelCookie = "panel_session"
+	// panelSessionTTL — сколько действует вход в панель
+	panelSessionTTL = 12 * time.Hour
+	// panelLoginMaxAge — насколько старыми могут быть данные Telegram Login Widget
+	panelLoginMaxAge = 24 * time.Hour
+	// panelSearchLimit — сколько пользователей показывается в результатах поиска
+	panelSearchLimit = 100
+	// panelOwnerID — администратор, вошедший по паролю PANEL_PASSWORD (роль owner)
+	panelOwnerID = "panel"
+	// panelLoginMaxFailures неверных паролей с одного IP за panelLoginWindow блокируют вход
+	// по паролю с этого IP на panelLoginWindow
+	panelLoginMaxFailures = 5
+	panelLoginWindow      = 15 * time.Minute
+)
+
+// loginAttempts — неудачные входы по паролю с одного IP
+type loginAttempts struct {
+	failures    int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+// loginLimiter ограничивает подбор PANEL_PASSWORD: пароль даёт роль owner
+type loginLimiter struct {
+	mu       sync.Mutex
+	attempts map[string]*loginAttempts
+}
+
+// locked сообщает, до какого момента вход с ip заблокирован
+func (l *loginLimiter) locked(ip string, now time.Time) (time.Time, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if a, ok := l.attempts[ip]; ok && now.Before(a.lockedUntil) {
+		return a.lockedUntil, true
+	}
+	return time.Time{}, false
+}
+
+// fail записывает неудачный вход и сообщает, заблокирован ли теперь ip
+func (l *loginLimiter) fail(ip string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, a := range l.attempts {
+		if now.Sub(a.first) > panelLoginWindow && now.After(a.lockedUntil) {
+			delete(l.attempts, key)
+		}
+	}
+	a, ok := l.attempts[ip]
+	if !ok {
+		a = &loginAttempts{first: now}
+		l.attempts[ip] = a
+	}
+	a.failures++
+	if a.failures >= panelLoginMaxFailures {
+		a.lockedUntil = now.Add(panelLoginWindow)
+		a.failures, a.first = 0, now
+		return true
+	}
+	return false
+}
+
+func (l *loginLimiter) reset(ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, ip)
+}
+
+// adminPanel — веб-панель администратора. Все действия выполняются через те же функции
+// хранилища и pfSense, что и команды бота, и с теми же правами ролей.
+type adminPanel struct {
+	bot      *tgbotapi.BotAPI
+	pfsense  *pfsense.PfSenseClient
+	botToken string
+	password string
+	secret   []byte
+	tmpl     *template.Template
+	logins   loginLimiter
+}
+
+// panelSession — вошедший администратор. В cookie хранится только ID, роль берётся из ADMINS
+// на каждом запросе, поэтому удалённый из ADMINS администратор теряет доступ сразу.
+type panelSession struct {
+	AdminID int64 // 0 — вход по паролю
+	Role    adminRole
+	Name    string
+	CSRF    string
+}
+
+// startAdminPanel запускает веб-панель на PANEL_ADDR. Без PANEL_ADDR панель отключена.
+func startAdminPanel(bot *tgbotapi.BotAPI, pfsenseClient *pfsense.PfSenseClient, botToken string) {
+	addr := strings.TrimSpace(os.Getenv("PANEL_ADDR"))
+	if addr == "" {
+		return
+	}
+
+	secret := []byte(os.Getenv("PANEL_SECRET"))
+	if len(secret) == 0 {
+		// без PANEL_SECRET входы сбрасываются при перезапуске бота
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Printf("admin panel disabled: %v", err)
+			return
+		}
+	}
+
+	tmpl, err := template.New("").Funcs(template.FuncMap{
+		"amount": formatPaymentAmount,
+		"date":   formatPanelDate,
+		"json":   panelJSON,
+	}).ParseFS(panelTemplates, "web/templates/*.html")
+	if err != nil {
+		log.Printf("admin panel disabled, templates error: %v", err)
+		return
+	}
+
+	p := &adminPanel{
+		bot:      bot,
+		pfsense:  pfsenseClient,
+		botToken: botToken,
+		password: os.Getenv("PANEL_PASSWORD"),
+		secret:   secret,
+		tmpl:     tmpl,
+		logins:   loginLimiter{attempts: make(map[string]*loginAttempts)},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /login", p.handleLoginPage)
+	mux.HandleFunc("POST /login", p.handlePasswordLogin)
+	mux.HandleFunc("GET /auth/telegram", p.handleTelegramLogin)
+	mux.HandleFunc("POST /logout", p.handleLogout)
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/users", http.StatusFound)
+	})
+	mux.HandleFunc("GET /users", p.require("user", p.handleUsers))
+	mux.HandleFunc("GET /users/{id}", p.require("user", p.handleUser))
+	mux.HandleFunc("POST /users/{id}/grant", p.require("grant", p.handleGrant))
+	mux.HandleFunc("POST /users/{id}/deduct", p.require("grant", p.handleDeduct))
+	mux.HandleFunc("POST /users/{id}/revoke", p.require("revoke", p.handleRevoke))
+	mux.HandleFunc("POST /users/{id}/unrevoke", p.require("unrevoke", p.handleRevoke))
+	mux.HandleFunc("GET /payments", p.require("user", p.handlePayments))
+	mux.HandleFunc("POST /payments/{id}/refund", p.require("refund", p.handleRefund))
+	mux.HandleFunc("GET /plans", p.require("plans", p.handlePlans))
+	mux.HandleFunc("POST /plans", p.require("plan_set", p.handlePlanSave))
+	mux.HandleFunc("POST /plans/{id}/hide", p.require("plan_hide", p.handlePlanVisibility))
+	mux.HandleFunc("POST /plans/{id}/show", p.require("plan_show", p.handlePlanVisibility))
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		log.Printf("admin panel listening on %s", addr)
+		if err := server.ListenAndServe(); err != nil {
+			log.Printf("admin panel stopped: %v", err)
+		}
+	}()
+}
+
+// can сообщает, разрешена ли администратору служебная команда (права те же, что у команд бота)
+func (s panelSession) can(command string) bool {
+	return roleCan(s.Role, command)
+}
+
+func (s panelSession) auditID() string {
+	if s.AdminID == 0 {
+		return panelOwnerID
+	}
+	return strconv.FormatInt(s.AdminID, 10)
+}
+
+type panelHandler func(w http.ResponseWriter, r *http.Request, s panelSession)
+
+// require пускает только вошедших администраторов с правом на команду. Изменяющие запросы
+// проверяются на CSRF-токен и записываются в журнал действий.
+func (p *adminPanel) require(command string, next panelHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := p.session(r)
+		if !ok {
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+		if r.Method == http.MethodPost {
+			if subtle.ConstantTimeCompare([]byte(r.FormValue("csrf")), []byte(s.CSRF)) != 1 {
+				http.Error(w, "invalid csrf token", http.StatusForbidden)
+				return
+			}
+		}
+		allowed := s.can(command)
+		if r.Method == http.MethodPost || !allowed {
+			args := strings.TrimSpace(r.URL.Path + " " + panelAuditArgs(r))
+			if err := sqliteClient.RecordAudit(sqlite.AuditEntry{
+				AdminID: s.auditID(),
+				Role:    string(s.Role),
+				Action:  "panel_" + command,
+				Args:    args,
+				Denied:  !allowed,
+			}); err != nil {
+				log.Printf("RecordAudit error: %v", err)
+			}
+		}
+		if !allowed {
+			w.WriteHeader(http.StatusForbidden)
+			p.render(w, "error.html", map[string]any{"Session": s, "Error": "⛔️ Недостаточно прав"})
+			return
+		}
+		next(w, r, s)
+	}
+}
+
+// panelAuditArgs — параметры формы для журнала, без CSRF-токена
+func panelAuditArgs(r *http.Request) string {
+	var parts []string
+	for key, values := range r.PostForm {
+		if key == "csrf" {
+			continue
+		}
+		parts = append(parts, key+"="+strings.Join(values, ","))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+func (p *adminPanel) render(w http.ResponseWriter, name string, data map[string]any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := p.tmpl.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("admin panel template %s error: %v", name, err)
+	}
+}
+
+// redirect возвращает на страницу с сообщением о результате действия
+func redirect(w http.ResponseWriter, r *http.Request, path, message string) {
+	http.Redirect(w, r, path+"?msg="+url.QueryEscape(message), http.StatusSeeOther)
+}
+
+// panelBackPath допускает возврат только на страницу самой панели: путь с одним «/» в начале,
+// без схемы, хоста, запроса и обратных слешей. Иначе возвращается fallback.
+func panelBackPath(back, fallback string) string {
+	if !strings.HasPrefix(back, "/") || strings.HasPrefix(back, "//") || strings.Contains(back, `\`) {
+		return fallback
+	}
+	u, err := url.Parse(back)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" ||
+		!strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") || strings.Contains(u.Path, `\`) {
+		return fallback
+	}
+	return back
+}
+
+func formatPanelDate(value string) string {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(time.UTC).Format("02.01.2006 15:04")
+	}
+	return value
+}
+
+// ---- вход ----
+
+func (p *adminPanel) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	p.render(w, "login.html", map[string]any{
+		"BotName":  p.bot.Self.UserName,
+		"Password": p.password != "",
+		"Error":    r.URL.Query().Get("error"),
+	})
+}
+
+func (p *adminPanel) handlePasswordLogin(w http.ResponseWriter, r *http.Request) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	now := time.Now()
+	if until, locked := p.logins.locked(ip, now); locked {
+		http.Redirect(w, r, "/login?error="+url.QueryEscape("Слишком много неудачных попыток, попробуйте после "+until.Local().Format("15:04")), http.StatusSeeOther)
+		return
+	}
+	if p.password == "" || subtle.ConstantTimeCompare([]byte(r.FormValue("password")), []byte(p.password)) != 1 {
+		log.Printf("admin panel: failed password login from %s", r.RemoteAddr)
+		if p.logins.fail(ip, now) {
+			log.Printf("admin panel: password login from %s locked for %s after %d failures", ip, panelLoginWindow, panelLoginMaxFailures)
+		}
+		http.Redirect(w, r, "/login?error="+url.QueryEscape("Неверный пароль"), http.StatusSeeOther)
+		return
+	}
+	p.logins.reset(ip)
+	p.setSession(w, r, 0)
+	http.Redirect(w, r, "/users", http.StatusSeeOther)
+}
+
+// handleTelegramLogin проверяет подпись Telegram Login Widget:
+// https://core.telegram.org/widgets/login#checking-authorization
+func (p *adminPanel) handleTelegramLogin(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	hash := query.Get("hash")
+
+	var fields []string
+	for key := range query {
+		if key != "hash" {
+			fields = append(fields, key+"="+query.Get(key))
+		}
+	}
+	sort.Strings(fields)
+
+	key := sha256.Sum256([]byte(p.botToken))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	authDate, _ := strconv.ParseInt(query.Get("auth_date"), 10, 64)
+	id, _ := strconv.ParseInt(query.Get("id"), 10, 64)
+	switch {
+	case !hmac.Equal([]byte(expected), []byte(hash)):
+		http.Redirect(w, r, "/login?error="+url.QueryEscape("Неверная подпись Telegram"), http.StatusSeeOther)
+		return
+	case time.Since(time.Unix(authDate, 0)) > panelLoginMaxAge:
+		http.Redirect(w, r, "/login?error="+url.QueryEscape("Данные входа устарели, войдите ещё раз"), http.StatusSeeOther)
+		return
+	case !isAdmin(id):
+		log.Printf("admin panel: Telegram login of non-admin %d", id)
+		http.Redirect(w, r, "/login?error="+url.QueryEscape("Этого аккаунта нет в списке администраторов"), http.StatusSeeOther)
+		return
+	}
+	p.setSession(w, r, id)
+	http.Redirect(w, r, "/users", http.StatusSeeOther)
+}
+
+func (p *adminPanel) handleLogout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: panelCookie, Value: "", Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// setSession выдаёт подписанную cookie вида base64(id|expires).base64(hmac)
+func (p *adminPanel) setSession(w http.ResponseWriter, r *http.Request, adminID int64) {
+	expires := time.Now().Add(panelSessionTTL)
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d|%d", adminID, expires.Unix())))
+	http.SetCookie(w, &http.Cookie{
+		Name:     panelCookie,
+		Value:    payload + "." + p.sign(payload),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (p *adminPanel) session(r *http.Request) (panelSession, bool) {
+	cookie, err := r.Cookie(panelCookie)
+	if err != nil {
+		return panelSession{}, false
+	}
+	payload, sig, ok := strings.Cut(cookie.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(p.sign(payload))) {
+		return panelSession{}, false
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return panelSession{}, false
+	}
+	idStr, expStr, _ := strings.Cut(string(raw), "|")
+	id, err1 := strconv.ParseInt(idStr, 10, 64)
+	exp, err2 := strconv.ParseInt(expStr, 10, 64)
+	if err1 != nil || err2 != nil || time.Now().Unix() > exp {
+		return panelSession{}, false
+	}
+
+	s := panelSession{AdminID: id, CSRF: p.sign("csrf:" + payload)}
+	if id == 0 {
+		if p.password == "" {
+			return panelSession{}, false
+		}
+		s.Role, s.Name = roleOwner, "вход по паролю"
+		return s, true
+	}
+	role, ok := admins[id]
+	if !ok {
+		return panelSession{}, false
+	}
+	s.Role, s.Name = role, strconv.FormatInt(id, 10)
+	return s, true
+}
+
+func (p *adminPanel) sign(value string) string {
+	mac := hmac.New(sha256.New, p.secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ---- пользователи ----
+
+// panelUser — строка пользователя в списке и заголовок карточки
+type panelUser struct {
+	ID        string
+	User      sqlite.UserData
+	Status    string
+	ExpiresAt string
+}
+
+func (p *adminPanel) handleUsers(w http.ResponseWriter, r *http.Request, s panelSession) {
+	q := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("q")))
+	users := sqliteClient.GetAllUsers()
+	now := time.Now()
+
+	var found []panelUser
+	for id, u := range users {
+		if q != "" && !userMatches(id, u, q) {
+			continue
+		}
+		found = append(found, p.panelUser(users, id, u, now))
+	}
+	sort.Slice(found, func(i, j int) bool { return found[i].User.CreatedAt > found[j].User.CreatedAt })
+	total := len(found)
+	if len(found) > panelSearchLimit {
+		found = found[:panelSearchLimit]
+	}
+
+	p.render(w, "users.html", map[string]any{
+		"Session": s,
+		"Query":   q,
+		"Users":   found,
+		"Total":   total,
+		"Limit":   panelSearchLimit,
+	})
+}
+
+// userMatches ищет по Telegram ID, контактам для чеков и CertRef (включая устройства)
+func userMatches(id string, u sqlite.UserData, q string) bool {
+	if strings.Contains(id, q) || strings.Contains(strings.ToLower(u.Email), q) ||
+		strings.Contains(u.Phone, q) || strings.Contains(strings.ToLower(u.CertRef), q) {
+		return true
+	}
+	for _, d := range u.Devices {
+		if strings.Contains(strings.ToLower(d.CertRef), q) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *adminPanel) panelUser(users map[string]sqlite.UserData, id string, u sqlite.UserData, now time.Time) panelUser {
+	pu := panelUser{ID: id, User: u, Status: userStatusTitles[userStatus(users, u)]}
+	if expiresAt, err := sqliteClient.GetExpiresAt(id, now); err == nil && !expiresAt.IsZero() {
+		pu.ExpiresAt = expiresAt.UTC().Format("02.01.2006 15:04")
+	}
+	return pu
+}
+
+func (p *adminPanel) handleUser(w http.ResponseWriter, r *http.Request, s panelSession) {
+	id := r.PathValue("id")
+	users := sqliteClient.GetAllUsers()
+	u, ok := users[id]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		p.render(w, "error.html", map[string]any{"Session": s, "Error": "Пользователь " + id + " не найден"})
+		return
+	}
+
+	var payments []sqlite.PaymentRecord
+	for _, pay := range sqliteClient.GetPayments() {
+		if pay.UserID == id {
+			payments = append(payments, pay)
+		}
+	}
+
+	p.render(w, "user.html", map[string]any{
+		"Session":  s,
+		"Message":  r.URL.Query().Get("msg"),
+		"User":     p.panelUser(users, id, u, time.Now()),
+		"Certs":    userCertificates(p.pfsense, u),
+		"Payments": payments,
+		"Back":     "/users/" + id,
+		"Family":   sqliteClient.FamilyMembers(id),
+	})
+}
+
+func (p *adminPanel) handleGrant(w http.ResponseWriter, r *http.Request, s panelSession) {
+	id := r.PathValue("id")
+	days, err := strconv.ParseInt(r.FormValue("days"), 10, 64)
+	if err != nil || days <= 0 {
+		redirect(w, r, "/users/"+id, "❌ Количество дней должно быть положительным числом")
+		return
+	}
+	if _, err := sqliteClient.GetUser(id); err != nil {
+		redirect(w, r, "/users/"+id, "❌ "+err.Error())
+		return
+	}
+	if err := grantDays(p.bot, id, days); err != nil {
+		log.Printf("AddDays error for panel grant to %s: %v", id, err)
+		redirect(w, r, "/users/"+id, "❌ Не удалось начислить дни: "+err.Error())
+		return
+	}
+	redirect(w, r, "/users/"+id, fmt.Sprintf("✅ Начислено %d дней", days))
+}
+
+func (p *adminPanel) handleDeduct(w http.ResponseWriter, r *http.Request, s panelSession) {
+	id := r.PathValue("id")
+	days, err := strconv.ParseInt(r.FormValue("days"), 10, 64)
+	if err != nil || days <= 0 {
+		redirect(w, r, "/users/"+id, "❌ Количество дней должно быть положительным числом")
+		return
+	}
+	remaining, err := deductDays(p.bot, id, days)
+	if err != nil {
+		log.Printf("DeductDays error for %s: %v", id, err)
+		redirect(w, r, "/users/"+id, "❌ Не удалось списать дни: "+err.Error())
+		return
+	}
+	redirect(w, r, "/users/"+id, fmt.Sprintf("✅ Списано %d дней, осталось %d", days, remaining))
+}
+
+// handleRevoke отзывает или восстанавливает один сертификат (cert=<refid>) или все сертификаты
+// пользователя и его семьи, как /revoke и /unrevoke
+func (p *adminPanel) handleRevoke(w http.ResponseWriter, r *http.Request, s panelSession) {
+	id := r.PathValue("id")
+	revoke := strings.HasSuffix(r.URL.Path, "/revoke")
+	certRef := r.FormValue("cert")
+
+	if certRef != "" {
+		refs, err := sqliteClient.GetCertRefs(id)
+		if err != nil || !containsString(refs, certRef) {
+			redirect(w, r, "/users/"+id, "❌ Сертификат не принадлежит пользователю")
+			return
+		}
+		if revoke {
+			scheduleRevoke(certRef)
+		} else if lockedCertRefs()[certRef] {
+			redirect(w, r, "/users/"+id, "❌ Пользователь заблокирован или его доступ приостановлен")
+			return
+		} else {
+			scheduleUnrevoke(certRef)
+		}
+	} else if revoke {
+		if err := suspendUser(id, s.auditID()); err != nil {
+			log.Printf("suspendUser error for %s: %v", id, err)
+			redirect(w, r, "/users/"+id, "❌ "+err.Error())
+			return
+		}
+	} else {
+		restored, err := resumeUser(id)
+		if err != nil {
+			log.Printf("resumeUser error for %s: %v", id, err)
+			redirect(w, r, "/users/"+id, "❌ "+err.Error())
+			return
+		}
+		if !restored {
+			redirect(w, r, "/users/"+id, "✅ Приостановка снята. Сертификаты не восстановлены: подписка не активна или заморожена")
+			return
+		}
+	}
+
+	action := "восстановление"
+	if revoke {
+		action = "отзыв"
+	}
+	redirect(w, r, "/users/"+id, "✅ Поставлено в очередь на "+action+", обновите страницу через несколько секунд")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ---- платежи ----
+
+func (p *adminPanel) handlePayments(w http.ResponseWriter, r *http.Request, s panelSession) {
+	userID := strings.TrimSpace(r.URL.Query().Get("user"))
+	var payments []sqlite.PaymentRecord
+	for _, pay := range sqliteClient.GetPayments() {
+		if userID == "" || pay.UserID == userID {
+			payments = append(payments, pay)
+		}
+	}
+	p.render(w, "payments.html", map[string]any{
+		"Session":  s,
+		"Message":  r.URL.Query().Get("msg"),
+		"User":     userID,
+		"Payments": payments,
+		"Back":     "/payments",
+	})
+}
+
+func (p *adminPanel) handleRefund(w http.ResponseWriter, r *http.Request, s panelSession) {
+	back := panelBackPath(r.FormValue("back"), "/payments")
+	record, err := refundPayment(p.bot, r.PathValue("id"))
+	if err != nil {
+		log.Printf("refundPayment error: %v", err)
+		redirect(w, r, back, "❌ Не удалось вернуть платёж: "+err.Error())
+		return
+	}
+	notifyRefund(p.bot, record)
+	redirect(w, r, back, fmt.Sprintf("✅ Возвращено %s пользователю %s, списано %d дней", formatPaymentAmount(record), record.UserID, record.Days))
+}
+
+// ---- тарифы ----
+
+// planFormFields — поля формы тарифа, они же параметры /plan_set
+var planFormFields = []string{"title", "price", "days", "stars", "devices", "family", "freeze", "sort", "currency", "desc"}
+
+func (p *adminPanel) handlePlans(w http.ResponseWriter, r *http.Request, s panelSession) {
+	p.render(w, "plans.html", map[string]any{
+		"Session": s,
+		"Message": r.URL.Query().Get("msg"),
+		"Plans":   allPlans(),
+	})
+}
+
+func (p *adminPanel) handlePlanSave(w http.ResponseWriter, r *http.Request, s panelSession) {
+	id := strings.TrimSpace(r.FormValue("id"))
+	if !sqlite.ValidPlanID(id) {
+		redirect(w, r, "/plans", "❌ ID тарифа: от 1 до 32 символов a-z, 0-9, «_» и «-»")
+		return
+	}
+	plan, exists := planByID(id)
+	if !exists {
+		plan = RatePlan{ID: id, Currency: "RUB"}
+	}
+	for _, key := range planFormFields {
+		value := strings.TrimSpace(r.FormValue(key))
+		if value == "" && key != "desc" {
+			continue
+		}
+		if err := setPlanField(&plan, key, value); err != nil {
+			redirect(w, r, "/plans", fmt.Sprintf("❌ %s: %v", key, err))
+			return
+		}
+	}
+	if _, err := savePlan(plan); err != nil {
+		redirect(w, r, "/plans", "❌ Не удалось сохранить тариф: "+err.Error())
+		return
+	}
+	redirect(w, r, "/plans", "✅ Тариф "+id+" сохранён")
+}
+
+func (p *adminPanel) handlePlanVisibility(w http.ResponseWriter, r *http.Request, s panelSession) {
+	hidden := strings.HasSuffix(r.URL.Path, "/hide")
+	plan, err := setPlanHidden(r.PathValue("id"), hidden)
+	if err != nil {
+		redirect(w, r, "/plans", "❌ "+err.Error())
+		return
+	}
+	status := "👁 Тариф снова доступен"
+	if hidden {
+		status = "🙈 Тариф скрыт"
+	}
+	redirect(w, r, "/plans", status+": "+plan.ID)
+}
+
+// panelJSON — запись пользователя как она хранится в data.json, для карточки пользователя
+func panelJSON(v any) string {
+	data, _ := json.MarshalIndent(v, "", "  ")
+	return string(data)
+}
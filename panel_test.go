@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestPanelBackPath(t *testing.T) {
+	tests := []struct {
+		back string
+		want string
+	}{
+		{"/payments", "/payments"},
+		{"/users/42", "/users/42"},
+		{"", "/payments"},
+		{"users/42", "/payments"},
+		{"//evil.com", "/payments"},
+		{"/\\evil.com", "/payments"},
+		{"/%5Cevil.com", "/payments"},
+		{"https://evil.com/", "/payments"},
+		{"/users/42?msg=x", "/payments"},
+		{"/users/42#top", "/payments"},
+		{"/\tevil.com", "/payments"},
+	}
+	for _, tt := range tests {
+		if got := panelBackPath(tt.back, "/payments"); got != tt.want {
+			t.Errorf("panelBackPath(%q) = %q, want %q", tt.back, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// RatePlan описывает тариф, который пользователь может выбрать.
+type RatePlan = sqlite.Plan
+
+// planReloadInterval — как часто проверяем, не изменился ли файл каталога
+const planReloadInterval = 30 * time.Second
+
+// defaultRatePlans записываются в каталог при первом запуске, дальше тарифы
+// редактируются командами /plan_set, /plan_hide, /plan_show или правкой plans.json.
+var defaultRatePlans = []RatePlan{
+	{ID: "15d", Title: "15 дней", Amount: 25, Days: 15, Stars: 20, Description: "Идеально, чтобы протестировать сервис или уехать на короткое время."},
+	{ID: "30d", Title: "30 дней", Amount: 50, Days: 30, Stars: 40, Description: "Идеально, чтобы протестировать сервис или уехать на короткое время."},
+	{ID: "60d", Title: "60 дней", Amount: 100, Days: 60, Stars: 75, FreezeDays: 7, Description: "Базовая подписка для постоянного доступа без ограничений."},
+	{ID: "120d", Title: "120 дней", Amount: 200, Days: 120, Stars: 150, Devices: 2, FreezeDays: 14, Description: "Полугодовой тариф со скидкой по сравнению с помесячной оплатой."},
+	{ID: "240d", Title: "240 дней", Amount: 300, Days: 240, Stars: 225, Devices: 2, FreezeDays: 14, Description: "Полугодовой тариф со скидкой по сравнению с помесячной оплатой."},
+	{ID: "365d", Title: "365 дней", Amount: 400, Days: 365, Stars: 300, Devices: 3, FreezeDays: 30, Description: "Максимальная выгода для тех, кто пользуется VPN круглый год."},
+	{ID: "family30", Title: "Семья 30 дней", Amount: 120, Days: 30, Stars: 100, Family: 4, Description: "Общий баланс для вас и ещё четырёх человек по ссылке-приглашению."},
+}
+
+var (
+	plansMu      sync.RWMutex
+	ratePlans    []RatePlan          // весь каталог в порядке сортировки, включая скрытые
+	ratePlanByID map[string]RatePlan // поиск по ID, включая скрытые — для старых платежей
+	plansModTime time.Time
+)
+
+// initPlanCatalog создаёт каталог из тарифов по умолчанию (если его ещё нет) и загружает его
+func initPlanCatalog(store *sqlite.Store) {
+	if path := strings.TrimSpace(os.Getenv("PLANS_FILE")); path != "" {
+		store.SetPlansFile(path)
+	}
+	if err := store.SeedPlans(defaultRatePlans); err != nil {
+		log.Printf("SeedPlans error: %v", err)
+	}
+	if err := reloadPlanCatalog(store); err != nil {
+		log.Printf("reloadPlanCatalog error: %v", err)
+	}
+
+	plansMu.RLock()
+	empty := len(ratePlans) == 0
+	plansMu.RUnlock()
+	if empty {
+		// каталог недоступен — работаем на встроенных тарифах, чтобы бот не остался без витрины
+		setPlanCatalog(defaultRatePlans, time.Time{})
+	}
+}
+
+// reloadPlanCatalog перечитывает каталог из хранилища
+func reloadPlanCatalog(store *sqlite.Store) error {
+	modTime := store.PlansModTime()
+	plans, err := store.LoadPlans()
+	if err != nil {
+		return err
+	}
+	if len(plans) == 0 {
+		return fmt.Errorf("каталог тарифов пуст")
+	}
+	setPlanCatalog(plans, modTime)
+	return nil
+}
+
+func setPlanCatalog(plans []RatePlan, modTime time.Time) {
+	byID := make(map[string]RatePlan, len(plans))
+	for _, plan := range plans {
+		if plan.Currency == "" {
+			plan.Currency = "RUB"
+		}
+		byID[plan.ID] = plan
+	}
+
+	plansMu.Lock()
+	ratePlans = plans
+	ratePlanByID = byID
+	plansModTime = modTime
+	plansMu.Unlock()
+}
+
+// planCatalogWatcher подхватывает ручные правки файла каталога без перезапуска бота
+func planCatalogWatcher(store *sqlite.Store) {
+	ticker := time.NewTicker(planReloadInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		modTime := store.PlansModTime()
+		plansMu.RLock()
+		changed := !modTime.Equal(plansModTime)
+		plansMu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := reloadPlanCatalog(store); err != nil {
+			log.Printf("plan catalog reload error: %v", err)
+			continue
+		}
+		log.Printf("plan catalog reloaded")
+	}
+}
+
+// applyPlanLimits сохраняет лимиты оплаченного тарифа: число устройств, дни заморозки и,
+// для семейного тарифа, размер семьи. Вызывается после начисления дней тарифа. Лимиты большего
+// тарифа сохраняются, пока не закончилось оплаченное им время. Уже добавленные устройства
+// сверх нового лимита продолжают работать, но новые добавить нельзя.
+func applyPlanLimits(userID string, plan RatePlan) {
+	limits := sqlite.PlanLimits{Devices: planDeviceLimit(plan), Family: plan.Family, Freeze: plan.FreezeDays}
+	if err := sqliteClient.ApplyPlanLimits(userID, limits, plan.Days, time.Now()); err != nil {
+		log.Printf("ApplyPlanLimits error for user %s: %v", userID, err)
+	}
+}
+
+// visiblePlans возвращает тарифы для витрины — без скрытых
+func visiblePlans() []RatePlan {
+	plansMu.RLock()
+	defer plansMu.RUnlock()
+
+	result := make([]RatePlan, 0, len(ratePlans))
+	for _, plan := range ratePlans {
+		if !plan.Hidden {
+			result = append(result, plan)
+		}
+	}
+	return result
+}
+
+// allPlans возвращает весь каталог, включая скрытые тарифы
+func allPlans() []RatePlan {
+	plansMu.RLock()
+	defer plansMu.RUnlock()
+	return append([]RatePlan(nil), ratePlans...)
+}
+
+// planByID ищет тариф по ID, включая скрытые: по ним приходят старые платежи и автопродления
+func planByID(id string) (RatePlan, bool) {
+	plansMu.RLock()
+	defer plansMu.RUnlock()
+	plan, ok := ratePlanByID[id]
+	return plan, ok
+}
+
+// purchasablePlanByID ищет тариф, который сейчас можно купить (не скрытый)
+func purchasablePlanByID(id string) (RatePlan, bool) {
+	plan, ok := planByID(id)
+	if !ok || plan.Hidden {
+		return RatePlan{}, false
+	}
+	return plan, true
+}
+
+// splitCommandArgs разбивает аргументы команды по пробелам с учётом кавычек:
+// title="30 дней" desc="Текст описания"
+func splitCommandArgs(s string) []string {
+	var args []string
+	var current strings.Builder
+	inQuotes := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case unicode.IsSpace(r) && !inQuotes:
+			if current.Len() > 0 {
+				args = append(args, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+func describePlan(plan RatePlan) string {
+	line := fmt.Sprintf("<code>%s</code> — %s, %.2f %s, %d дн.", html.EscapeString(plan.ID), html.EscapeString(plan.Title), plan.Amount, plan.Currency, plan.Days)
+	if plan.Stars > 0 {
+		line += fmt.Sprintf(", ⭐️%d", plan.Stars)
+	}
+	line += fmt.Sprintf(", устройств: %d", planDeviceLimit(plan))
+	if plan.Family > 0 {
+		line += fmt.Sprintf(", семья: +%d", plan.Family)
+	}
+	if plan.FreezeDays > 0 {
+		line += fmt.Sprintf(", заморозка: %d дн.", plan.FreezeDays)
+	}
+	line += fmt.Sprintf(", порядок %d", plan.SortOrder)
+	if plan.Hidden {
+		line += " (скрыт)"
+	}
+	return line
+}
+
+func handlePlansCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	if !isAdmin(msg.From.ID) {
+		return
+	}
+
+	plansMu.RLock()
+	plans := append([]RatePlan(nil), ratePlans...)
+	plansMu.RUnlock()
+
+	var lines []string
+	for _, plan := range plans {
+		lines = append(lines, describePlan(plan))
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, "📋 <b>Тарифы</b>\n\n"+strings.Join(lines, "\n"))
+	reply.ParseMode = "HTML"
+	bot.Send(reply)
+}
+
+// handlePlanSetCommand добавляет новый тариф или меняет поля существующего:
+// /plan_set 30d price=60 days=30 title="30 дней" stars=45 devices=2 sort=20 desc="..."
+func handlePlanSetCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	if !isAdmin(msg.From.ID) {
+		return
+	}
+
+	fields := splitCommandArgs(msg.CommandArguments())
+	if len(fields) == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /plan_set ID [price=50] [days=30] [title=\"30 дней\"] [stars=40] [devices=2] [family=4] [freeze=14] [sort=20] [currency=RUB] [desc=\"...\"]"))
+		return
+	}
+
+	id := fields[0]
+	if !sqlite.ValidPlanID(id) {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ ID тарифа: от 1 до 32 символов a-z, 0-9, «_» и «-»"))
+		return
+	}
+	plan, exists := planByID(id)
+	if !exists {
+		plan = RatePlan{ID: id, Currency: "RUB"}
+	}
+
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не понял параметр %q", field)))
+			return
+		}
+		if err := setPlanField(&plan, key, value); err != nil {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ %s: %v", key, err)))
+			return
+		}
+	}
+
+	plan, err := savePlan(plan)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось сохранить тариф: %v", err)))
+		return
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, "✅ Тариф сохранён: "+describePlan(plan))
+	reply.ParseMode = "HTML"
+	bot.Send(reply)
+}
+
+// setPlanField меняет поле тарифа по имени параметра /plan_set
+func setPlanField(plan *RatePlan, key, value string) error {
+	var err error
+	switch key {
+	case "price":
+		plan.Amount, err = strconv.ParseFloat(value, 64)
+		if err == nil && plan.Amount < minPaymentAmount {
+			err = fmt.Errorf("цена должна быть не меньше %.0f", minPaymentAmount)
+		}
+	case "days":
+		plan.Days, err = strconv.Atoi(value)
+		if err == nil && plan.Days <= 0 {
+			err = fmt.Errorf("срок должен быть положительным")
+		}
+	case "title":
+		plan.Title = value
+	case "desc":
+		plan.Description = value
+	case "stars":
+		plan.Stars, err = strconv.Atoi(value)
+	case "devices":
+		plan.Devices, err = strconv.Atoi(value)
+	case "family":
+		plan.Family, err = strconv.Atoi(value)
+	case "freeze":
+		plan.FreezeDays, err = strconv.Atoi(value)
+	case "sort":
+		plan.SortOrder, err = strconv.Atoi(value)
+	case "currency":
+		// оплата идёт через YooKassa в рублях, и все цены в боте показываются в ₽
+		if strings.ToUpper(value) != "RUB" {
+			err = fmt.Errorf("поддерживается только RUB")
+		} else {
+			plan.Currency = "RUB"
+		}
+	default:
+		err = fmt.Errorf("неизвестный параметр")
+	}
+	return err
+}
+
+// savePlan проверяет тариф, сохраняет его в каталог и перечитывает каталог
+func savePlan(plan RatePlan) (RatePlan, error) {
+	if plan.Amount <= 0 || plan.Days <= 0 {
+		return plan, fmt.Errorf("для нового тарифа укажите price и days")
+	}
+	if plan.Title == "" {
+		plan.Title = fmt.Sprintf("%d дней", plan.Days)
+	}
+	if err := sqliteClient.SavePlan(plan); err != nil {
+		log.Printf("SavePlan error: %v", err)
+		return plan, err
+	}
+	if err := reloadPlanCatalog(sqliteClient); err != nil {
+		log.Printf("reloadPlanCatalog error: %v", err)
+	}
+	return plan, nil
+}
+
+// setPlanHidden скрывает тариф с витрины или возвращает его
+func setPlanHidden(id string, hidden bool) (RatePlan, error) {
+	plan, ok := planByID(id)
+	if !ok {
+		return plan, fmt.Errorf("тариф %q не найден", id)
+	}
+	plan.Hidden = hidden
+	return savePlan(plan)
+}
+
+// handlePlanVisibilityCommand скрывает тариф с витрины или возвращает его. Тарифы не удаляются,
+// чтобы старые платежи и автопродления по ним продолжали работать.
+func handlePlanVisibilityCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, hidden bool) {
+	if !isAdmin(msg.From.ID) {
+		return
+	}
+
+	plan, err := setPlanHidden(strings.TrimSpace(msg.CommandArguments()), hidden)
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ %v", err)))
+		return
+	}
+
+	status := "👁 Тариф снова доступен"
+	if hidden {
+		status = "🙈 Тариф скрыт"
+	}
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("%s: %s", status, plan.ID)))
+}
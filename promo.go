@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// minPaymentAmount — минимальная сумма платежа после скидки, меньше YooKassa не принимает
+const minPaymentAmount = 1.0
+
+// applyPromo возвращает тариф с учётом скидки и бонусных дней промокода
+func applyPromo(plan RatePlan, promo sqlite.PromoCode) RatePlan {
+	if promo.Code == "" {
+		return plan
+	}
+
+	discounted := plan
+	switch {
+	case promo.DiscountPercent > 0:
+		discounted.Amount = plan.Amount * float64(100-promo.DiscountPercent) / 100
+	case promo.DiscountAmount > 0:
+		discounted.Amount = plan.Amount - promo.DiscountAmount
+	}
+	discounted.Amount = math.Round(discounted.Amount*100) / 100
+	if discounted.Amount < minPaymentAmount {
+		discounted.Amount = minPaymentAmount
+	}
+	discounted.Days += promo.BonusDays
+	return discounted
+}
+
+// applySessionPromo применяет промокод из сессии, заново проверяя его лимиты, и бронирует
+// использование за выставляемым счётом
+func applySessionPromo(session *UserSession, userID string, plan RatePlan) (RatePlan, sqlite.PromoCode, error) {
+	if session.PendingPromoCode == "" {
+		return plan, sqlite.PromoCode{}, nil
+	}
+	promo, err := sqliteClient.ReservePromoCode(session.PendingPromoCode, userID, plan.ID, time.Now())
+	if err != nil {
+		session.PendingPromoCode = ""
+		return plan, sqlite.PromoCode{}, fmt.Errorf("промокод не применён: %v", err)
+	}
+	return applyPromo(plan, promo), promo, nil
+}
+
+// redeemPromoCode засчитывает использование промокода, когда оплаченные дни уже начислены
+func redeemPromoCode(code, userID string) {
+	if err := sqliteClient.UsePromoCode(code, userID); err != nil {
+		log.Printf("UsePromoCode error: %v", err)
+	}
+}
+
+// describePromo кратко описывает выгоду промокода
+func describePromo(promo sqlite.PromoCode) string {
+	var parts []string
+	switch {
+	case promo.DiscountPercent > 0:
+		parts = append(parts, fmt.Sprintf("скидка %d%%", promo.DiscountPercent))
+	case promo.DiscountAmount > 0:
+		parts = append(parts, fmt.Sprintf("скидка %.0f ₽", promo.DiscountAmount))
+	}
+	if promo.BonusDays > 0 {
+		parts = append(parts, fmt.Sprintf("+%d дней", promo.BonusDays))
+	}
+	if len(parts) == 0 {
+		return "без бонуса"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// showPromoStep — шаг «🎟 Промокод» между выбором тарифа и оплатой
+func showPromoStep(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, plan RatePlan, notice string) {
+	text := fmt.Sprintf("💳 <b>Тариф «%s»</b>\n💰 Стоимость: %.0f ₽ за %d дней", html.EscapeString(plan.Title), plan.Amount, plan.Days)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	if session.PendingPromoCode != "" {
+		promo, err := sqliteClient.CheckPromoCode(session.PendingPromoCode, strconv.FormatInt(chatID, 10), plan.ID, time.Now())
+		if err == nil {
+			final := applyPromo(plan, promo)
+			text += fmt.Sprintf("\n\n🎟 Промокод <code>%s</code>: %s\n✅ К оплате: <b>%.2f ₽</b> за %d дней",
+				html.EscapeString(promo.Code), describePromo(promo), final.Amount, final.Days)
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("➡️ Перейти к оплате", "promo_continue"),
+			))
+			rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("❌ Убрать промокод", "promo_clear"),
+			))
+		} else {
+			session.PendingPromoCode = ""
+		}
+	}
+	if session.PendingPromoCode == "" {
+		text += "\n\nЕсть промокод? Введите его, чтобы получить скидку или бонусные дни."
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🎟 Промокод", "promo_enter"),
+		))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➡️ Продолжить без промокода", "promo_continue"),
+		))
+	}
+	if notice != "" {
+		text = notice + "\n\n" + text
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ К тарифам", "nav_topup"),
+	))
+
+	if err := updateSessionText(bot, chatID, session, stateChooseRate, text, "HTML", tgbotapi.NewInlineKeyboardMarkup(rows...)); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+}
+
+func handlePromoEnter(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
+	chatID := cq.Message.Chat.ID
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("➡️ Продолжить без промокода", "promo_continue"),
+		),
+	)
+	if err := updateSessionText(bot, chatID, session, stateEnterPromo, "🎟 Отправьте промокод одним сообщением:", "HTML", kb); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+}
+
+func handlePromoInput(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession) {
+	chatID := msg.Chat.ID
+	plan, ok := planByID(session.PendingPlanID)
+	if !ok {
+		_ = updateSessionText(bot, chatID, session, stateTopUp, "❌ Не удалось определить выбранный тариф. Выберите снова.", "HTML", rateSelectionKeyboard())
+		return
+	}
+
+	code := sqlite.NormalizePromoCode(msg.Text)
+	promo, err := sqliteClient.CheckPromoCode(code, strconv.FormatInt(msg.From.ID, 10), plan.ID, time.Now())
+	if err != nil {
+		session.PendingPromoCode = ""
+		showPromoStep(bot, chatID, session, plan, fmt.Sprintf("❌ %s", html.EscapeString(err.Error())))
+		return
+	}
+
+	session.PendingPromoCode = promo.Code
+	showPromoStep(bot, chatID, session, plan, "✅ Промокод применён!")
+}
+
+func metaString(meta map[string]interface{}, key string) string {
+	if v, ok := meta[key]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return ""
+}
+
+func metaInt(meta map[string]interface{}, key string) int {
+	switch value := meta[key].(type) {
+	case float64:
+		return int(value)
+	case int:
+		return value
+	case string:
+		n, _ := strconv.Atoi(value)
+		return n
+	}
+	return 0
+}
+
+// handlePromoAddCommand — /promo_add CODE percent=20 fixed=0 days=0 uses=100 per_user=1
+// from=2026-01-01 until=2026-01-31 plans=30d,60d
+func handlePromoAddCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	if !isAdmin(msg.From.ID) {
+		return
+	}
+
+	fields := strings.Fields(msg.CommandArguments())
+	if len(fields) == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /promo_add CODE percent=20 | fixed=50 | days=7 [uses=100] [per_user=1] [from=2026-01-01] [until=2026-01-31] [plans=30d,60d]"))
+		return
+	}
+
+	promo := sqlite.PromoCode{Code: fields[0]}
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не понял параметр %q", field)))
+			return
+		}
+		var err error
+		switch key {
+		case "percent":
+			promo.DiscountPercent, err = strconv.Atoi(value)
+			if err == nil && (promo.DiscountPercent <= 0 || promo.DiscountPercent >= 100) {
+				err = fmt.Errorf("процент должен быть от 1 до 99")
+			}
+		case "fixed":
+			promo.DiscountAmount, err = strconv.ParseFloat(value, 64)
+		case "days":
+			promo.BonusDays, err = strconv.Atoi(value)
+		case "uses":
+			promo.MaxUses, err = strconv.Atoi(value)
+		case "per_user":
+			promo.MaxUsesPerUser, err = strconv.Atoi(value)
+		case "from":
+			var t time.Time
+			t, err = time.Parse("2006-01-02", value)
+			promo.ValidFrom = t.UTC().Format(time.RFC3339)
+		case "until":
+			var t time.Time
+			t, err = time.Parse("2006-01-02", value)
+			// действует до конца указанного дня
+			promo.ValidUntil = t.Add(24*time.Hour - time.Second).UTC().Format(time.RFC3339)
+		case "plans":
+			for _, id := range strings.Split(value, ",") {
+				if _, ok := planByID(id); !ok {
+					err = fmt.Errorf("неизвестный тариф %s", id)
+					break
+				}
+				promo.PlanIDs = append(promo.PlanIDs, id)
+			}
+		default:
+			err = fmt.Errorf("неизвестный параметр")
+		}
+		if err != nil {
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ %s: %v", key, err)))
+			return
+		}
+	}
+
+	if promo.DiscountPercent == 0 && promo.DiscountAmount == 0 && promo.BonusDays == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Укажите percent, fixed или days"))
+		return
+	}
+	if promo.DiscountPercent > 0 && promo.DiscountAmount > 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Нельзя одновременно percent и fixed"))
+		return
+	}
+
+	if err := sqliteClient.SavePromoCode(promo); err != nil {
+		log.Printf("SavePromoCode error: %v", err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось сохранить промокод: %v", err)))
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Промокод %s сохранён: %s", sqlite.NormalizePromoCode(promo.Code), describePromo(promo))))
+}
+
+func handlePromoListCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	if !isAdmin(msg.From.ID) {
+		return
+	}
+
+	codes := sqliteClient.ListPromoCodes()
+	if len(codes) == 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Промокодов пока нет"))
+		return
+	}
+
+	var lines []string
+	for _, p := range codes {
+		line := fmt.Sprintf("<code>%s</code> — %s, использован %d", html.EscapeString(p.Code), describePromo(p), p.UsedCount)
+		if p.MaxUses > 0 {
+			line += fmt.Sprintf("/%d", p.MaxUses)
+		}
+		if p.ValidUntil != "" {
+			line += ", до " + p.ValidUntil[:10]
+		}
+		if len(p.PlanIDs) > 0 {
+			line += ", тарифы: " + strings.Join(p.PlanIDs, ",")
+		}
+		lines = append(lines, line)
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, "🎟 <b>Промокоды</b>\n\n"+strings.Join(lines, "\n"))
+	reply.ParseMode = "HTML"
+	bot.Send(reply)
+}
+
+func handlePromoDeleteCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	if !isAdmin(msg.From.ID) {
+		return
+	}
+	code := strings.TrimSpace(msg.CommandArguments())
+	if err := sqliteClient.DeletePromoCode(code); err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ %v", err)))
+		return
+	}
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("🗑 Промокод %s удалён", sqlite.NormalizePromoCode(code))))
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// referralTier — уровень реферальной программы: начиная с From-го подтверждённого друга
+// пригласивший получает Days дней за каждого
+type referralTier struct {
+	From int
+	Days int64
+}
+
+var (
+	// referralTiers — уровни по возрастанию From, первый начинается с первого друга (REFERRAL_TIERS)
+	referralTiers = []referralTier{{From: 1, Days: 15}}
+	// referralPaymentPercent — процент от дней каждой оплаты друга, который получает пригласивший
+	// (REFERRAL_PAYMENT_PERCENT, 0 — отключено)
+	referralPaymentPercent = 0
+	// referralSecondLevelDays — бонус за друга, которого привёл ваш друг (REFERRAL_SECOND_LEVEL_DAYS,
+	// 0 — отключено)
+	referralSecondLevelDays = 0
+
+	// referralHoldDays — через сколько дней активного пользования бонус начисляется и без оплаты
+	// (REFERRAL_HOLD_DAYS, 0 — только после оплаты)
+	referralHoldDays = 14
+	// referralDailyLimit — сколько приглашений с бонусом пригласивший может получить за сутки
+	// (REFERRAL_DAILY_LIMIT, 0 — без ограничения)
+	referralDailyLimit = 5
+)
+
+const (
+	// referralCheckInterval — как часто проверяются ожидающие бонусы
+	referralCheckInterval = 10 * time.Minute
+	// referralReportDays — за сколько дней по умолчанию строится /refreport
+	referralReportDays = 30
+	// referralReportLimit — сколько подозрительных групп показывает /refreport
+	referralReportLimit = 20
+	// referralPaymentLookback — за сколько последних дней учитываются оплаты друзей при плановом
+	// обходе: включение REFERRAL_PAYMENT_PERCENT не начисляет награды за давние оплаты
+	referralPaymentLookback = 7 * sqlite.Day
+)
+
+// referralChecks — приглашённые, которых нужно проверить сразу, например после оплаты
+var referralChecks = make(chan string, 64)
+
+func loadReferralSettings() {
+	referralHoldDays = envInt("REFERRAL_HOLD_DAYS", referralHoldDays)
+	referralDailyLimit = envInt("REFERRAL_DAILY_LIMIT", referralDailyLimit)
+	referralPaymentPercent = envInt("REFERRAL_PAYMENT_PERCENT", referralPaymentPercent)
+	referralSecondLevelDays = envInt("REFERRAL_SECOND_LEVEL_DAYS", referralSecondLevelDays)
+	if v := strings.TrimSpace(os.Getenv("REFERRAL_TIERS")); v != "" {
+		if tiers, err := parseReferralTiers(v); err == nil {
+			referralTiers = tiers
+		} else {
+			log.Printf("invalid REFERRAL_TIERS=%q: %v, using %v", v, err, referralTiers)
+		}
+	}
+}
+
+// parseReferralTiers разбирает REFERRAL_TIERS="1:15,5:20,10:30": с какого друга сколько дней
+func parseReferralTiers(v string) ([]referralTier, error) {
+	var tiers []referralTier
+	for _, part := range strings.Split(v, ",") {
+		fromStr, daysStr, ok := strings.Cut(strings.TrimSpace(part), ":")
+		from, err1 := strconv.Atoi(strings.TrimSpace(fromStr))
+		days, err2 := strconv.ParseInt(strings.TrimSpace(daysStr), 10, 64)
+		if !ok || err1 != nil || err2 != nil || from < 1 || days < 0 {
+			return nil, fmt.Errorf("bad tier %q", part)
+		}
+		tiers = append(tiers, referralTier{From: from, Days: days})
+	}
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].From < tiers[j].From })
+	if len(tiers) == 0 || tiers[0].From != 1 {
+		return nil, errors.New("the first tier must start from 1")
+	}
+	for i := 1; i < len(tiers); i++ {
+		if tiers[i].From == tiers[i-1].From {
+			return nil, fmt.Errorf("duplicate tier %d", tiers[i].From)
+		}
+	}
+	return tiers, nil
+}
+
+// referralTierFor — уровень, по которому начисляется бонус за n-го подтверждённого друга
+func referralTierFor(n int) referralTier {
+	tier := referralTiers[0]
+	for _, t := range referralTiers {
+		if n >= t.From {
+			tier = t
+		}
+	}
+	return tier
+}
+
+// nextReferralTier — следующий уровень после того, что действует для n-го друга
+func nextReferralTier(n int) (referralTier, bool) {
+	for _, t := range referralTiers {
+		if t.From > n {
+			return t, true
+		}
+	}
+	return referralTier{}, false
+}
+
+// progressBar — полоска прогресса из width клеток
+func progressBar(done, total, width int) string {
+	if total <= 0 {
+		return ""
+	}
+	filled := done * width / total
+	if filled > width {
+		filled = width
+	}
+	return strings.Repeat("▓", filled) + strings.Repeat("░", width-filled)
+}
+
+// referralProgramText — экран реферальной программы: ссылка, заработанные дни, уровень и условия
+func referralProgramText(bot *tgbotapi.BotAPI, userID string) string {
+	link := fmt.Sprintf("https://t.me/%s?start=ref_%s", bot.Self.UserName, userID)
+	sum, err := sqliteClient.ReferralSummary(userID)
+	if err != nil {
+		log.Printf("ReferralSummary error: %v", err)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "🎁 <b>Реферальная программа</b>\n\n🔗 <b>Ваша ссылка:</b>\n<code>%s</code>\n\n", link)
+	fmt.Fprintf(&b, "📊 <b>Статистика:</b>\n• Приглашено: %d чел.\n• Подтверждено: %d", sum.Invited, sum.Rewarded)
+	if sum.Pending > 0 {
+		fmt.Fprintf(&b, " (ещё %d ожидают)", sum.Pending)
+	}
+	fmt.Fprintf(&b, "\n• Заработано: %d дн.\n", sum.EarnedDays)
+
+	current := referralTierFor(sum.Rewarded + 1)
+	if len(referralTiers) > 1 {
+		fmt.Fprintf(&b, "\n🏆 <b>Ваш уровень:</b> %d дн. за друга\n", current.Days)
+		if next, ok := nextReferralTier(sum.Rewarded + 1); ok {
+			prev := current.From - 1
+			fmt.Fprintf(&b, "%s %d/%d — ещё %d подтверждённых друзей до %d дн. за друга\n",
+				progressBar(sum.Rewarded-prev, next.From-1-prev, 10), sum.Rewarded, next.From-1, next.From-1-sum.Rewarded, next.Days)
+		} else {
+			b.WriteString("Это максимальный уровень\n")
+		}
+	}
+
+	fmt.Fprintf(&b, "\n💡 <b>Условия:</b>\n• Вы: <b>+%d дн.</b> за каждого друга, %s\n", current.Days, referralConditions())
+	if len(referralTiers) > 1 {
+		var levels []string
+		for _, t := range referralTiers {
+			levels = append(levels, fmt.Sprintf("с %d-го — %d дн.", t.From, t.Days))
+		}
+		b.WriteString("• Уровни: " + strings.Join(levels, ", ") + "\n")
+	}
+	if referralPaymentPercent > 0 {
+		fmt.Fprintf(&b, "• Вы: <b>%d%%</b> от дней каждой оплаты друга\n", referralPaymentPercent)
+	}
+	if referralSecondLevelDays > 0 {
+		fmt.Fprintf(&b, "• Вы: <b>+%d дн.</b> за каждого подтверждённого друга, которого пригласил ваш друг\n", referralSecondLevelDays)
+	}
+	fmt.Fprintf(&b, "• Друг: <b>+%d дн.</b> в подарок\n\nПоделитесь ссылкой и получайте дни!", welcomeBonusDays)
+	return b.String()
+}
+
+// referralConditions — когда пригласивший получает бонус, для экрана реферальной программы
+func referralConditions() string {
+	if referralHoldDays <= 0 {
+		return "когда друг оплатит подписку"
+	}
+	return fmt.Sprintf("когда друг оплатит подписку или будет пользоваться VPN %d дн.", referralHoldDays)
+}
+
+// referralRejectReason проверяет пригласившего при регистрации по ссылке. known == false —
+// такого пользователя нет, приглашение не записывается. Непустая причина — приглашение
+// записывается, но бонус за него не положен.
+func referralRejectReason(referrerID string, now time.Time) (reason string, known bool) {
+	referrer, err := sqliteClient.GetUser(referrerID)
+	if err != nil {
+		return "", false
+	}
+	if referrer.Banned(now) {
+		return "пригласивший заблокирован", true
+	}
+	// бонусы за цепочку одноразовых аккаунтов не начисляются: пока приглашение самого
+	// пригласившего не подтверждено, его бонусы ждут (см. checkReferral), а если отклонено —
+	// не положены
+	if own, ok := sqliteClient.GetReferral(referrerID); ok && own.Status == sqlite.ReferralRejected {
+		return "приглашение пригласившего отклонено", true
+	}
+	if referralDailyLimit > 0 && sqliteClient.CountReferralsSince(referrerID, now.Add(-sqlite.Day)) >= referralDailyLimit {
+		return fmt.Sprintf("больше %d приглашений за сутки", referralDailyLimit), true
+	}
+	return "", true
+}
+
+// registerReferral записывает регистрацию нового пользователя по ссылке пригласившего.
+// Бонус пригласившему начисляется позже, см. checkReferral. Возвращает false, если
+// пригласившего нет в базе.
+func registerReferral(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, userID, referrerID string) bool {
+	now := time.Now()
+	reason, known := referralRejectReason(referrerID, now)
+	if !known {
+		log.Printf("user %s came with unknown referrer %s, referral ignored", userID, referrerID)
+		return false
+	}
+	if err := sqliteClient.RecordReferral(userID, referrerID, reason, now); err != nil {
+		log.Printf("RecordReferral error: %v", err)
+		return false
+	}
+
+	bonus := fmt.Sprintf("ожидает: %s", referralConditions())
+	if reason != "" {
+		log.Printf("referral bonus for %s -> %s rejected: %s", referrerID, userID, reason)
+		bonus = "отклонён: " + reason
+	} else if referrerChatID, err := strconv.ParseInt(referrerID, 10, 64); err == nil {
+		sum, _ := sqliteClient.ReferralSummary(referrerID)
+		bot.Send(tgbotapi.NewMessage(referrerChatID, fmt.Sprintf("🎉 По вашей реферальной ссылке зарегистрировался новый пользователь! Вы получите %d дней, %s.", referralTierFor(sum.Rewarded+1).Days, referralConditions())))
+	}
+
+	notifyAdmins(
+		bot,
+		eventReferral,
+		fmt.Sprintf("🎁 Новая реферальная регистрация!\n• Новый пользователь: %s\n• Пригласивший: %s\n• Бонус рефереру: %s", userID, referrerID, bonus),
+		msg.From.UserName,
+		msg.From.ID,
+	)
+	return true
+}
+
+// queueReferralCheck просит проверить бонус за приглашённого, не дожидаясь планового обхода
+func queueReferralCheck(userID string) {
+	select {
+	case referralChecks <- userID:
+	default:
+		// очередь полна — бонус проверится при плановом обходе
+	}
+}
+
+// referralWorker начисляет отложенные бонусы за приглашения: сразу после оплаты
+// приглашённого и при регулярном обходе ожидающих приглашений
+func referralWorker(bot *tgbotapi.BotAPI) {
+	ticker := time.NewTicker(referralCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case userID := <-referralChecks:
+			now := time.Now()
+			if r, ok := sqliteClient.GetReferral(userID); ok {
+				if r.Status == sqlite.ReferralPending {
+					checkReferral(bot, r, now)
+					r, _ = sqliteClient.GetReferral(userID)
+				}
+				rewardReferralPayments(bot, r, sqliteClient.GetPayments(), now)
+			}
+		case <-ticker.C:
+			referrals, err := sqliteClient.ListReferrals()
+			if err != nil {
+				log.Printf("ListReferrals error: %v", err)
+				continue
+			}
+			now := time.Now()
+			payments := sqliteClient.GetPayments()
+			// сначала самые старые: бонус друга подтверждается раньше бонусов за его друзей
+			for i := len(referrals) - 1; i >= 0; i-- {
+				r := referrals[i]
+				if r.Status == sqlite.ReferralPending {
+					checkReferral(bot, r, now)
+					r, _ = sqliteClient.GetReferral(r.InviteeID)
+				}
+				rewardReferralPayments(bot, r, payments, now)
+			}
+		}
+	}
+}
+
+// checkReferral начисляет бонус, если приглашённый оплатил подписку или получил конфиг и
+// пользуется ботом referralHoldDays дней. Бонус отклоняется, если приглашённый заблокирован
+// или приглашение самого пригласившего отклонено, и ждёт, пока оно не подтверждено.
+func checkReferral(bot *tgbotapi.BotAPI, r sqlite.Referral, now time.Time) {
+	invitee, err := sqliteClient.GetUser(r.InviteeID)
+	if err != nil {
+		return
+	}
+	reject := ""
+	own, invited := sqliteClient.GetReferral(r.ReferrerID)
+	switch {
+	case invitee.Banned(now):
+		reject = "приглашённый заблокирован"
+	case invited && own.Status == sqlite.ReferralRejected:
+		reject = "приглашение пригласившего отклонено"
+	case invited && own.Status == sqlite.ReferralPending:
+		return
+	}
+	if reject != "" {
+		if err := sqliteClient.RejectReferral(r.InviteeID, reject, now); err != nil && !errors.Is(err, sqlite.ErrReferralNotPending) {
+			log.Printf("RejectReferral error: %v", err)
+		}
+		return
+	}
+
+	paid := false
+	for _, p := range sqliteClient.GetPayments() {
+		if p.UserID == r.InviteeID && !p.Refunded {
+			paid = true
+			break
+		}
+	}
+	reason := "оплатил подписку"
+	if !paid {
+		createdAt, err := time.Parse(time.RFC3339, r.CreatedAt)
+		if referralHoldDays <= 0 || err != nil || now.Sub(createdAt) < time.Duration(referralHoldDays)*sqlite.Day ||
+			invitee.CertRef == "" || invitee.BlockedAt != "" || !referralInviteeSubscribed(invitee, r.ReferrerID, now) {
+			return
+		}
+		reason = fmt.Sprintf("пользуется VPN уже %d дн.", referralHoldDays)
+	}
+
+	sum, err := sqliteClient.ReferralSummary(r.ReferrerID)
+	if err != nil {
+		log.Printf("ReferralSummary error: %v", err)
+		return
+	}
+	tier := referralTierFor(sum.Rewarded + 1)
+	r, err = sqliteClient.RewardReferral(r.InviteeID, tier.Days, now)
+	if err != nil {
+		if !errors.Is(err, sqlite.ErrReferralNotPending) {
+			log.Printf("RewardReferral error: %v", err)
+		}
+		return
+	}
+	log.Printf("referrer %s received %d days for %s", r.ReferrerID, r.Days, r.InviteeID)
+
+	text := fmt.Sprintf("🎉 Приглашённый вами друг %s. Вам начислено %d дней!", reason, r.Days)
+	if next, ok := nextReferralTier(sum.Rewarded + 1); ok && next.From == sum.Rewarded+2 {
+		text += fmt.Sprintf("\n🏆 Новый уровень: за следующих друзей — %d дней.", next.Days)
+	}
+	creditReferrer(bot, r.ReferrerID, text)
+
+	// бонус второго уровня — тому, кто пригласил пригласившего
+	if referralSecondLevelDays > 0 && invited && own.Status == sqlite.ReferralRewarded && !userBanned(own.ReferrerID) {
+		reward := sqlite.ReferralReward{Kind: sqlite.RewardSecondLevel, ReferrerID: own.ReferrerID, InviteeID: r.InviteeID, Days: int64(referralSecondLevelDays)}
+		if added, err := sqliteClient.AddReferralReward(reward, now); err != nil {
+			log.Printf("AddReferralReward error: %v", err)
+		} else if added {
+			creditReferrer(bot, own.ReferrerID, fmt.Sprintf("🎉 Друг, которого вы пригласили, привёл своего друга. Вам начислено %d дней!", reward.Days))
+		}
+	}
+}
+
+// referralInviteeSubscribed — у приглашённого к концу проверки есть оплаченное время. Приветственные
+// дни заканчиваются раньше REFERRAL_HOLD_DAYS, поэтому одноразовый аккаунт, который только скачал
+// конфиг, бонуса не приносит. Подписка в семье самого пригласившего тоже не считается.
+func referralInviteeSubscribed(invitee sqlite.UserData, referrerID string, now time.Time) bool {
+	if invitee.FamilyOwner != "" {
+		if invitee.FamilyOwner == referrerID {
+			return false
+		}
+		owner, err := sqliteClient.GetUser(invitee.FamilyOwner)
+		return err == nil && owner.Remaining(now) > 0
+	}
+	return invitee.Remaining(now) > 0
+}
+
+// rewardReferralPayments начисляет пригласившему процент от дней оплат подтверждённого друга
+// за последние referralPaymentLookback
+func rewardReferralPayments(bot *tgbotapi.BotAPI, r sqlite.Referral, payments []sqlite.PaymentRecord, now time.Time) {
+	if referralPaymentPercent <= 0 || r.Status != sqlite.ReferralRewarded || userBanned(r.ReferrerID) {
+		return
+	}
+	since := now.Add(-referralPaymentLookback)
+	if created, err := time.Parse(time.RFC3339, r.CreatedAt); err == nil && created.After(since) {
+		since = created
+	}
+	for _, p := range payments {
+		if p.UserID != r.InviteeID || p.Refunded {
+			continue
+		}
+		if at, err := time.Parse(time.RFC3339, p.CreatedAt); err != nil || at.Before(since) {
+			continue
+		}
+		days := int64(p.Days * referralPaymentPercent / 100)
+		if days <= 0 {
+			continue
+		}
+		reward := sqlite.ReferralReward{Kind: sqlite.RewardPayment, ReferrerID: r.ReferrerID, InviteeID: r.InviteeID, PaymentID: p.ID, Days: days}
+		added, err := sqliteClient.AddReferralReward(reward, now)
+		if err != nil {
+			log.Printf("AddReferralReward error: %v", err)
+			continue
+		}
+		if added {
+			log.Printf("referrer %s received %d days for payment %s of %s", r.ReferrerID, days, p.ID, r.InviteeID)
+			creditReferrer(bot, r.ReferrerID, fmt.Sprintf("💳 Ваш друг оплатил подписку. Вам начислено %d%% — %d дней!", referralPaymentPercent, days))
+		}
+	}
+}
+
+// creditReferrer возвращает пригласившему доступ после начисления дней и сообщает о награде
+func creditReferrer(bot *tgbotapi.BotAPI, referrerID, text string) {
+	users := sqliteClient.GetAllUsers()
+	if referrer := users[referrerID]; !referrer.Frozen() && userActive(users, referrer) {
+		scheduleUnrevokeUser(referrerID)
+	}
+	if chatID, err := strconv.ParseInt(referrerID, 10, 64); err == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, text))
+	}
+}
+
+// voidReferralPaymentReward списывает с пригласившего награду за возвращённый платёж друга
+func voidReferralPaymentReward(paymentID string) {
+	reward, remaining, ok, err := sqliteClient.VoidPaymentReward(paymentID, time.Now())
+	if err != nil {
+		log.Printf("VoidPaymentReward error: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	log.Printf("referral reward of %d days for refunded payment %s voided for %s", reward.Days, paymentID, reward.ReferrerID)
+	if remaining <= 0 {
+		scheduleRevokeUser(reward.ReferrerID)
+	}
+}
+
+// referralCluster — пригласивший и все, кто пришёл по цепочке его приглашений за период
+type referralCluster struct {
+	Root      string
+	Invitees  int
+	Depth     int // сколько уровней в цепочке приглашений
+	Paid      int
+	Rejected  int
+	NoConfig  int // приглашённые, которые так и не получили конфиг
+	MaxPerDay int // больше всего приглашений одного пригласившего за скользящие сутки
+	Flags     []string
+}
+
+// referralClusters группирует приглашения за период по корневому пригласившему и
+// оставляет группы с признаками накрутки
+func referralClusters(referrals []sqlite.Referral, users map[string]sqlite.UserData, payments []sqlite.PaymentRecord, since time.Time) []referralCluster {
+	paid := make(map[string]bool)
+	for _, p := range payments {
+		if !p.Refunded {
+			paid[p.UserID] = true
+		}
+	}
+
+	byInvitee := make(map[string]sqlite.Referral)
+	times := make(map[string][]time.Time) // referrerID -> моменты приглашений
+	for _, r := range referrals {
+		at, err := time.Parse(time.RFC3339, r.CreatedAt)
+		if err != nil || at.Before(since) {
+			continue
+		}
+		byInvitee[r.InviteeID] = r
+		times[r.ReferrerID] = append(times[r.ReferrerID], at)
+	}
+
+	// корень — первый пригласивший в цепочке, который сам пришёл не по приглашению за период
+	root := func(id string) (string, int) {
+		depth := 1
+		for {
+			r, ok := byInvitee[id]
+			if !ok || depth > len(byInvitee) {
+				return id, depth - 1
+			}
+			id = r.ReferrerID
+			depth++
+		}
+	}
+
+	clusters := make(map[string]*referralCluster)
+	for inviteeID, r := range byInvitee {
+		rootID, depth := root(inviteeID)
+		c := clusters[rootID]
+		if c == nil {
+			c = &referralCluster{Root: rootID}
+			clusters[rootID] = c
+		}
+		c.Invitees++
+		if depth > c.Depth {
+			c.Depth = depth
+		}
+		if paid[inviteeID] {
+			c.Paid++
+		}
+		if r.Status == sqlite.ReferralRejected {
+			c.Rejected++
+		}
+		if users[inviteeID].CertRef == "" {
+			c.NoConfig++
+		}
+		if n := maxPerDay(times[r.ReferrerID]); n > c.MaxPerDay {
+			c.MaxPerDay = n
+		}
+	}
+
+	burst := referralDailyLimit
+	if burst <= 0 {
+		burst = 5
+	}
+	var result []referralCluster
+	for _, c := range clusters {
+		if c.MaxPerDay >= burst {
+			c.Flags = append(c.Flags, "всплеск регистраций")
+		}
+		if c.Depth > 1 {
+			c.Flags = append(c.Flags, "цепочка приглашений")
+		}
+		if c.Invitees >= 3 && c.Paid == 0 {
+			c.Flags = append(c.Flags, "никто не оплатил")
+		}
+		if c.Invitees >= 3 && c.NoConfig*2 > c.Invitees {
+			c.Flags = append(c.Flags, "большинство без конфига")
+		}
+		if c.Rejected > 0 {
+			c.Flags = append(c.Flags, "отклонённые бонусы")
+		}
+		if len(c.Flags) > 0 {
+			result = append(result, *c)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if len(result[i].Flags) != len(result[j].Flags) {
+			return len(result[i].Flags) > len(result[j].Flags)
+		}
+		if result[i].Invitees != result[j].Invitees {
+			return result[i].Invitees > result[j].Invitees
+		}
+		return result[i].Root < result[j].Root
+	})
+	return result
+}
+
+// maxPerDay — наибольшее число моментов, попадающих в одни скользящие сутки
+func maxPerDay(times []time.Time) int {
+	sorted := append([]time.Time(nil), times...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Before(sorted[j]) })
+	best, start := 0, 0
+	for end := range sorted {
+		for sorted[end].Sub(sorted[start]) >= sqlite.Day {
+			start++
+		}
+		if n := end - start + 1; n > best {
+			best = n
+		}
+	}
+	return best
+}
+
+// handleReferralReportCommand — /refreport [дней], подозрительные группы приглашений
+func handleReferralReportCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	days := referralReportDays
+	if n, err := strconv.Atoi(strings.TrimSpace(msg.CommandArguments())); err == nil && n > 0 {
+		days = n
+	}
+	referrals, err := sqliteClient.ListReferrals()
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Не удалось прочитать приглашения: %v", err)))
+		return
+	}
+	now := time.Now()
+	clusters := referralClusters(referrals, sqliteClient.GetAllUsers(), sqliteClient.GetPayments(), now.Add(-time.Duration(days)*sqlite.Day))
+
+	var pending, rewarded, rejected int
+	for _, r := range referrals {
+		switch r.Status {
+		case sqlite.ReferralPending:
+			pending++
+		case sqlite.ReferralRewarded:
+			rewarded++
+		case sqlite.ReferralRejected:
+			rejected++
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("🕵️ <b>Подозрительные приглашения за %d дн.</b>", days),
+		fmt.Sprintf("Всего бонусов: ожидают %d, начислено %d, отклонено %d", pending, rewarded, rejected),
+		"",
+	}
+	if len(clusters) == 0 {
+		lines = append(lines, "Подозрительных групп не найдено")
+	}
+	if len(clusters) > referralReportLimit {
+		lines = append(lines, fmt.Sprintf("Показаны %d из %d групп", referralReportLimit, len(clusters)))
+		clusters = clusters[:referralReportLimit]
+	}
+	for _, c := range clusters {
+		lines = append(lines, fmt.Sprintf("• <code>%s</code>: приглашено %d (уровней: %d), оплатили %d, без конфига %d, отклонено %d, до %d за сутки\n  ⚠️ %s",
+			c.Root, c.Invitees, c.Depth, c.Paid, c.NoConfig, c.Rejected, c.MaxPerDay, html.EscapeString(strings.Join(c.Flags, ", "))))
+	}
+	if len(clusters) > 0 {
+		lines = append(lines, "", "Карточка: /user &lt;id&gt;, блокировка с аннулированием бонусов: /ban &lt;id&gt; &lt;причина&gt;")
+	}
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, strings.Join(lines, "\n"))
+	reply.ParseMode = "HTML"
+	bot.Send(reply)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+)
+
+func TestMaxPerDay(t *testing.T) {
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(hours ...int) []time.Time {
+		var times []time.Time
+		for _, h := range hours {
+			times = append(times, base.Add(time.Duration(h)*time.Hour))
+		}
+		return times
+	}
+	tests := []struct {
+		name  string
+		times []time.Time
+		want  int
+	}{
+		{"пусто", nil, 0},
+		{"одно приглашение", at(0), 1},
+		{"все в одних сутках", at(0, 1, 5, 23), 4},
+		{"ровно сутки — уже другое окно", at(0, 24), 1},
+		{"скользящее окно, а не календарный день", at(0, 20, 30, 40, 50), 3},
+		{"порядок не важен", at(50, 0, 40, 20, 30), 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := maxPerDay(tt.times); got != tt.want {
+				t.Errorf("maxPerDay() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferralClusters(t *testing.T) {
+	now := time.Date(2026, 3, 20, 12, 0, 0, 0, time.UTC)
+	since := now.Add(-30 * sqlite.Day)
+	ref := func(invitee, referrer string, ago time.Duration, status sqlite.ReferralStatus) sqlite.Referral {
+		return sqlite.Referral{InviteeID: invitee, ReferrerID: referrer, Status: status, CreatedAt: now.Add(-ago).Format(time.RFC3339)}
+	}
+	referrals := []sqlite.Referral{
+		// всплеск: пять приглашений за час, никто не оплатил и не получил конфиг
+		ref("b1", "farm", 60*time.Minute, sqlite.ReferralPending),
+		ref("b2", "farm", 50*time.Minute, sqlite.ReferralPending),
+		ref("b3", "farm", 40*time.Minute, sqlite.ReferralPending),
+		ref("b4", "farm", 30*time.Minute, sqlite.ReferralPending),
+		ref("b5", "farm", 20*time.Minute, sqlite.ReferralPending),
+		// цепочка chain -> x -> y, все оплатили
+		ref("x", "chain", 10*sqlite.Day, sqlite.ReferralRewarded),
+		ref("y", "x", 5*sqlite.Day, sqlite.ReferralRewarded),
+		// отклонённый бонус
+		ref("t", "rej", 3*sqlite.Day, sqlite.ReferralRejected),
+		// обычное приглашение без признаков накрутки
+		ref("p", "clean", 2*sqlite.Day, sqlite.ReferralRewarded),
+		// старое приглашение за пределами периода
+		ref("old1", "stale", 60*sqlite.Day, sqlite.ReferralRewarded),
+	}
+	users := map[string]sqlite.UserData{
+		"x":    {CertRef: "cx"},
+		"y":    {CertRef: "cy"},
+		"t":    {CertRef: "ct"},
+		"p":    {CertRef: "cp"},
+		"old1": {},
+	}
+	payments := []sqlite.PaymentRecord{
+		{ID: "1", UserID: "x"},
+		{ID: "2", UserID: "y"},
+		{ID: "3", UserID: "p"},
+		{ID: "4", UserID: "t", Refunded: true},
+	}
+
+	got := referralClusters(referrals, users, payments, since)
+	want := []referralCluster{
+		{Root: "farm", Invitees: 5, Depth: 1, NoConfig: 5, MaxPerDay: 5,
+			Flags: []string{"всплеск регистраций", "никто не оплатил", "большинство без конфига"}},
+		{Root: "chain", Invitees: 2, Depth: 2, Paid: 2, MaxPerDay: 1,
+			Flags: []string{"цепочка приглашений"}},
+		{Root: "rej", Invitees: 1, Depth: 1, Rejected: 1, MaxPerDay: 1,
+			Flags: []string{"отклонённые бонусы"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("referralClusters() =\n%+v\nwant\n%+v", got, want)
+	}
+}
+
+func TestParseReferralTiers(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []referralTier
+		wantErr bool
+	}{
+		{value: "1:15", want: []referralTier{{1, 15}}},
+		{value: "1:15,5:20,10:30", want: []referralTier{{1, 15}, {5, 20}, {10, 30}}},
+		{value: " 10:30, 1:15 ,5:20", want: []referralTier{{1, 15}, {5, 20}, {10, 30}}},
+		{value: "5:20,10:30", wantErr: true},
+		{value: "1:15,1:20", wantErr: true},
+		{value: "1:15,x:20", wantErr: true},
+		{value: "1-15", wantErr: true},
+		{value: "0:15", wantErr: true},
+		{value: "1:-5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseReferralTiers(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseReferralTiers(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseReferralTiers(%q) = %v, want %v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReferralTierFor(t *testing.T) {
+	saved := referralTiers
+	defer func() { referralTiers = saved }()
+	referralTiers = []referralTier{{1, 15}, {5, 20}, {10, 30}}
+
+	tests := []struct {
+		n        int
+		want     int64
+		next     referralTier
+		wantNext bool
+	}{
+		{n: 1, want: 15, next: referralTier{5, 20}, wantNext: true},
+		{n: 4, want: 15, next: referralTier{5, 20}, wantNext: true},
+		{n: 5, want: 20, next: referralTier{10, 30}, wantNext: true},
+		{n: 9, want: 20, next: referralTier{10, 30}, wantNext: true},
+		{n: 10, want: 30},
+		{n: 50, want: 30},
+	}
+	for _, tt := range tests {
+		if got := referralTierFor(tt.n).Days; got != tt.want {
+			t.Errorf("referralTierFor(%d) = %d дн., want %d", tt.n, got, tt.want)
+		}
+		next, ok := nextReferralTier(tt.n)
+		if ok != tt.wantNext || next != tt.next {
+			t.Errorf("nextReferralTier(%d) = %v, %v, want %v, %v", tt.n, next, ok, tt.next, tt.wantNext)
+		}
+	}
+}
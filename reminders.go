@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // часовые пояса пользователей не должны зависеть от tzdata на сервере
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultTimezone = "Europe/Moscow"
+
+var (
+	// reminderDays — за сколько дней до нуля напоминать о продлении (REMIND_DAYS), по убыванию
+	reminderDays = []int64{3, 1}
+	// quietFrom и quietTo — тихие часы по времени пользователя (QUIET_HOURS), напоминания откладываются
+	quietFrom = 22
+	quietTo   = 9
+)
+
+// reminderTimezones — часовые пояса, которые можно выбрать в профиле
+var reminderTimezones = []struct {
+	Name  string
+	Title string
+}{
+	{"Europe/Kaliningrad", "Калининград (UTC+2)"},
+	{"Europe/Moscow", "Москва (UTC+3)"},
+	{"Europe/Samara", "Самара (UTC+4)"},
+	{"Asia/Yekaterinburg", "Екатеринбург (UTC+5)"},
+	{"Asia/Omsk", "Омск (UTC+6)"},
+	{"Asia/Novosibirsk", "Новосибирск (UTC+7)"},
+	{"Asia/Irkutsk", "Иркутск (UTC+8)"},
+	{"Asia/Yakutsk", "Якутск (UTC+9)"},
+	{"Asia/Vladivostok", "Владивосток (UTC+10)"},
+	{"Asia/Magadan", "Магадан (UTC+11)"},
+	{"Asia/Kamchatka", "Камчатка (UTC+12)"},
+}
+
+// loadReminderSettings читает REMIND_DAYS ("3,1"; "off" — без напоминаний) и QUIET_HOURS ("22-9")
+func loadReminderSettings() {
+	if v := strings.TrimSpace(os.Getenv("REMIND_DAYS")); v != "" {
+		if strings.EqualFold(v, "off") {
+			reminderDays = nil
+		} else if days, err := parseReminderDays(v); err != nil {
+			log.Printf("invalid REMIND_DAYS=%q: %v", v, err)
+		} else {
+			reminderDays = days
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("QUIET_HOURS")); v != "" {
+		from, to, ok := strings.Cut(v, "-")
+		f, errF := strconv.Atoi(strings.TrimSpace(from))
+		t, errT := strconv.Atoi(strings.TrimSpace(to))
+		if !ok || errF != nil || errT != nil || f < 0 || f > 23 || t < 0 || t > 23 {
+			log.Printf("invalid QUIET_HOURS=%q, using %d-%d", v, quietFrom, quietTo)
+		} else {
+			quietFrom, quietTo = f, t
+		}
+	}
+}
+
+func parseReminderDays(v string) ([]int64, error) {
+	var days []int64
+	for _, part := range strings.Split(v, ",") {
+		n, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("ожидаются положительные числа через запятую")
+		}
+		days = append(days, n)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i] > days[j] })
+	return days, nil
+}
+
+// dueReminder возвращает порог, о котором пора напомнить при остатке days. sent — порог,
+// о котором уже напоминали в этом цикле: о нём и о более ранних повторно не пишем.
+func dueReminder(days, sent int64) (int64, bool) {
+	var threshold int64
+	for _, t := range reminderDays {
+		if days <= t {
+			threshold = t
+		}
+	}
+	if threshold == 0 || (sent != 0 && threshold >= sent) {
+		return 0, false
+	}
+	return threshold, true
+}
+
+func userLocation(user sqlite.UserData) *time.Location {
+	name := user.Timezone
+	if name == "" {
+		name = defaultTimezone
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("unknown timezone %q: %v", name, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// formatUserTime форматирует момент времени в часовом поясе пользователя
+func formatUserTime(user sqlite.UserData, t time.Time) string {
+	return t.In(userLocation(user)).Format("02.01.2006 15:04")
+}
+
+func timezoneTitle(name string) string {
+	if name == "" {
+		name = defaultTimezone
+	}
+	for _, tz := range reminderTimezones {
+		if tz.Name == name {
+			return tz.Title
+		}
+	}
+	return name
+}
+
+// inQuietHours сообщает, попадает ли момент now в тихие часы по местному времени
+func inQuietHours(now time.Time, loc *time.Location) bool {
+	if quietFrom == quietTo {
+		return false
+	}
+	hour := now.In(loc).Hour()
+	if quietFrom < quietTo {
+		return hour >= quietFrom && hour < quietTo
+	}
+	return hour >= quietFrom || hour < quietTo
+}
+
+// checkExpiryReminder напоминает о скором окончании баланса. Вызывается из expiryWorker
+// для владельцев баланса с положительным остатком; напоминание в тихие часы откладывается
+// до следующей проверки.
+func checkExpiryReminder(store *sqlite.Store, bot *tgbotapi.BotAPI, userID string, userData sqlite.UserData, now time.Time) {
+	// баланс пополнили выше последнего напоминания — начинается новый цикл
+	sent := userData.ReminderSent
+	if sent != 0 && userData.Days > sent {
+		if err := store.SetReminderSent(userID, 0); err != nil {
+			log.Printf("SetReminderSent error: %v", err)
+			return
+		}
+		sent = 0
+	}
+
+	if userData.RemindersOff || userData.Banned(now) || (userData.AutopayEnabled && userData.PaymentMethodID != "") {
+		return
+	}
+	threshold, ok := dueReminder(userData.Days, sent)
+	if !ok || inQuietHours(now, userLocation(userData)) {
+		return
+	}
+
+	chatID, err := strconv.ParseInt(userID, 10, 64)
+	if err != nil {
+		log.Printf("failed to parse chat id %s: %v", userID, err)
+		return
+	}
+	text := fmt.Sprintf("⏳ Подписка действует до <b>%s</b> (осталось %d дн.). Когда она закончится, VPN отключится.\n\nПродлите подписку заранее, чтобы не потерять доступ.",
+		formatUserTime(userData, userData.ExpiresAt(now)), userData.Days)
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ParseMode = "HTML"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("💳 Продлить", "remind_renew"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🔕 Не напоминать", "remind_off"),
+		),
+	)
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("send reminder to %s error: %v", userID, err)
+		return
+	}
+	if err := store.SetReminderSent(userID, threshold); err != nil {
+		log.Printf("SetReminderSent error: %v", err)
+	}
+}
+
+func showReminders(bot *tgbotapi.BotAPI, chatID int64, session *UserSession, userID string) {
+	user, _ := sqliteClient.GetUser(userID)
+
+	status := "включены"
+	toggle := tgbotapi.NewInlineKeyboardButtonData("🔕 Отключить", "remind_off")
+	if user.RemindersOff {
+		status = "отключены"
+		toggle = tgbotapi.NewInlineKeyboardButtonData("🔔 Включить", "remind_on")
+	}
+	var when []string
+	for _, d := range reminderDays {
+		when = append(when, strconv.FormatInt(d, 10))
+	}
+	schedule := "не настроены администратором"
+	if len(when) > 0 {
+		schedule = fmt.Sprintf("за %s дн. до окончания баланса", strings.Join(when, " и "))
+	}
+
+	text := fmt.Sprintf("🔔 <b>Напоминания</b>\n\n"+
+		"├ Статус: %s\n"+
+		"├ Когда: %s\n"+
+		"└ Часовой пояс: %s\n\n"+
+		"С %02d:00 до %02d:00 по вашему времени напоминания не приходят.",
+		status, schedule, timezoneTitle(user.Timezone), quietFrom, quietTo)
+
+	marketing := "включены"
+	marketingToggle := tgbotapi.NewInlineKeyboardButtonData("📢 Отписаться от рассылок", "unsub_marketing")
+	if user.MarketingOff {
+		marketing = "отключены"
+		marketingToggle = tgbotapi.NewInlineKeyboardButtonData("📢 Подписаться на рассылки", "sub_marketing")
+	}
+	text += fmt.Sprintf("\n\n📢 Рассылки об акциях и новостях: %s. Сервисные сообщения приходят всегда.", marketing)
+
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(toggle),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🕒 Часовой пояс", "remind_tz"),
+		),
+		tgbotapi.NewInlineKeyboardRow(marketingToggle),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "nav_status"),
+		),
+	)
+	if err := updateSessionText(bot, chatID, session, stateStatus, text, "HTML", kb); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+}
+
+func showTimezoneSelection(bot *tgbotapi.BotAPI, chatID int64, session *UserSession) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, tz := range reminderTimezones {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(tz.Title, "remind_tz_"+tz.Name),
+		))
+	}
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "nav_reminders"),
+	))
+	if err := updateSessionText(bot, chatID, session, stateStatus, "🕒 Выберите ваш часовой пояс:", "HTML", tgbotapi.NewInlineKeyboardMarkup(rows...)); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+}
+
+// handleReminderCallback обрабатывает кнопки remind_*: из профиля и из самого напоминания
+func handleReminderCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession, data string) {
+	chatID := cq.Message.Chat.ID
+	userID := strconv.FormatInt(cq.From.ID, 10)
+
+	switch {
+	case data == "remind_renew":
+		// напоминание приходит отдельным сообщением — дальше работаем в нём
+		session.MessageID = cq.Message.MessageID
+		days, _ := sqliteClient.GetDays(userID)
+		if err := showRateSelection(bot, chatID, session, fmt.Sprintf("Текущий баланс: %d дней. Выберите пополнение.", days)); err != nil {
+			log.Printf("showRateSelection error: %v", err)
+		}
+		ackCallback(bot, cq, "")
+	case data == "remind_on", data == "remind_off":
+		enabled := data == "remind_on"
+		if err := sqliteClient.SetRemindersEnabled(userID, enabled); err != nil {
+			log.Printf("SetRemindersEnabled error: %v", err)
+			ackCallback(bot, cq, "❌ Не удалось сохранить настройку")
+			return
+		}
+		if cq.Message.MessageID == session.MessageID {
+			showReminders(bot, chatID, session, userID)
+		}
+		if enabled {
+			ackCallback(bot, cq, "🔔 Напоминания включены")
+		} else {
+			ackCallback(bot, cq, "🔕 Напоминания отключены. Включить снова можно в профиле.")
+		}
+	case data == "remind_tz":
+		showTimezoneSelection(bot, chatID, session)
+		ackCallback(bot, cq, "")
+	case strings.HasPrefix(data, "remind_tz_"):
+		name := strings.TrimPrefix(data, "remind_tz_")
+		if _, err := time.LoadLocation(name); err != nil {
+			ackCallback(bot, cq, "❌ Неизвестный часовой пояс")
+			return
+		}
+		if err := sqliteClient.SetTimezone(userID, name); err != nil {
+			log.Printf("SetTimezone error: %v", err)
+			ackCallback(bot, cq, "❌ Не удалось сохранить настройку")
+			return
+		}
+		showReminders(bot, chatID, session, userID)
+		ackCallback(bot, cq, "✅ Часовой пояс сохранён")
+	default:
+		ackCallback(bot, cq, "")
+	}
+}
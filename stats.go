@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	// statsChartDays — за сколько последних дней строится график выручки и регистраций
+	statsChartDays = 30
+	rubCurrency    = "RUB"
+)
+
+// revenue — сумма платежей по валютам
+type revenue map[string]float64
+
+func (r revenue) String() string {
+	if len(r) == 0 {
+		return "0"
+	}
+	var parts []string
+	if v, ok := r[rubCurrency]; ok {
+		parts = append(parts, fmt.Sprintf("%.2f ₽", v))
+	}
+	if v, ok := r[starsCurrency]; ok {
+		parts = append(parts, fmt.Sprintf("%.0f ⭐️", v))
+	}
+	for currency, v := range r {
+		if currency != rubCurrency && currency != starsCurrency {
+			parts = append(parts, fmt.Sprintf("%.2f %s", v, currency))
+		}
+	}
+	return strings.Join(parts, " + ")
+}
+
+type planRevenue struct {
+	PlanID  string
+	Count   int
+	Revenue revenue
+}
+
+// botStats — сводка для /stats
+type botStats struct {
+	Total, Active, Expired, Inactive int
+	NewToday, New7, New30            int
+	Churned30                        int
+	AvgDays                          float64
+	Referred, ReferredPaid           int
+
+	RevenueToday, Revenue7, Revenue30, RevenueAll revenue
+	Plans30                                       []planRevenue
+
+	DailyRevenue []float64 // выручка в рублях по дням, последний элемент — сегодня
+	DailySignups []int
+}
+
+// userActive сообщает, есть ли у пользователя доступ к VPN: оплаченное время или льготный
+// период; участник семьи активен, пока активна подписка владельца
+func userActive(users map[string]sqlite.UserData, u sqlite.UserData) bool {
+	if u.FamilyOwner != "" {
+		u = users[u.FamilyOwner]
+	}
+	return u.Days > 0 || u.InGrace()
+}
+
+// collectStats считает статистику по пользователям и платежам. Границы суток — по
+// часовому поясу по умолчанию.
+func collectStats(users map[string]sqlite.UserData, payments []sqlite.PaymentRecord, now time.Time) botStats {
+	loc, err := time.LoadLocation(defaultTimezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	y, m, d := now.In(loc).Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, loc)
+	dayIndex := func(t time.Time) int {
+		// номер дня на графике: statsChartDays-1 — сегодня, отрицательный — раньше графика
+		return statsChartDays - 1 - int(today.Sub(time.Date(t.In(loc).Year(), t.In(loc).Month(), t.In(loc).Day(), 0, 0, 0, 0, loc)).Hours()/24)
+	}
+
+	st := botStats{
+		RevenueToday: revenue{}, Revenue7: revenue{}, Revenue30: revenue{}, RevenueAll: revenue{},
+		DailyRevenue: make([]float64, statsChartDays),
+		DailySignups: make([]int, statsChartDays),
+	}
+
+	paid := make(map[string]bool)
+	for _, p := range payments {
+		if !p.Refunded {
+			paid[p.UserID] = true
+		}
+	}
+
+	var balanceSum int64
+	var balanceCount int
+	for id, u := range users {
+		st.Total++
+		switch {
+		case userActive(users, u):
+			st.Active++
+			if u.FamilyOwner == "" {
+				balanceSum += u.Days
+				balanceCount++
+			}
+		case u.CertRef != "":
+			st.Expired++
+		default:
+			st.Inactive++
+		}
+
+		if created, err := time.Parse(time.RFC3339, u.CreatedAt); err == nil {
+			switch age := now.Sub(created); {
+			case !created.Before(today):
+				st.NewToday++
+				st.New7++
+				st.New30++
+			case age < 7*24*time.Hour:
+				st.New7++
+				st.New30++
+			case age < 30*24*time.Hour:
+				st.New30++
+			}
+			if i := dayIndex(created); i >= 0 && i < statsChartDays {
+				st.DailySignups[i]++
+			}
+		}
+
+		if expired, err := time.Parse(time.RFC3339, u.ExpiredAt); err == nil && now.Sub(expired) < 30*24*time.Hour && !userActive(users, u) {
+			st.Churned30++
+		}
+
+		if u.ReferredBy != "" {
+			st.Referred++
+			if paid[id] {
+				st.ReferredPaid++
+			}
+		}
+	}
+	if balanceCount > 0 {
+		st.AvgDays = float64(balanceSum) / float64(balanceCount)
+	}
+
+	plans := make(map[string]*planRevenue)
+	for _, p := range payments {
+		if p.Refunded {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339, p.CreatedAt)
+		if err != nil {
+			continue
+		}
+		st.RevenueAll[p.Currency] += p.Amount
+		age := now.Sub(created)
+		if !created.Before(today) {
+			st.RevenueToday[p.Currency] += p.Amount
+		}
+		if age < 7*24*time.Hour {
+			st.Revenue7[p.Currency] += p.Amount
+		}
+		if age < 30*24*time.Hour {
+			st.Revenue30[p.Currency] += p.Amount
+			pr, ok := plans[p.PlanID]
+			if !ok {
+				pr = &planRevenue{PlanID: p.PlanID, Revenue: revenue{}}
+				plans[p.PlanID] = pr
+			}
+			pr.Count++
+			pr.Revenue[p.Currency] += p.Amount
+		}
+		if i := dayIndex(created); i >= 0 && i < statsChartDays && p.Currency == rubCurrency {
+			st.DailyRevenue[i] += p.Amount
+		}
+	}
+	for _, pr := range plans {
+		st.Plans30 = append(st.Plans30, *pr)
+	}
+	sort.Slice(st.Plans30, func(i, j int) bool {
+		if st.Plans30[i].Revenue[rubCurrency] != st.Plans30[j].Revenue[rubCurrency] {
+			return st.Plans30[i].Revenue[rubCurrency] > st.Plans30[j].Revenue[rubCurrency]
+		}
+		return st.Plans30[i].Count > st.Plans30[j].Count
+	})
+	return st
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part) * 100 / float64(total)
+}
+
+// formatStats собирает HTML-сводку для /stats
+func formatStats(st botStats) string {
+	var b strings.Builder
+	b.WriteString("📊 <b>Статистика</b>\n\n")
+
+	fmt.Fprintf(&b, "👥 <b>Пользователи:</b> %d\n", st.Total)
+	fmt.Fprintf(&b, "├ 🟢 Активны: %d\n", st.Active)
+	fmt.Fprintf(&b, "├ 🔴 Подписка закончилась: %d\n", st.Expired)
+	fmt.Fprintf(&b, "└ ⚪️ Не подключались: %d\n\n", st.Inactive)
+
+	fmt.Fprintf(&b, "🆕 <b>Новые:</b> сегодня %d, за 7 дней %d, за 30 дней %d\n", st.NewToday, st.New7, st.New30)
+	fmt.Fprintf(&b, "📉 <b>Отток за 30 дней:</b> %d (%.1f%%)\n", st.Churned30, percent(st.Churned30, st.Active+st.Churned30))
+	fmt.Fprintf(&b, "⏳ <b>Средний баланс:</b> %.1f дн.\n\n", st.AvgDays)
+
+	b.WriteString("💰 <b>Выручка:</b>\n")
+	fmt.Fprintf(&b, "├ Сегодня: %s\n", st.RevenueToday)
+	fmt.Fprintf(&b, "├ 7 дней: %s\n", st.Revenue7)
+	fmt.Fprintf(&b, "├ 30 дней: %s\n", st.Revenue30)
+	fmt.Fprintf(&b, "└ Всего: %s\n", st.RevenueAll)
+
+	if len(st.Plans30) > 0 {
+		b.WriteString("\n🧾 <b>Тарифы за 30 дней:</b>\n")
+		for _, pr := range st.Plans30 {
+			title := pr.PlanID
+			if plan, ok := planByID(pr.PlanID); ok {
+				title = plan.Title
+			}
+			fmt.Fprintf(&b, "• %s — %d опл., %s\n", html.EscapeString(title), pr.Count, pr.Revenue)
+		}
+	}
+
+	fmt.Fprintf(&b, "\n🤝 <b>Рефералы:</b> приглашено %d, оплатили %d (%.1f%%)", st.Referred, st.ReferredPaid, percent(st.ReferredPaid, st.Referred))
+	return b.String()
+}
+
+// renderStatsChart рисует PNG с двумя столбчатыми диаграммами по дням: выручка в рублях
+// сверху и регистрации снизу. Подписей нет — масштаб указывается в подписи к картинке.
+func renderStatsChart(dailyRevenue []float64, dailySignups []int) ([]byte, error) {
+	const (
+		width, height = 900, 500
+		margin        = 20
+		gap           = 30
+	)
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.White}, image.Point{}, draw.Src)
+
+	panelHeight := (height - 2*margin - gap) / 2
+	signups := make([]float64, len(dailySignups))
+	for i, v := range dailySignups {
+		signups[i] = float64(v)
+	}
+	drawBars(img, image.Rect(margin, margin, width-margin, margin+panelHeight), dailyRevenue, color.RGBA{46, 160, 67, 255})
+	drawBars(img, image.Rect(margin, margin+panelHeight+gap, width-margin, height-margin), signups, color.RGBA{33, 118, 199, 255})
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func drawBars(img *image.RGBA, area image.Rectangle, values []float64, barColor color.Color) {
+	grid := &image.Uniform{color.RGBA{225, 225, 225, 255}}
+	for i := 0; i <= 4; i++ {
+		y := area.Max.Y - area.Dy()*i/4
+		draw.Draw(img, image.Rect(area.Min.X, y, area.Max.X, y+1), grid, image.Point{}, draw.Src)
+	}
+
+	maxValue := 0.0
+	for _, v := range values {
+		if v > maxValue {
+			maxValue = v
+		}
+	}
+	if maxValue == 0 || len(values) == 0 {
+		return
+	}
+	slot := area.Dx() / len(values)
+	fill := &image.Uniform{barColor}
+	for i, v := range values {
+		h := int(float64(area.Dy()) * v / maxValue)
+		x := area.Min.X + i*slot
+		draw.Draw(img, image.Rect(x+slot/6, area.Max.Y-h, x+slot-slot/6, area.Max.Y), fill, image.Point{}, draw.Src)
+	}
+}
+
+// handleStatsCommand — /stats, сводка по пользователям, выручке и рефералам с графиком
+func handleStatsCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	st := collectStats(sqliteClient.GetAllUsers(), sqliteClient.GetPayments(), time.Now())
+
+	reply := tgbotapi.NewMessage(msg.Chat.ID, formatStats(st))
+	reply.ParseMode = "HTML"
+	if _, err := bot.Send(reply); err != nil {
+		log.Printf("send stats error: %v", err)
+	}
+
+	maxRevenue, maxSignups := 0.0, 0
+	for i := range st.DailyRevenue {
+		maxRevenue = max(maxRevenue, st.DailyRevenue[i])
+		maxSignups = max(maxSignups, st.DailySignups[i])
+	}
+	if maxRevenue == 0 && maxSignups == 0 {
+		return
+	}
+	chart, err := renderStatsChart(st.DailyRevenue, st.DailySignups)
+	if err != nil {
+		log.Printf("renderStatsChart error: %v", err)
+		return
+	}
+	photo := tgbotapi.NewPhoto(msg.Chat.ID, tgbotapi.FileBytes{Name: "stats.png", Bytes: chart})
+	photo.Caption = fmt.Sprintf("📈 За %d дней, по дням: выручка в рублях (зелёный, максимум %.2f ₽) и регистрации (синий, максимум %d). Линии сетки — каждые 25%% от максимума.",
+		statsChartDays, maxRevenue, maxSignups)
+	if _, err := bot.Send(photo); err != nil {
+		log.Printf("send stats chart error: %v", err)
+	}
+}
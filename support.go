@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// supportContact — куда писать, если обращения в боте не настроены
+const supportContact = "@happycatvpn"
+
+var (
+	// supportChatID — группа поддержки (SUPPORT_CHAT_ID); 0 — обращения отключены
+	supportChatID int64
+	// supportTopicID — тема форума в группе поддержки (SUPPORT_TOPIC_ID); 0 — без темы
+	supportTopicID int
+)
+
+func loadSupportSettings() {
+	if v := strings.TrimSpace(os.Getenv("SUPPORT_CHAT_ID")); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			log.Printf("invalid SUPPORT_CHAT_ID=%q, support tickets are disabled", v)
+			return
+		}
+		supportChatID = id
+	}
+	supportTopicID = envInt("SUPPORT_TOPIC_ID", 0)
+}
+
+func handleSupport(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
+	chatID := cq.Message.Chat.ID
+	userID := strconv.FormatInt(cq.From.ID, 10)
+
+	var supportText string
+	kb := singleBackKeyboard("nav_menu")
+	switch ticket, open, err := sqliteClient.OpenTicketFor(userID); {
+	case supportChatID == 0:
+		supportText = `📞 <b>Служба поддержки HappyCat VPN</b>
+
+Напиши нам в Telegram: ` + supportContact + `
+<i>Мы отвечаем 24/7 и всегда рядом, если нужна помощь.</i>`
+	case err != nil:
+		log.Printf("OpenTicketFor error: %v", err)
+		supportText = "❌ Не удалось открыть поддержку. Попробуйте позже или напишите " + supportContact
+	case open:
+		supportText = fmt.Sprintf("💬 <b>Обращение №%d</b>\n\nОбращение открыто. Напишите сообщение или пришлите скриншот — ответ поддержки придёт в этот чат.", ticket.ID)
+		kb = tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("✅ Вопрос решён", "ticket_close"),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("⬅️ Назад", "nav_menu"),
+			),
+		)
+	default:
+		supportText = `📞 <b>Служба поддержки HappyCat VPN</b>
+
+Опишите проблему одним или несколькими сообщениями, можно приложить скриншоты. Ответ придёт в этот чат.
+<i>Мы отвечаем 24/7 и всегда рядом, если нужна помощь.</i>`
+	}
+
+	if err := updateSessionText(bot, chatID, session, stateSupport, supportText, "HTML", kb); err != nil {
+		log.Printf("updateSessionText error: %v", err)
+	}
+
+	notifyAdmins(bot, eventActivity, fmt.Sprintf("Пользователь id:%d открыл раздел поддержки", cq.From.ID), cq.From.UserName, int64(cq.From.ID))
+}
+
+// handleTicketMessage пересылает сообщение пользователя в поддержку, если он в разделе поддержки
+// или у него есть открытое обращение. Возвращает false, если сообщение не относится к поддержке.
+func handleTicketMessage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession) bool {
+	if supportChatID == 0 {
+		return false
+	}
+	userID := strconv.FormatInt(msg.From.ID, 10)
+	ticket, open, err := sqliteClient.OpenTicketFor(userID)
+	if err != nil {
+		log.Printf("OpenTicketFor error: %v", err)
+		return false
+	}
+	if !open && session.State != stateSupport {
+		return false
+	}
+
+	if !open {
+		ticket, err = openTicket(bot, msg)
+		if err != nil {
+			log.Printf("openTicket error: %v", err)
+			bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Не удалось создать обращение. Попробуйте позже или напишите "+supportContact))
+			return true
+		}
+		reply := tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("📨 Обращение №%d создано. Можете дописать подробности или приложить скриншоты — ответ придёт в этот чат.", ticket.ID))
+		reply.ReplyToMessageID = msg.MessageID
+		bot.Send(reply)
+	}
+
+	// сообщения обращения идут ответом на его карточку, чтобы в группе получилась ветка
+	relayID, err := supportCopy(bot, msg.Chat.ID, msg.MessageID, ticket.RelayIDs[0])
+	if err != nil {
+		log.Printf("support relay error: %v", err)
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ Не удалось передать сообщение в поддержку. Попробуйте ещё раз чуть позже."))
+		return true
+	}
+	if err := sqliteClient.AddTicketMessage(ticket.ID, sqlite.TicketMessage{
+		From:   sqlite.TicketFromUser,
+		Text:   messageText(msg),
+		FileID: attachmentFileID(msg),
+	}, relayID); err != nil {
+		log.Printf("AddTicketMessage error: %v", err)
+	}
+	return true
+}
+
+// openTicket создаёт обращение и публикует в группе поддержки карточку с данными пользователя
+func openTicket(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) (sqlite.Ticket, error) {
+	userID := strconv.FormatInt(msg.From.ID, 10)
+	ticket, _, err := sqliteClient.CreateTicket(userID, time.Now())
+	if err != nil {
+		return ticket, err
+	}
+
+	card, err := userCard(userID)
+	if err != nil {
+		card = fmt.Sprintf("👤 <code>%s</code>: %v", userID, html.EscapeString(err.Error()))
+	}
+	from := fmt.Sprintf("<a href=\"tg://user?id=%d\">%s</a>", msg.From.ID, html.EscapeString(msg.From.FirstName))
+	if msg.From.UserName != "" {
+		from += " @" + html.EscapeString(msg.From.UserName)
+	}
+	text := fmt.Sprintf("🆕 <b>Обращение №%d</b> от %s\n\n%s\n\n<i>Ответьте реплаем на сообщение обращения — ответ уйдёт пользователю. /close реплаем закрывает обращение.</i>", ticket.ID, from, card)
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Закрыть", fmt.Sprintf("ticket_close_%d", ticket.ID)),
+		),
+	)
+	headerID, err := supportSend(bot, text, 0, &kb)
+	if err != nil {
+		return ticket, err
+	}
+	if err := sqliteClient.AddTicketRelay(ticket.ID, headerID); err != nil {
+		return ticket, err
+	}
+	ticket.RelayIDs = append(ticket.RelayIDs, headerID)
+	return ticket, nil
+}
+
+// handleSupportChatMessage пересылает пользователю ответы сотрудников из группы поддержки
+func handleSupportChatMessage(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	if msg.ReplyToMessage == nil || msg.From == nil || msg.From.IsBot {
+		return
+	}
+	ticket, ok, err := sqliteClient.TicketByRelay(msg.ReplyToMessage.MessageID)
+	if err != nil {
+		log.Printf("TicketByRelay error: %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	agentID := strconv.FormatInt(msg.From.ID, 10)
+
+	if msg.IsCommand() && msg.Command() == "close" {
+		closeTicket(bot, ticket.ID, agentID, agentName(msg.From), false)
+		return
+	}
+	if ticket.Status != sqlite.TicketOpen {
+		supportSend(bot, fmt.Sprintf("Обращение №%d закрыто, ответ пользователю не отправлен.", ticket.ID), msg.MessageID, nil)
+		return
+	}
+
+	chatID, err := strconv.ParseInt(ticket.UserID, 10, 64)
+	if err != nil {
+		log.Printf("failed to parse chat id %s: %v", ticket.UserID, err)
+		return
+	}
+	cfg := tgbotapi.NewCopyMessage(chatID, msg.Chat.ID, msg.MessageID)
+	cfg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Вопрос решён", "ticket_close"),
+		),
+	)
+	if _, err := bot.Request(cfg); err != nil {
+		log.Printf("support reply to %s error: %v", ticket.UserID, err)
+		text := "❌ Не удалось доставить ответ."
+		if isBlockedError(err) {
+			text = "❌ Пользователь заблокировал бота, ответ не доставлен."
+		}
+		supportSend(bot, text, msg.MessageID, nil)
+		return
+	}
+	// на ответ сотрудника тоже можно ответить реплаем — он остаётся в ветке обращения
+	if err := sqliteClient.AddTicketMessage(ticket.ID, sqlite.TicketMessage{
+		From:    sqlite.TicketFromSupport,
+		AgentID: agentID,
+		Text:    messageText(msg),
+		FileID:  attachmentFileID(msg),
+	}, msg.MessageID); err != nil {
+		log.Printf("AddTicketMessage error: %v", err)
+	}
+}
+
+// handleSupportChatCallback — кнопка «✅ Закрыть» на карточке обращения в группе поддержки
+func handleSupportChatCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery) {
+	id, err := strconv.Atoi(strings.TrimPrefix(cq.Data, "ticket_close_"))
+	if err != nil || !strings.HasPrefix(cq.Data, "ticket_close_") {
+		ackCallback(bot, cq, "")
+		return
+	}
+	if closeTicket(bot, id, strconv.FormatInt(cq.From.ID, 10), agentName(cq.From), false) {
+		bot.Send(tgbotapi.NewEditMessageReplyMarkup(cq.Message.Chat.ID, cq.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+		ackCallback(bot, cq, "Обращение закрыто")
+		return
+	}
+	ackCallback(bot, cq, "Обращение уже закрыто")
+}
+
+// handleTicketCloseCallback — пользователь сам закрывает своё обращение
+func handleTicketCloseCallback(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
+	userID := strconv.FormatInt(cq.From.ID, 10)
+	ticket, open, err := sqliteClient.OpenTicketFor(userID)
+	if err != nil || !open {
+		ackCallback(bot, cq, "Открытых обращений нет")
+		return
+	}
+	closeTicket(bot, ticket.ID, userID, "", true)
+	if cq.Message.MessageID == session.MessageID {
+		handleSupport(bot, cq, session)
+	} else {
+		bot.Send(tgbotapi.NewEditMessageReplyMarkup(cq.Message.Chat.ID, cq.Message.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+	}
+	ackCallback(bot, cq, "✅ Обращение закрыто. Спасибо!")
+}
+
+// closeTicket закрывает обращение и сообщает об этом второй стороне. Возвращает false,
+// если обращение уже было закрыто.
+func closeTicket(bot *tgbotapi.BotAPI, ticketID int, closedBy, closedByName string, byUser bool) bool {
+	ticket, err := sqliteClient.CloseTicket(ticketID, closedBy, time.Now())
+	if errors.Is(err, sqlite.ErrTicketClosed) {
+		return false
+	}
+	if err != nil {
+		log.Printf("CloseTicket error: %v", err)
+		return false
+	}
+
+	headerID := 0
+	if len(ticket.RelayIDs) > 0 {
+		headerID = ticket.RelayIDs[0]
+	}
+	if byUser {
+		supportSend(bot, fmt.Sprintf("✅ Обращение №%d закрыто пользователем.", ticket.ID), headerID, nil)
+		return true
+	}
+	supportSend(bot, fmt.Sprintf("✅ Обращение №%d закрыто: %s.", ticket.ID, html.EscapeString(closedByName)), headerID, nil)
+	if chatID, err := strconv.ParseInt(ticket.UserID, 10, 64); err == nil {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Обращение №%d закрыто. Если вопрос остался, напишите в «💬 Поддержка» ещё раз.", ticket.ID)))
+	}
+	return true
+}
+
+func agentName(u *tgbotapi.User) string {
+	if u.UserName != "" {
+		return "@" + u.UserName
+	}
+	return strings.TrimSpace(u.FirstName + " " + u.LastName)
+}
+
+// supportSend отправляет сообщение в группу поддержки (в тему SUPPORT_TOPIC_ID, если она задана).
+// Запрос собирается вручную: в этой версии библиотеки нет message_thread_id.
+func supportSend(bot *tgbotapi.BotAPI, text string, replyTo int, kb *tgbotapi.InlineKeyboardMarkup) (int, error) {
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", supportChatID)
+	params.AddNonZero("message_thread_id", supportTopicID)
+	params.AddNonEmpty("text", text)
+	params.AddNonEmpty("parse_mode", "HTML")
+	params.AddBool("disable_web_page_preview", true)
+	params.AddNonZero("reply_to_message_id", replyTo)
+	params.AddBool("allow_sending_without_reply", replyTo != 0)
+	if kb != nil {
+		if err := params.AddInterface("reply_markup", kb); err != nil {
+			return 0, err
+		}
+	}
+	resp, err := bot.MakeRequest("sendMessage", params)
+	if err != nil {
+		log.Printf("support chat send error: %v", err)
+		return 0, err
+	}
+	var sent tgbotapi.Message
+	if err := json.Unmarshal(resp.Result, &sent); err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
+}
+
+// supportCopy копирует сообщение пользователя в группу поддержки ответом на replyTo
+func supportCopy(bot *tgbotapi.BotAPI, fromChatID int64, messageID, replyTo int) (int, error) {
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", supportChatID)
+	params.AddNonZero("message_thread_id", supportTopicID)
+	params.AddNonZero64("from_chat_id", fromChatID)
+	params.AddNonZero("message_id", messageID)
+	params.AddNonZero("reply_to_message_id", replyTo)
+	params.AddBool("allow_sending_without_reply", replyTo != 0)
+	resp, err := bot.MakeRequest("copyMessage", params)
+	if err != nil {
+		return 0, err
+	}
+	var copied tgbotapi.MessageID
+	if err := json.Unmarshal(resp.Result, &copied); err != nil {
+		return 0, err
+	}
+	return copied.MessageID, nil
+}
+
+func messageText(msg *tgbotapi.Message) string {
+	if msg.Text != "" {
+		return msg.Text
+	}
+	return msg.Caption
+}
+
+// attachmentFileID возвращает file_id вложения, чтобы вложения оставались в истории обращения
+func attachmentFileID(msg *tgbotapi.Message) string {
+	switch {
+	case len(msg.Photo) > 0:
+		return msg.Photo[len(msg.Photo)-1].FileID
+	case msg.Document != nil:
+		return msg.Document.FileID
+	case msg.Video != nil:
+		return msg.Video.FileID
+	case msg.Voice != nil:
+		return msg.Voice.FileID
+	case msg.Sticker != nil:
+		return msg.Sticker.FileID
+	}
+	return ""
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	pfsense "github.com/Asort97/vpnBot/clients/pfSense"
+	sqlite "github.com/Asort97/vpnBot/clients/sqLite"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// transferLimits — ограничения самостоятельных переводов, чтобы дни нельзя было
+// быстро перегонять между аккаунтами (например, накопленные бонусы)
+var transferLimits = sqlite.TransferLimits{
+	MaxDays:        90,
+	MaxTransfers:   3,
+	Period:         30 * 24 * time.Hour,
+	RequirePayment: true,
+}
+
+func transferCancelKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Отмена", "nav_menu"),
+		),
+	)
+}
+
+// handleTransferCommand начинает перевод дней: /transfer
+func handleTransferCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession) {
+	chatID := msg.Chat.ID
+	userID := strconv.FormatInt(msg.From.ID, 10)
+	user, _ := sqliteClient.GetUser(userID)
+
+	session.TransferDays = 0
+	session.TransferTo = ""
+
+	if user.FamilyOwner != "" {
+		_ = updateSessionText(bot, chatID, session, stateMenu, "❌ Участники семьи не могут переводить общий баланс.", "HTML", singleBackKeyboard("nav_menu"))
+		return
+	}
+	if user.Days <= 0 {
+		_ = updateSessionText(bot, chatID, session, stateMenu, "❌ На балансе нет дней для перевода.", "HTML", singleBackKeyboard("nav_menu"))
+		return
+	}
+	if !sqliteClient.HasPaid(userID) {
+		_ = updateSessionText(bot, chatID, session, stateMenu, "❌ Переводить дни можно после первой оплаты подписки. Бонусные дни передать нельзя.", "HTML", singleBackKeyboard("nav_menu"))
+		return
+	}
+
+	text := fmt.Sprintf("🔄 <b>Перевод дней</b>\n\nНа балансе: <b>%d дней</b>.\nСколько дней перевести? За один раз — не больше %d, не чаще %d раз в %d дней.",
+		user.Days, transferLimits.MaxDays, transferLimits.MaxTransfers, int(transferLimits.Period.Hours()/24))
+	_ = updateSessionText(bot, chatID, session, stateTransferDays, text, "HTML", transferCancelKeyboard())
+}
+
+func handleTransferDaysInput(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession) {
+	chatID := msg.Chat.ID
+	days, err := strconv.ParseInt(strings.TrimSpace(msg.Text), 10, 64)
+	if err != nil || days <= 0 {
+		_ = updateSessionText(bot, chatID, session, stateTransferDays, "❌ Отправьте количество дней числом, например 30.", "HTML", transferCancelKeyboard())
+		return
+	}
+	if days > transferLimits.MaxDays {
+		_ = updateSessionText(bot, chatID, session, stateTransferDays, fmt.Sprintf("❌ За один раз можно перевести не больше %d дней.", transferLimits.MaxDays), "HTML", transferCancelKeyboard())
+		return
+	}
+	// переводить можно только полные оплаченные сутки
+	if user, _ := sqliteClient.GetUser(strconv.FormatInt(msg.From.ID, 10)); user.Remaining(time.Now()) < time.Duration(days)*sqlite.Day {
+		full := int64(user.Remaining(time.Now()) / sqlite.Day)
+		_ = updateSessionText(bot, chatID, session, stateTransferDays, fmt.Sprintf("❌ Можно перевести не больше %d дней.", full), "HTML", transferCancelKeyboard())
+		return
+	}
+
+	session.TransferDays = days
+	text := "👤 Кому перевести? Отправьте Telegram ID получателя или перешлите сюда любое его сообщение.\n\nПолучатель должен хотя бы раз запустить бота."
+	_ = updateSessionText(bot, chatID, session, stateTransferRecipient, text, "HTML", transferCancelKeyboard())
+}
+
+func handleTransferRecipientInput(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, session *UserSession) {
+	chatID := msg.Chat.ID
+
+	var recipientID string
+	switch {
+	case msg.ForwardFrom != nil:
+		recipientID = strconv.FormatInt(msg.ForwardFrom.ID, 10)
+	case msg.ForwardSenderName != "":
+		// пользователь скрыл аккаунт в настройках пересылки
+		_ = updateSessionText(bot, chatID, session, stateTransferRecipient, "❌ Получатель скрывает аккаунт при пересылке. Попросите его прислать свой ID (он есть в профиле бота) и отправьте ID сюда.", "HTML", transferCancelKeyboard())
+		return
+	default:
+		if _, err := strconv.ParseInt(strings.TrimSpace(msg.Text), 10, 64); err != nil {
+			_ = updateSessionText(bot, chatID, session, stateTransferRecipient, "❌ Отправьте числовой Telegram ID или перешлите сообщение получателя.", "HTML", transferCancelKeyboard())
+			return
+		}
+		recipientID = strings.TrimSpace(msg.Text)
+	}
+
+	if recipientID == strconv.FormatInt(msg.From.ID, 10) {
+		_ = updateSessionText(bot, chatID, session, stateTransferRecipient, "❌ Нельзя перевести дни самому себе.", "HTML", transferCancelKeyboard())
+		return
+	}
+	if sqliteClient.IsNewUser(recipientID) {
+		_ = updateSessionText(bot, chatID, session, stateTransferRecipient, "❌ Этот пользователь ещё не запускал бота. Попросите его открыть бота и попробуйте снова.", "HTML", transferCancelKeyboard())
+		return
+	}
+
+	session.TransferTo = recipientID
+	text := fmt.Sprintf("🔄 Перевести <b>%d дней</b> пользователю <code>%s</code>?\n\nОтменить перевод после подтверждения нельзя.", session.TransferDays, recipientID)
+	kb := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Перевести", "transfer_confirm"),
+			tgbotapi.NewInlineKeyboardButtonData("⬅️ Отмена", "nav_menu"),
+		),
+	)
+	_ = updateSessionText(bot, chatID, session, stateTransferRecipient, text, "HTML", kb)
+}
+
+func handleTransferConfirm(bot *tgbotapi.BotAPI, cq *tgbotapi.CallbackQuery, session *UserSession) {
+	chatID := cq.Message.Chat.ID
+	fromID := strconv.FormatInt(cq.From.ID, 10)
+	if session.TransferDays <= 0 || session.TransferTo == "" {
+		ackCallback(bot, cq, "Перевод устарел, начните заново: /transfer")
+		return
+	}
+
+	record, err := sqliteClient.TransferDays(fromID, session.TransferTo, session.TransferDays, time.Now(), &transferLimits, "")
+	session.TransferDays = 0
+	session.TransferTo = ""
+	if err != nil {
+		log.Printf("TransferDays error: %v", err)
+		_ = updateSessionText(bot, chatID, session, stateMenu, fmt.Sprintf("❌ Перевод не выполнен: %v.", err), "HTML", singleBackKeyboard("nav_menu"))
+		ackCallback(bot, cq, "")
+		return
+	}
+
+	afterTransfer(record)
+	_ = updateSessionText(bot, chatID, session, stateMenu, fmt.Sprintf("✅ Переведено %d дней пользователю <code>%s</code>.", record.Days, record.ToID), "HTML", singleBackKeyboard("nav_menu"))
+	notifyTransfer(bot, record)
+	notifyAdmins(bot, eventTransfer, fmt.Sprintf("🔄 Перевод %d дней: id:%s → id:%s", record.Days, record.FromID, record.ToID), cq.From.UserName, cq.From.ID)
+	ackCallback(bot, cq, "")
+}
+
+// afterTransfer приводит доступ в соответствие с новыми балансами обеих сторон
+func afterTransfer(record sqlite.TransferRecord) {
+	if days, _ := sqliteClient.GetDays(record.FromID); days <= 0 {
+		scheduleRevokeUser(record.FromID)
+	}
+	if user, err := sqliteClient.GetUser(record.ToID); err == nil && !user.Frozen() {
+		if days, _ := sqliteClient.GetDays(record.ToID); days > 0 {
+			scheduleUnrevokeUser(record.ToID)
+		}
+	}
+}
+
+func notifyTransfer(bot *tgbotapi.BotAPI, record sqlite.TransferRecord) {
+	if fromChatID, err := strconv.ParseInt(record.FromID, 10, 64); err == nil {
+		text := fmt.Sprintf("🔄 С вашего баланса переведено %d дней пользователю %s.", record.Days, record.ToID)
+		if record.Migration {
+			text = fmt.Sprintf("🔄 Ваш аккаунт перенесён на Telegram ID %s.", record.ToID)
+		}
+		bot.Send(tgbotapi.NewMessage(fromChatID, text))
+	}
+	if toChatID, err := strconv.ParseInt(record.ToID, 10, 64); err == nil {
+		text := fmt.Sprintf("🎉 Вам переведено %d дней от пользователя %s.", record.Days, record.FromID)
+		if record.Migration {
+			text = fmt.Sprintf("🔄 На ваш аккаунт перенесён аккаунт %s: баланс %d дней и VPN-конфигурации. Старые файлы .ovpn продолжают работать.", record.FromID, record.Days)
+		}
+		bot.Send(tgbotapi.NewMessage(toChatID, text))
+	}
+}
+
+// handleAdminTransferCommand — /transfer_force FROM TO DAYS, перевод без ограничений
+func handleAdminTransferCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message) {
+	if !isAdmin(msg.From.ID) {
+		return
+	}
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 3 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /transfer_force FROM TO DAYS"))
+		return
+	}
+	days, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || days <= 0 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "❌ DAYS должно быть положительным числом"))
+		return
+	}
+
+	record, err := sqliteClient.TransferDays(args[0], args[1], days, time.Now(), nil, strconv.FormatInt(msg.From.ID, 10))
+	if err != nil {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Перевод не выполнен: %v", err)))
+		return
+	}
+	afterTransfer(record)
+	notifyTransfer(bot, record)
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Переведено %d дней: %s → %s", record.Days, record.FromID, record.ToID)))
+}
+
+// handleMigrateCommand — /migrate FROM TO, перенос аккаунта вместе с сертификатами
+func handleMigrateCommand(bot *tgbotapi.BotAPI, msg *tgbotapi.Message, pfsenseClient *pfsense.PfSenseClient) {
+	if !isAdmin(msg.From.ID) {
+		return
+	}
+	args := strings.Fields(msg.CommandArguments())
+	if len(args) != 2 {
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, "Использование: /migrate FROM TO"))
+		return
+	}
+	fromID, toID := args[0], args[1]
+
+	record, err := sqliteClient.MigrateAccount(fromID, toID, time.Now(), strconv.FormatInt(msg.From.ID, 10))
+	if err != nil {
+		if !errors.Is(err, sqlite.ErrMigrationTargetBusy) && !errors.Is(err, sqlite.ErrMigrationTargetInFamily) && !errors.Is(err, sqlite.ErrTransferSelf) {
+			log.Printf("MigrateAccount error: %v", err)
+		}
+		bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("❌ Перенос не выполнен: %v", err)))
+		return
+	}
+
+	// Сертификаты открепляем от старого пользователя pfSense и прикрепляем к новому
+	if pfUserID, exists := pfsenseClient.IsUserExist(fromID); exists {
+		if err := pfsenseClient.SetUserCertificates(pfUserID, []string{}); err != nil {
+			log.Printf("detach certificates of %s error: %v", fromID, err)
+		}
+	}
+	if _, _, _, err := ensureUserCertificate(pfsenseClient, toID); err != nil {
+		log.Printf("ensureUserCertificate for migrated user %s error: %v", toID, err)
+	}
+	afterTransfer(record)
+	if days, _ := sqliteClient.GetDays(toID); days <= 0 {
+		scheduleRevokeUser(toID)
+	}
+
+	notifyTransfer(bot, record)
+	bot.Send(tgbotapi.NewMessage(msg.Chat.ID, fmt.Sprintf("✅ Аккаунт %s перенесён на %s (%d дней, сертификаты перенесены)", fromID, toID, record.Days)))
+}